@@ -0,0 +1,40 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// CaptureDrops saves the samples of the packets dropped during the duration to the pcapng file out
+func (app *ClientAPP) CaptureDrops(duration string, out string) (string, error) {
+	route := app.url("/capture") + "?" + url.Values{"duration": {duration}}.Encode()
+	resp, err := app.httpClient().Get(route)
+	if err != nil {
+		return "", errors.New("Error in sending GET request -> " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var message ErrorStatusMessage
+		if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+			return "", errors.New("Bad Json Returned from the server -> " + err.Error())
+		}
+		return "", errors.New(message.Message)
+	}
+	file, err := os.Create(out)
+	if err != nil {
+		return "", errors.New("cannot create the capture file -> " + err.Error())
+	}
+	written, err := io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.New("cannot write the capture file -> " + err.Error())
+	}
+	return fmt.Sprintf("%d bytes of dropped packet samples saved to %s", written, out), nil
+}
@@ -1,17 +1,92 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type ClientAPP struct {
 	ServerIP   string
 	ServerPort string
+	// Token is sent as the bearer token of every request when it is not empty
+	Token string
+	// TLS makes the client use https with this configuration, see TLSConfig
+	TLS *tls.Config
+}
+
+// tokenTransport adds the bearer token to the requests
+type tokenTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (transport tokenTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.Header.Set("Authorization", "Bearer "+transport.token)
+	return transport.next.RoundTrip(request)
+}
+
+// TLSConfig returns the TLS configuration of the client, the server certificate is verified with the CA
+// bundle or else with the system roots, and the client certificate is sent when it is set
+func TLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.New("cannot read the CA bundle -> " + err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no PEM certificate found in " + caFile)
+		}
+		config.RootCAs = pool
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("the client certificate and key should be passed together")
+	}
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.New("cannot load the client certificate -> " + err.Error())
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// url returns the address of the route on the goxdp service
+func (app *ClientAPP) url(route string) string {
+	scheme := "http"
+	if app.TLS != nil {
+		scheme = "https"
+	}
+	return (&url.URL{Scheme: scheme, Host: net.JoinHostPort(app.ServerIP, app.ServerPort), Path: route}).String()
+}
+
+// httpClient returns the client used for the requests to the goxdp service
+func (app *ClientAPP) httpClient() *http.Client {
+	if app.Token == "" && app.TLS == nil {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = app.TLS
+	if app.Token == "" {
+		return &http.Client{Transport: transport}
+	}
+	return &http.Client{Transport: tokenTransport{token: app.Token, next: transport}}
 }
 
 type ErrorStatusMessage struct {
@@ -29,7 +104,7 @@ func (app *ClientAPP) LoadXDP(interfaces string, mode string) (string, error) {
 		return "", errors.New("cannot marshal json data -> " + err.Error())
 	}
 	requestBody := bytes.NewBuffer(postBody)
-	resp, err := http.Post("http://"+app.ServerIP+":"+app.ServerPort+"/load", "application/json", requestBody)
+	resp, err := app.httpClient().Post(app.url("/load"), "application/json", requestBody)
 	if err != nil {
 		return "", errors.New("Error in sending POST request -> " + err.Error())
 	}
@@ -57,7 +132,7 @@ func (app *ClientAPP) UnloadXDP(interfaces string) (string, error) {
 		return "", errors.New("cannot marshal json data -> " + err.Error())
 	}
 	requestBody := bytes.NewBuffer(postBody)
-	resp, err := http.Post("http://"+app.ServerIP+":"+app.ServerPort+"/unload", "application/json", requestBody)
+	resp, err := app.httpClient().Post(app.url("/unload"), "application/json", requestBody)
 	if err != nil {
 		return "", errors.New("Error in sending POST request -> " + err.Error())
 	}
@@ -77,28 +152,52 @@ func (app *ClientAPP) UnloadXDP(interfaces string) (string, error) {
 	}
 }
 
-func (app *ClientAPP) BlockXDP(action string, target string, timeout uint) (string, error) {
+// Optional rule details sent with the block action
+type RuleOptions struct {
+	Mode     string `json:"mode,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	SrcPort  string `json:"src_port,omitempty"`
+	DstPort  string `json:"dst_port,omitempty"`
+	Pps      uint64 `json:"pps,omitempty"`
+	Bps      uint64 `json:"bps,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+}
+
+func (app *ClientAPP) BlockXDP(action string, target string, timeout uint, options RuleOptions) (string, error) {
 	//Encode the data
-	postBody, err := json.Marshal(map[string]any{
-		"action":  action,
-		"target":  target,
-		"timeout": timeout,
-	})
+	postBody, err := json.Marshal(struct {
+		Action  string `json:"action"`
+		Target  string `json:"target"`
+		Timeout uint   `json:"timeout"`
+		RuleOptions
+	}{action, target, timeout, options})
 	if err != nil {
 		return "", errors.New("cannot marshal json data -> " + err.Error())
 	}
 	requestBody := bytes.NewBuffer(postBody)
-	resp, err := http.Post("http://"+app.ServerIP+":"+app.ServerPort+"/block", "application/json", requestBody)
+	resp, err := app.httpClient().Post(app.url("/block"), "application/json", requestBody)
 	if err != nil {
 		return "", errors.New("Error in sending POST request -> " + err.Error())
 	}
 	defer resp.Body.Close()
 
 	if resp.Status == "200 OK" {
+		//the note tells when the rule is shadowed by a broader rule or can be merged
+		var output struct {
+			Note string `json:"note"`
+		}
+		json.NewDecoder(resp.Body).Decode(&output)
+		note := ""
+		if output.Note != "" {
+			note = ", the rule is " + output.Note + ", POST /rules/optimize compacts the blocked rules"
+		}
 		if action == "allow" {
 			return "target is allowed successfully", nil
+		} else if action == "ratelimit" {
+			return "target is rate limited successfully" + note, nil
 		} else {
-			return "target is blocked successfully", nil
+			return "target is blocked successfully" + note, nil
 		}
 	} else {
 		var errorMessage ErrorStatusMessage
@@ -112,6 +211,105 @@ func (app *ClientAPP) BlockXDP(action string, target string, timeout uint) (stri
 	}
 }
 
+// BatchRule is a single rule of the batch block request
+type BatchRule struct {
+	Target  string `json:"target"`
+	Action  string `json:"action"`
+	Timeout uint   `json:"timeout"`
+	RuleOptions
+}
+
+type batchResult struct {
+	Target  string `json:"target"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+type batchOutput struct {
+	Applied int           `json:"applied"`
+	Failed  int           `json:"failed"`
+	Results []batchResult `json:"results"`
+}
+
+// ReadBatchFile reads one target per line with an optional timeout in seconds after it,
+// empty lines and lines starting with # are skipped
+func ReadBatchFile(path string, action string, timeout uint, options RuleOptions) ([]BatchRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules := []BatchRule{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected a target and an optional timeout", path, line)
+		}
+		rule := BatchRule{Target: fields[0], Action: action, Timeout: timeout, RuleOptions: options}
+		if len(fields) == 2 {
+			value, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid timeout %s", path, line, fields[1])
+			}
+			rule.Timeout = uint(value)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (app *ClientAPP) BlockBatchXDP(rules []BatchRule) (string, error) {
+	//Encode the data
+	postBody, err := json.Marshal(map[string][]BatchRule{
+		"rules": rules,
+	})
+	if err != nil {
+		return "", errors.New("cannot marshal json data -> " + err.Error())
+	}
+	requestBody := bytes.NewBuffer(postBody)
+	resp, err := app.httpClient().Post(app.url("/block/batch"), "application/json", requestBody)
+	if err != nil {
+		return "", errors.New("Error in sending POST request -> " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.Status == "200 OK" {
+		var output batchOutput
+		//Parse json body
+		err = json.NewDecoder(resp.Body).Decode(&output)
+		if err != nil {
+			return "", errors.New("Bad Json Returned from the server -> " + err.Error())
+		}
+		var buffer strings.Builder
+		for _, result := range output.Results {
+			//the applied rules carry a message when they are shadowed or can be merged
+			if result.Message != "" {
+				fmt.Fprintf(&buffer, "%s: %s\n", result.Target, result.Message)
+			}
+		}
+		fmt.Fprintf(&buffer, "%d rules applied successfully, %d failed", output.Applied, output.Failed)
+		return buffer.String(), nil
+	} else {
+		var errorMessage ErrorStatusMessage
+		//Parse json body
+		err = json.NewDecoder(resp.Body).Decode(&errorMessage)
+		if err != nil {
+
+			return "", errors.New("Bad Json Returned from the server ->: %v" + err.Error())
+		}
+		return errorMessage.Message, nil
+	}
+}
+
 // Structs for XDP status
 type statusMapJson struct {
 	Target           netip.Addr `json:"target"`
@@ -125,20 +323,73 @@ type statusTimeoutOutput struct {
 	Timeout   string `json:"timeout"`
 	Remaining int    `json:"remaining_time"`
 }
+type statusBlockedOutput struct {
+	Target           string    `json:"target"`
+	Action           string    `json:"action"`
+	Mode             string    `json:"mode"`
+	Pps              uint32    `json:"pps,omitempty"`
+	Bps              uint64    `json:"bps,omitempty"`
+	Protocol         string    `json:"protocol"`
+	SrcPort          string    `json:"src_port"`
+	DstPort          string    `json:"dst_port"`
+	Packets          uint64    `json:"packets"`
+	Bytes            uint64    `json:"bytes"`
+	MonitoredPackets uint64    `json:"monitored_packets"`
+	MonitoredBytes   uint64    `json:"monitored_bytes"`
+	ShadowedBy       string    `json:"shadowed_by"`
+	Comment          string    `json:"comment"`
+	Owner            string    `json:"owner"`
+	Origin           string    `json:"origin"`
+	Created          time.Time `json:"created"`
+	Updated          time.Time `json:"updated"`
+}
+type statusRatelimitOutput struct {
+	Target  netip.Addr `json:"target"`
+	Rule    string     `json:"rule"`
+	Passed  uint64     `json:"passed_packets"`
+	Limited uint64     `json:"limited_packets"`
+}
+type verdictCount struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+type verdictOutput struct {
+	Interface   string       `json:"interface"`
+	Ifindex     uint32       `json:"ifindex"`
+	Passed      verdictCount `json:"passed"`
+	DroppedSrc  verdictCount `json:"dropped_src"`
+	DroppedDst  verdictCount `json:"dropped_dst"`
+	DroppedGre  verdictCount `json:"dropped_gre"`
+	Aborted     verdictCount `json:"aborted"`
+	NonIPPassed verdictCount `json:"non_ip_passed"`
+	Monitored   verdictCount `json:"monitored"`
+}
 type statusMapOutput struct {
-	Interfaces []string              `json:"interfaces"`
-	Blocked    []string              `json:"blocked"`
-	Timeout    []statusTimeoutOutput `json:"timeout"`
-	Status     []statusMapJson       `json:"stats"`
+	Interfaces  []string                `json:"interfaces"`
+	Blocked     []string                `json:"blocked"`
+	Rules       []statusBlockedOutput   `json:"rules"`
+	Allowed     []string                `json:"allowed"`
+	Ratelimited []statusRatelimitOutput `json:"ratelimited"`
+	Timeout     []statusTimeoutOutput   `json:"timeout"`
+	Status      []statusMapJson         `json:"stats"`
+	Verdicts    []verdictOutput         `json:"verdicts"`
+	Monitor     bool                    `json:"monitor"`
 }
 
 func (app *ClientAPP) StatusXDP() (string, error) {
 
-	resp, err := http.Get("http://" + app.ServerIP + ":" + app.ServerPort + "/status")
+	resp, err := app.httpClient().Get(app.url("/status"))
 	if err != nil {
 		return "", errors.New("Error in sending GET request -> " + err.Error())
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var message ErrorStatusMessage
+		if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+			return "", errors.New("Bad Json Returned from the server -> " + err.Error())
+		}
+		return "", errors.New(message.Message)
+	}
 	var message statusMapOutput
 	//Parse json body
 	err = json.NewDecoder(resp.Body).Decode(&message)
@@ -153,16 +404,83 @@ func (app *ClientAPP) StatusXDP() (string, error) {
 	}
 	//Print blocked IP addresses
 	outMsg += "\nBlocked IP address are:\n"
-	for index, value := range message.Blocked {
+	if message.Monitor {
+		outMsg += "Every rule is in monitor mode, the matched packets are counted as monitored and passed\n"
+	}
+	outMsg += fmt.Sprintf("%-4s %-43s %-10s %-8s %-10s %-12s %-12s %-25s %-16s %-16s %-18s %-18s\n", "No", "IP Address", "Action", "Mode", "Protocol", "Src Port", "Dst Port", "Rate", "Dropped packets", "Dropped bytes", "Monitored packets", "Monitored bytes")
+	for index, value := range message.Rules {
+		rate := ""
+		if value.Action == "ratelimit" {
+			rate = fmt.Sprintf("%d pps %d bps", value.Pps, value.Bps)
+		}
+		outMsg += fmt.Sprintf(
+			"%-4d %-43s %-10s %-8s %-10s %-12s %-12s %-25s %-16d %-16d %-18d %-18d\n",
+			index+1,
+			value.Target,
+			value.Action,
+			value.Mode,
+			value.Protocol,
+			value.SrcPort,
+			value.DstPort,
+			rate,
+			value.Packets,
+			value.Bytes,
+			value.MonitoredPackets,
+			value.MonitoredBytes,
+		)
+	}
+
+	//Print the rules that a broader rule already handles
+	shadowed := ""
+	for _, value := range message.Rules {
+		if value.ShadowedBy != "" {
+			shadowed += fmt.Sprintf("\t%s is shadowed by %s\n", value.Target, value.ShadowedBy)
+		}
+	}
+	if shadowed != "" {
+		outMsg += "\nShadowed rules, the optimize action removes them:\n" + shadowed
+	}
+
+	//Print who blocked the IP addresses and why
+	outMsg += "\nBlocked IP addresses' details:\n"
+	outMsg += fmt.Sprintf("%-4s %-43s %-8s %-20s %-20s %-20s %s\n", "No", "IP Address", "Origin", "Owner", "Created", "Updated", "Comment")
+	for index, value := range message.Rules {
+		created, updated := "-", "-"
+		if !value.Created.IsZero() {
+			created = value.Created.Local().Format("2006-01-02 15:04:05")
+			updated = value.Updated.Local().Format("2006-01-02 15:04:05")
+		}
+		outMsg += fmt.Sprintf(
+			"%-4d %-43s %-8s %-20s %-20s %-20s %s\n",
+			index+1,
+			value.Target,
+			value.Origin,
+			value.Owner,
+			created,
+			updated,
+			value.Comment,
+		)
+	}
+
+	//Print rate limited sources
+	outMsg += "\nRate limited sources:\n"
+	outMsg += fmt.Sprintf("%-4s %-39s %-43s %-20s %-20s\n", "No", "IP Address", "Rule", "Passed packets", "Limited packets")
+	for index, value := range message.Ratelimited {
+		outMsg += fmt.Sprintf("%-4d %-39s %-43s %-20d %-20d\n", index+1, value.Target, value.Rule, value.Passed, value.Limited)
+	}
+
+	//Print allowed IP addresses
+	outMsg += "\nAllowed IP address are:\n"
+	for index, value := range message.Allowed {
 		outMsg += fmt.Sprintf("\t%d- %s\n", index+1, value)
 	}
 
 	//Print Timeout table
 	outMsg += "\nFiltered IP addresses' timeouts:\n"
-	outMsg += fmt.Sprintf("%-4s %-25s %-20s %-15s\n", "No", "IP Address", "Timeout", "Remaining Time")
+	outMsg += fmt.Sprintf("%-4s %-43s %-20s %-15s\n", "No", "IP Address", "Timeout", "Remaining Time")
 	for index, value := range message.Timeout {
 		outMsg += fmt.Sprintf(
-			"%-4d %-25s %-20s %-15ds\n",
+			"%-4d %-43s %-20s %-15ds\n",
 			index+1,
 			value.Target,
 			value.Timeout,
@@ -172,10 +490,10 @@ func (app *ClientAPP) StatusXDP() (string, error) {
 
 	//Print stats table
 	outMsg += "\nFiltered IP addresses' status:\n"
-	outMsg += fmt.Sprintf("%-4s %-28s %-40s %-40s\n", "No", "IP Address", "Source filter", "Destination filter")
+	outMsg += fmt.Sprintf("%-4s %-47s %-40s %-40s\n", "No", "IP Address", "Source filter", "Destination filter")
 	for index, value := range message.Status {
 		outMsg += fmt.Sprintf(
-			"%-4d %-20s %24d bytes (%-8d packets) %24d bytes (%-8d packets)\n",
+			"%-4d %-39s %24d bytes (%-8d packets) %24d bytes (%-8d packets)\n",
 			index+1,
 			value.Target,
 			value.Src_size_packets,
@@ -184,11 +502,81 @@ func (app *ClientAPP) StatusXDP() (string, error) {
 			value.Dst_packets,
 		)
 	}
+
+	//Print the verdicts of the interfaces
+	outMsg += "\nPackets per verdict:\n"
+	outMsg += fmt.Sprintf("%-16s %-16s %-16s %-16s %-16s %-16s %-16s %-16s\n", "Interface", "Passed", "Dropped src", "Dropped dst", "Dropped GRE", "Aborted", "Non IP passed", "Monitored")
+	for _, value := range message.Verdicts {
+		outMsg += fmt.Sprintf(
+			"%-16s %-16d %-16d %-16d %-16d %-16d %-16d %-16d\n",
+			value.Interface,
+			value.Passed.Packets,
+			value.DroppedSrc.Packets,
+			value.DroppedDst.Packets,
+			value.DroppedGre.Packets,
+			value.Aborted.Packets,
+			value.NonIPPassed.Packets,
+			value.Monitored.Packets,
+		)
+	}
 	return outMsg, nil
 }
 
+// Structs for the optimize action
+type shadowedRule struct {
+	Target     string `json:"target"`
+	ShadowedBy string `json:"shadowed_by"`
+}
+type mergedRule struct {
+	Target string   `json:"target"`
+	From   []string `json:"from"`
+}
+type optimizeOutput struct {
+	DryRun  bool           `json:"dry_run"`
+	Before  int            `json:"before"`
+	After   int            `json:"after"`
+	Removed []shadowedRule `json:"removed"`
+	Merged  []mergedRule   `json:"merged"`
+	Failed  []batchResult  `json:"failed"`
+}
+
+func (app *ClientAPP) OptimizeXDP(dryRun bool) (string, error) {
+	resp, err := app.httpClient().Post(app.url("/rules/optimize?dry_run="+strconv.FormatBool(dryRun)), "application/json", nil)
+	if err != nil {
+		return "", errors.New("Error in sending POST request -> " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var errorMessage ErrorStatusMessage
+		if err := json.NewDecoder(resp.Body).Decode(&errorMessage); err != nil {
+			return "", errors.New("Bad Json Returned from the server -> " + err.Error())
+		}
+		return "", errors.New(errorMessage.Message)
+	}
+	var output optimizeOutput
+	if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+		return "", errors.New("Bad Json Returned from the server -> " + err.Error())
+	}
+	var buffer strings.Builder
+	for _, value := range output.Removed {
+		fmt.Fprintf(&buffer, "%s removed, shadowed by %s\n", value.Target, value.ShadowedBy)
+	}
+	for _, value := range output.Merged {
+		fmt.Fprintf(&buffer, "%s merged from %s\n", value.Target, strings.Join(value.From, ", "))
+	}
+	for _, result := range output.Failed {
+		fmt.Fprintf(&buffer, "%s: %s\n", result.Target, result.Message)
+	}
+	if output.DryRun {
+		fmt.Fprintf(&buffer, "Dry run, the blocked rules would go from %d to %d", output.Before, output.After)
+	} else {
+		fmt.Fprintf(&buffer, "The blocked rules went from %d to %d, %d failed", output.Before, output.After, len(output.Failed))
+	}
+	return buffer.String(), nil
+}
+
 func (app *ClientAPP) FlushStatusXDP() (string, error) {
-	resp, err := http.Post("http://"+app.ServerIP+":"+app.ServerPort+"/flushstatus", "application/json", nil)
+	resp, err := app.httpClient().Post(app.url("/flushstatus"), "application/json", nil)
 	if err != nil {
 		return "", errors.New("Error in sending POST request -> " + err.Error())
 	}
@@ -209,7 +597,90 @@ func (app *ClientAPP) FlushStatusXDP() (string, error) {
 }
 
 func (app *ClientAPP) FlushBlockedXDP() (string, error) {
-	resp, err := http.Post("http://"+app.ServerIP+":"+app.ServerPort+"/flushblocked", "application/json", nil)
+	resp, err := app.httpClient().Post(app.url("/flushblocked"), "application/json", nil)
+	if err != nil {
+		return "", errors.New("Error in sending POST request -> " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.Status == "200 OK" {
+		return "Flushed successfully", nil
+	} else {
+		var errorMessage ErrorStatusMessage
+		//Parse json body
+		err = json.NewDecoder(resp.Body).Decode(&errorMessage)
+		if err != nil {
+
+			return "", errors.New("Bad Json Returned from the server ->: %v" + err.Error())
+		}
+		return errorMessage.Message, nil
+	}
+}
+
+func (app *ClientAPP) AllowListXDP(target string) (string, error) {
+	//Encode the data
+	postBody, err := json.Marshal(map[string]string{
+		"target": target,
+	})
+	if err != nil {
+		return "", errors.New("cannot marshal json data -> " + err.Error())
+	}
+	requestBody := bytes.NewBuffer(postBody)
+	resp, err := app.httpClient().Post(app.url("/allow-list"), "application/json", requestBody)
+	if err != nil {
+		return "", errors.New("Error in sending POST request -> " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.Status == "200 OK" {
+		return "target is added to the allow list successfully", nil
+	} else {
+		var errorMessage ErrorStatusMessage
+		//Parse json body
+		err = json.NewDecoder(resp.Body).Decode(&errorMessage)
+		if err != nil {
+
+			return "", errors.New("Bad Json Returned from the server ->: %v" + err.Error())
+		}
+		return errorMessage.Message, nil
+	}
+}
+
+func (app *ClientAPP) UnallowListXDP(target string) (string, error) {
+	//Encode the data
+	postBody, err := json.Marshal(map[string]string{
+		"target": target,
+	})
+	if err != nil {
+		return "", errors.New("cannot marshal json data -> " + err.Error())
+	}
+	request, err := http.NewRequest(http.MethodDelete, app.url("/allow-list"), bytes.NewBuffer(postBody))
+	if err != nil {
+		return "", errors.New("cannot create DELETE request -> " + err.Error())
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := app.httpClient().Do(request)
+	if err != nil {
+		return "", errors.New("Error in sending DELETE request -> " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.Status == "200 OK" {
+		return "target is removed from the allow list successfully", nil
+	} else {
+		var errorMessage ErrorStatusMessage
+		//Parse json body
+		err = json.NewDecoder(resp.Body).Decode(&errorMessage)
+		if err != nil {
+
+			return "", errors.New("Bad Json Returned from the server ->: %v" + err.Error())
+		}
+		return errorMessage.Message, nil
+	}
+}
+
+func (app *ClientAPP) FlushAllowedXDP() (string, error) {
+	resp, err := app.httpClient().Post(app.url("/flushallowed"), "application/json", nil)
 	if err != nil {
 		return "", errors.New("Error in sending POST request -> " + err.Error())
 	}
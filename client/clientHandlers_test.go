@@ -0,0 +1,65 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadBatchFile(t *testing.T) {
+	options := RuleOptions{Mode: "monitor", Owner: "ci"}
+	tests := []struct {
+		name    string
+		content string
+		want    []BatchRule
+		err     string
+	}{
+		{name: "empty", content: "", want: []BatchRule{}},
+		{name: "comments and empty lines", content: "# list\n\n   \n#192.0.2.1\n", want: []BatchRule{}},
+		{
+			name:    "default timeout",
+			content: "192.0.2.1\n2001:db8::/32\n",
+			want: []BatchRule{
+				{Target: "192.0.2.1", Action: "block", Timeout: 60, RuleOptions: options},
+				{Target: "2001:db8::/32", Action: "block", Timeout: 60, RuleOptions: options},
+			},
+		},
+		{
+			name:    "timeout per line",
+			content: "  192.0.2.0/24   300  \n10.0.0.1 0\n",
+			want: []BatchRule{
+				{Target: "192.0.2.0/24", Action: "block", Timeout: 300, RuleOptions: options},
+				{Target: "10.0.0.1", Action: "block", Timeout: 0, RuleOptions: options},
+			},
+		},
+		{name: "too many fields", content: "192.0.2.1\n192.0.2.2 60 drop\n", err: ":2: expected a target"},
+		{name: "invalid timeout", content: "192.0.2.1 soon\n", err: ":1: invalid timeout soon"},
+		{name: "negative timeout", content: "192.0.2.1 -5\n", err: ":1: invalid timeout -5"},
+		{name: "timeout too large", content: "192.0.2.1 4294967296\n", err: ":1: invalid timeout"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "batch.txt")
+		if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		rules, err := ReadBatchFile(path, "block", 60, options)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rules, test.want) {
+			t.Errorf("%s: rules %+v, want %+v", test.name, rules, test.want)
+		}
+	}
+	if _, err := ReadBatchFile(filepath.Join(t.TempDir(), "missing.txt"), "block", 60, options); err == nil {
+		t.Error("missing file: expected an error")
+	}
+}
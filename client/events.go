@@ -0,0 +1,84 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// firewallEvent is a single event of the /events stream
+type firewallEvent struct {
+	ID     uint64          `json:"id"`
+	Time   time.Time       `json:"time"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Target string          `json:"target"`
+	State  json.RawMessage `json:"state"`
+	Drops  []struct {
+		Target  string  `json:"target"`
+		Packets uint64  `json:"packets"`
+		Bytes   uint64  `json:"bytes"`
+		Pps     float64 `json:"pps"`
+		Bps     float64 `json:"bps"`
+	} `json:"drops"`
+}
+
+// WatchEvents prints the events of the goxdp service to out as they happen until the stream is closed,
+// types keeps only the events of these comma separated types when it is not empty
+func (app *ClientAPP) WatchEvents(types string, out io.Writer) error {
+	route := app.url("/events")
+	if types != "" {
+		route += "?" + url.Values{"types": {types}}.Encode()
+	}
+	resp, err := app.httpClient().Get(route)
+	if err != nil {
+		return errors.New("Error in sending GET request -> " + err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var message ErrorStatusMessage
+		if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+			return errors.New("Bad Json Returned from the server -> " + err.Error())
+		}
+		return errors.New(message.Message)
+	}
+
+	var lastID uint64
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		//only the data lines are read, the event type and ID are repeated in the JSON
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event firewallEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return errors.New("Bad Json Returned from the server -> " + err.Error())
+		}
+		//the IDs of the events left out by the types filter are missing too
+		if types == "" && lastID != 0 && event.ID > lastID+1 {
+			fmt.Fprintf(out, "%d events were missed\n", event.ID-lastID-1)
+		}
+		lastID = event.ID
+		when := event.Time.Local().Format("2006-01-02 15:04:05")
+		if event.Type == "drops" {
+			for _, value := range event.Drops {
+				fmt.Fprintf(out, "%s %-18s %-43s %d packets %d bytes (%.1f pps %.0f bps)\n",
+					when, event.Type, value.Target, value.Packets, value.Bytes, value.Pps, value.Bps)
+			}
+			continue
+		}
+		fmt.Fprintf(out, "%s %-18s %-43s by %s %s\n", when, event.Type, event.Target, event.Actor, event.State)
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.New("The event stream was interrupted -> " + err.Error())
+	}
+	return errors.New("The event stream was closed by the server")
+}
@@ -6,6 +6,8 @@ require (
 	github.com/cilium/ebpf v0.12.3
 	github.com/go-chi/chi/v5 v5.0.10
 	github.com/prometheus/client_golang v1.17.0
+	golang.org/x/sys v0.14.1-0.20231108175955-e4099bfacb8c
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -18,6 +20,5 @@ require (
 	github.com/prometheus/common v0.44.0 // indirect
 	github.com/prometheus/procfs v0.11.1 // indirect
 	golang.org/x/exp v0.0.0-20231110203233-9a3e6036ecaa // indirect
-	golang.org/x/sys v0.14.1-0.20231108175955-e4099bfacb8c // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 )
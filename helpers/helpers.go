@@ -2,32 +2,68 @@ package helpers
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"net"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"strings"
 )
 
+// ErrorMessage is the JSON body of every error response
+type ErrorMessage struct {
+	Status  int          `json:"status"`
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a single invalid field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
 func Error(response http.ResponseWriter, message string, status int) {
-	http.Error(response, fmt.Sprintf(`{ "status": %d, "message": "%s" }`, status, message), status)
+	writeError(response, ErrorMessage{Status: status, Message: message})
 }
 
-// Check if the IP address is valid or not
+// ValidationError rejects a request body that does not match its schema with the error of every field
+func ValidationError(response http.ResponseWriter, errors []FieldError) {
+	writeError(response, ErrorMessage{Status: http.StatusBadRequest, Message: "Invalid Request Body", Errors: errors})
+}
+
+func writeError(response http.ResponseWriter, message ErrorMessage) {
+	status := message.Status
+	body, err := json.Marshal(message)
+	if err != nil {
+		body = []byte(fmt.Sprintf(`{"status":%d}`, status))
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("X-Content-Type-Options", "nosniff")
+	response.WriteHeader(status)
+	response.Write(body)
+	response.Write([]byte("\n"))
+}
+
+// Check if the IPv4 or IPv6 address is valid or not
 func IpChecker(ip string) (*string, error) {
 	if !strings.Contains(ip, "/") {
-		ip += "/32"
-	}
-	if strings.Contains(ip, ":") {
-		return nil, errors.New("is not an IPv4 address")
+		if strings.Contains(ip, ":") {
+			ip += "/128"
+		} else {
+			ip += "/32"
+		}
 	}
-	_, _, err := net.ParseCIDR(ip)
+	prefix, err := netip.ParsePrefix(ip)
 	if err != nil {
 		return nil, err
 	}
-	// ip=bits.Reverse64(ip)
-
+	//IPv4-mapped IPv6 addresses (::ffff:1.2.3.4) belong to the IPv4 trie, the XDP program never sees them in IPv6 packets
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	ip = prefix.String()
 	return &ip, nil
 }
 
@@ -77,3 +113,74 @@ func Ipv4Reverse(IPv4Addr string) string {
 	return outString
 
 }
+
+// Protocol names accepted by the rules and their IP protocol numbers
+var protocols = map[string]uint8{
+	"tcp":  6,
+	"udp":  17,
+	"icmp": 1,
+	"gre":  47,
+}
+
+// Convert the protocol name to the IP protocol number, an empty name matches any protocol
+func ProtocolChecker(protocol string, ipv6 bool) (uint8, error) {
+	if protocol == "" || protocol == "any" {
+		return 0, nil
+	}
+	number, ok := protocols[strings.ToLower(protocol)]
+	if !ok {
+		return 0, errors.New("unsupported protocol " + protocol + ", available values are tcp, udp, icmp, and gre")
+	}
+	if number == 1 && ipv6 {
+		//ICMPv6
+		return 58, nil
+	}
+	return number, nil
+}
+
+// Convert the IP protocol number back to its name
+func ProtocolName(number uint8) string {
+	if number == 0 {
+		return "any"
+	}
+	if number == 58 {
+		return "icmp"
+	}
+	for name, value := range protocols {
+		if value == number {
+			return name
+		}
+	}
+	return strconv.Itoa(int(number))
+}
+
+// Parse a single port (53) or a port range (1024-2048), an empty string or any matches any port
+func PortChecker(ports string) (uint16, uint16, error) {
+	if ports == "" || ports == "any" {
+		return 0, 0, nil
+	}
+	bits := strings.SplitN(ports, "-", 2)
+	min, err := strconv.ParseUint(bits[0], 10, 16)
+	if err != nil || min == 0 {
+		return 0, 0, errors.New("invalid port " + ports)
+	}
+	max := min
+	if len(bits) == 2 {
+		max, err = strconv.ParseUint(bits[1], 10, 16)
+		if err != nil || max < min {
+			return 0, 0, errors.New("invalid port range " + ports)
+		}
+	}
+	return uint16(min), uint16(max), nil
+}
+
+// Format the port range returned by PortChecker
+func PortRange(min uint16, max uint16) string {
+	if max == 0 {
+		return "any"
+	}
+	if min == max {
+		return strconv.Itoa(int(min))
+	}
+	return strconv.Itoa(int(min)) + "-" + strconv.Itoa(int(max))
+}
@@ -0,0 +1,107 @@
+package helpers
+
+import "testing"
+
+func TestIpChecker(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "192.0.2.1", want: "192.0.2.1/32"},
+		{input: "192.0.2.0/24", want: "192.0.2.0/24"},
+		{input: "2001:db8::1", want: "2001:db8::1/128"},
+		{input: "2001:db8::/32", want: "2001:db8::/32"},
+		{input: "::ffff:1.2.3.4", want: "1.2.3.4/32"},
+		{input: "::ffff:1.2.3.0/120", want: "1.2.3.0/24"},
+		{input: "::ffff:0:0/96", want: "0.0.0.0/0"},
+		{input: "::/64", want: "::/64"},
+		{input: "192.0.2.1/33", wantErr: true},
+		{input: "2001:db8::/129", wantErr: true},
+		{input: "300.0.0.1", wantErr: true},
+		{input: "example.com", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := IpChecker(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("IpChecker(%q) = %q, want an error", test.input, *got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("IpChecker(%q) returned %v", test.input, err)
+			continue
+		}
+		if *got != test.want {
+			t.Errorf("IpChecker(%q) = %q, want %q", test.input, *got, test.want)
+		}
+	}
+}
+
+func TestPortChecker(t *testing.T) {
+	tests := []struct {
+		input    string
+		min, max uint16
+		wantErr  bool
+	}{
+		{input: "", min: 0, max: 0},
+		{input: "any", min: 0, max: 0},
+		{input: "53", min: 53, max: 53},
+		{input: "1024-2048", min: 1024, max: 2048},
+		{input: "65535", min: 65535, max: 65535},
+		{input: "0", wantErr: true},
+		{input: "65536", wantErr: true},
+		{input: "2048-1024", wantErr: true},
+		{input: "53-", wantErr: true},
+		{input: "dns", wantErr: true},
+	}
+	for _, test := range tests {
+		min, max, err := PortChecker(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("PortChecker(%q) = %d-%d, want an error", test.input, min, max)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("PortChecker(%q) returned %v", test.input, err)
+			continue
+		}
+		if min != test.min || max != test.max {
+			t.Errorf("PortChecker(%q) = %d-%d, want %d-%d", test.input, min, max, test.min, test.max)
+		}
+		if test.max != 0 && PortRange(min, max) != test.input {
+			t.Errorf("PortRange(%d, %d) = %q, want %q", min, max, PortRange(min, max), test.input)
+		}
+	}
+}
+
+func TestProtocolChecker(t *testing.T) {
+	tests := []struct {
+		input   string
+		ipv6    bool
+		want    uint8
+		wantErr bool
+	}{
+		{input: "", want: 0},
+		{input: "any", want: 0},
+		{input: "tcp", want: 6},
+		{input: "UDP", want: 17},
+		{input: "icmp", want: 1},
+		{input: "icmp", ipv6: true, want: 58},
+		{input: "gre", want: 47},
+		{input: "sctp", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ProtocolChecker(test.input, test.ipv6)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ProtocolChecker(%q, %v) returned %v", test.input, test.ipv6, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ProtocolChecker(%q, %v) = %d, want %d", test.input, test.ipv6, got, test.want)
+		}
+	}
+}
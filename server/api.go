@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+
+	"github.com/ahsifer/goxdp/helpers"
+	"github.com/cilium/ebpf"
+	"github.com/go-chi/chi/v5"
+)
+
+// Structs used by the /v1 API
+type interfaceOutput struct {
+	Name     string `json:"name"`
+	Mode     string `json:"mode,omitempty"`
+	Attached bool   `json:"attached"`
+}
+type interfaceLoad struct {
+	Mode string `json:"mode" openapi:"required" enum:"nv,skb,hw"`
+}
+type statsOutput struct {
+	Ratelimited []statusRatelimitOutput `json:"ratelimited"`
+	Status      []statusMapJson         `json:"stats"`
+	Verdicts    []verdictOutput         `json:"verdicts"`
+}
+
+// writeJSON writes the value as the JSON body of the response with the given status code
+func (app *Application) writeJSON(response http.ResponseWriter, status int, value any) {
+	finalResponse, err := json.Marshal(value)
+	if err != nil {
+		app.ErrorLog.Println("Unable to parse json data", err)
+		helpers.Error(response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	response.Write(finalResponse)
+}
+
+// rulePrefix parses the CIDR of the /v1/rules/{cidr} routes, the slash of the CIDR may be escaped as %2F
+func rulePrefix(request *http.Request) (netip.Prefix, error) {
+	target, err := url.PathUnescape(chi.URLParam(request, "*"))
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	cidr, err := helpers.IpChecker(target)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return parsePrefix(*cidr)
+}
+
+// list the blocked rules
+func (app *Application) apiRulesList(response http.ResponseWriter, request *http.Request) {
+	rules, err := app.blockedRules()
+	if err != nil {
+		app.InfoLog.Print(err)
+		helpers.Error(response, "Unable to read the blocked LPM maps", http.StatusInternalServerError)
+		return
+	}
+	app.writeJSON(response, http.StatusOK, app.blockedOutput(rules))
+}
+
+// create a blocked rule, an existing rule of the same CIDR is a conflict
+func (app *Application) apiRulesCreate(response http.ResponseWriter, request *http.Request) {
+	var body ruleEntry
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot parse json request -> %v\n", err)
+		helpers.Error(response, "Invalid Request Body", http.StatusBadRequest)
+		return
+	}
+	prefix, rule, err := body.rule()
+	if err != nil {
+		helpers.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	//the XDP program ignores the rule once its timeout is finished
+	rule.ExpiresNs, err = ruleExpiry(body.Timeout)
+	if errors.Is(err, errTimeoutTooLong) {
+		helpers.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		app.ErrorLog.Printf("Cannot read the monotonic clock -> %s", err)
+		helpers.Error(response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	err = app.updatePrefix(prefix, rule, ebpf.UpdateNoExist)
+	if errors.Is(err, ebpf.ErrKeyExist) {
+		current := app.ruleSnapshot(prefix)
+		app.audit(requestActor(request), auditBlock, prefix.String(), current, current, err)
+		helpers.Error(response, "A rule for "+prefix.String()+" already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.audit(requestActor(request), auditBlock, prefix.String(), nil, nil, err)
+		app.InfoLog.Print(err)
+		if errors.Is(err, errRuleTableFull) {
+			helpers.Error(response, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		helpers.Error(response, "Unable to update blocked LPM map", http.StatusInternalServerError)
+		return
+	}
+	app.setRuleMetadata(prefix, originAPI, requestOwner(request, &body.Owner), body.Comment)
+	app.audit(requestActor(request), auditBlock, prefix.String(), nil, app.ruleSnapshot(prefix), nil)
+	if note := app.ruleNotes([]blockedRule{{Prefix: prefix, Rule: rule}})[0]; note != "" {
+		app.InfoLog.Printf("Rule %s is %s, POST /rules/optimize compacts the blocked rules", prefix, note)
+	}
+	app.persistState()
+
+	response.Header().Set("Location", "/v1/rules/"+url.PathEscape(prefix.String()))
+	app.writeJSON(response, http.StatusCreated, app.blockedOutput([]blockedRule{{Prefix: prefix, Rule: rule}})[0])
+}
+
+// show a single blocked rule
+func (app *Application) apiRuleGet(response http.ResponseWriter, request *http.Request) {
+	prefix, err := rulePrefix(request)
+	if err != nil {
+		helpers.Error(response, "Invalid IP address or subnet", http.StatusBadRequest)
+		return
+	}
+	rule, err := app.lookupPrefix(prefix)
+	if errors.Is(err, ebpf.ErrKeyNotExist) {
+		helpers.Error(response, "No rule for "+prefix.String(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.InfoLog.Print(err)
+		helpers.Error(response, "Unable to read the blocked LPM maps", http.StatusInternalServerError)
+		return
+	}
+	app.writeJSON(response, http.StatusOK, app.blockedOutput([]blockedRule{{Prefix: prefix, Rule: rule}})[0])
+}
+
+// change the fields of a blocked rule that are present in the request body, the others are kept
+func (app *Application) apiRuleUpdate(response http.ResponseWriter, request *http.Request) {
+	prefix, err := rulePrefix(request)
+	if err != nil {
+		helpers.Error(response, "Invalid IP address or subnet", http.StatusBadRequest)
+		return
+	}
+	var body blockLoad
+	err = json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot parse json request -> %v\n", err)
+		helpers.Error(response, "Invalid Request Body", http.StatusBadRequest)
+		return
+	}
+	current, err := app.lookupPrefix(prefix)
+	if errors.Is(err, ebpf.ErrKeyNotExist) {
+		helpers.Error(response, "No rule for "+prefix.String(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.InfoLog.Print(err)
+		helpers.Error(response, "Unable to read the blocked LPM maps", http.StatusInternalServerError)
+		return
+	}
+	before := app.ruleSnapshot(prefix)
+
+	//apply the changes on top of the current rule and validate the result
+	entry := ruleEntryOf(prefix, current)
+	if body.Action != nil {
+		entry.Action = *body.Action
+		if entry.Action == "block" {
+			entry.Pps, entry.Bps = 0, 0
+		}
+	}
+	if body.Mode != nil {
+		entry.Mode = *body.Mode
+	}
+	if body.Protocol != nil {
+		entry.Protocol = *body.Protocol
+	}
+	if body.SrcPort != nil {
+		entry.SrcPort = *body.SrcPort
+	}
+	if body.DstPort != nil {
+		entry.DstPort = *body.DstPort
+	}
+	if body.Pps != nil {
+		entry.Pps = *body.Pps
+	}
+	if body.Bps != nil {
+		entry.Bps = *body.Bps
+	}
+	_, rule, err := entry.rule()
+	if err != nil {
+		helpers.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.ExpiresNs = current.ExpiresNs
+	if body.Timeout != nil {
+		rule.ExpiresNs, err = ruleExpiry(*body.Timeout)
+		if errors.Is(err, errTimeoutTooLong) {
+			helpers.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			app.ErrorLog.Printf("Cannot read the monotonic clock -> %s", err)
+			helpers.Error(response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	err = app.updatePrefix(prefix, rule, ebpf.UpdateExist)
+	if errors.Is(err, ebpf.ErrKeyNotExist) {
+		app.audit(requestActor(request), auditUpdate, prefix.String(), nil, nil, err)
+		helpers.Error(response, "No rule for "+prefix.String(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.audit(requestActor(request), auditUpdate, prefix.String(), before, before, err)
+		app.InfoLog.Print(err)
+		helpers.Error(response, "Unable to update blocked LPM map", http.StatusInternalServerError)
+		return
+	}
+
+	//the rule keeps its owner unless it is taken over from a feed or the configuration file
+	metadata := app.ruleMetadataOf([]blockedRule{{Prefix: prefix}})[prefix]
+	owner := metadata.Owner
+	if body.Owner != nil || metadata.Origin != originAPI {
+		owner = requestOwner(request, body.Owner)
+	}
+	comment := metadata.Comment
+	if body.Comment != nil {
+		comment = *body.Comment
+	}
+	app.setRuleMetadata(prefix, originAPI, owner, comment)
+	app.audit(requestActor(request), auditUpdate, prefix.String(), before, app.ruleSnapshot(prefix), nil)
+	app.persistState()
+	app.writeJSON(response, http.StatusOK, app.blockedOutput([]blockedRule{{Prefix: prefix, Rule: rule}})[0])
+}
+
+// remove a blocked rule
+func (app *Application) apiRuleDelete(response http.ResponseWriter, request *http.Request) {
+	prefix, err := rulePrefix(request)
+	if err != nil {
+		helpers.Error(response, "Invalid IP address or subnet", http.StatusBadRequest)
+		return
+	}
+	before := app.ruleSnapshot(prefix)
+	err = app.unblockPrefix(prefix)
+	if errors.Is(err, ebpf.ErrKeyNotExist) {
+		app.audit(requestActor(request), auditUnblock, prefix.String(), nil, nil, err)
+		helpers.Error(response, "No rule for "+prefix.String(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.audit(requestActor(request), auditUnblock, prefix.String(), before, before, err)
+		app.InfoLog.Print(err)
+		helpers.Error(response, "Unable to update blocked LPM map", http.StatusInternalServerError)
+		return
+	}
+	app.deleteRuleMetadata(prefix)
+	app.audit(requestActor(request), auditUnblock, prefix.String(), before, nil, nil)
+	app.persistState()
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// list the interfaces that the XDP program is attached to
+func (app *Application) apiInterfacesList(response http.ResponseWriter, request *http.Request) {
+	output := []interfaceOutput{}
+	for _, name := range app.loadedInterfaces() {
+		mode, _ := app.interfaceMode(name)
+		output = append(output, interfaceOutput{Name: name, Mode: mode, Attached: true})
+	}
+	app.writeJSON(response, http.StatusOK, output)
+}
+
+// show whether the XDP program is attached to the interface
+func (app *Application) apiInterfaceGet(response http.ResponseWriter, request *http.Request) {
+	name := chi.URLParam(request, "name")
+	mode, attached := app.interfaceMode(name)
+	if !attached {
+		helpers.Error(response, "XDP program is not loaded to the interface "+name, http.StatusNotFound)
+		return
+	}
+	app.writeJSON(response, http.StatusOK, interfaceOutput{Name: name, Mode: mode, Attached: true})
+}
+
+// attach the XDP program to the interface with the mode of the request body,
+// the interface is reattached when it is loaded with another mode
+func (app *Application) apiInterfacePut(response http.ResponseWriter, request *http.Request) {
+	name := chi.URLParam(request, "name")
+	var body interfaceLoad
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot parse json request -> %v\n", err)
+		helpers.Error(response, "Invalid Request Body", http.StatusBadRequest)
+		return
+	}
+	if _, ok := xdpModes[body.Mode]; !ok {
+		helpers.Error(response, "Invalid Mode, available values are nv, skb, and hw", http.StatusBadRequest)
+		return
+	}
+	before := app.interfaceSnapshot(name)
+	_, err = app.ensureXDP(name, body.Mode)
+	if !errors.Is(err, errNoInterface) {
+		app.audit(requestActor(request), auditLoad, name, before, app.interfaceSnapshot(name), err)
+	}
+	if errors.Is(err, errNoInterface) {
+		helpers.Error(response, "Interface "+name+" does not exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.ErrorLog.Print(err.Error())
+		helpers.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.writeJSON(response, http.StatusOK, interfaceOutput{Name: name, Mode: body.Mode, Attached: true})
+}
+
+// detach the XDP program from the interface
+func (app *Application) apiInterfaceDelete(response http.ResponseWriter, request *http.Request) {
+	name := chi.URLParam(request, "name")
+	before := app.interfaceSnapshot(name)
+	err := app.detachXDP(name)
+	if !errors.Is(err, errNotLoaded) {
+		app.audit(requestActor(request), auditUnload, name, before, app.interfaceSnapshot(name), err)
+	}
+	if errors.Is(err, errNotLoaded) {
+		helpers.Error(response, "XDP program is not loaded to the interface "+name, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.ErrorLog.Printf("Cannot remove XDP from the interface -> %v\n", err)
+		helpers.Error(response, "Cannot remove XDP from the interface "+name, http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// show the addresses with the most dropped packets and the rate limited sources
+func (app *Application) apiStats(response http.ResponseWriter, request *http.Request) {
+	output := statsOutput{
+		Ratelimited: app.readRatelimitMap(),
+		Status:      app.addressStats(),
+		Verdicts:    app.readVerdictsMap(),
+	}
+	app.writeJSON(response, http.StatusOK, output)
+}
+
+// empty the status maps and the ratelimit token buckets
+func (app *Application) apiStatsFlush(response http.ResponseWriter, request *http.Request) {
+	err := app.flushStatus()
+	app.audit(requestActor(request), auditFlushStatus, "", nil, nil, err)
+	if err != nil {
+		app.InfoLog.Print(err.Error())
+		helpers.Error(response, "Unable to empty the status maps", http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// show the settings that can be changed while the service runs
+func (app *Application) apiSettingsGet(response http.ResponseWriter, request *http.Request) {
+	app.writeJSON(response, http.StatusOK, app.settings())
+}
+
+// change the settings of the request body, the XDP program applies them to the next packets
+func (app *Application) apiSettingsUpdate(response http.ResponseWriter, request *http.Request) {
+	var body settingsPatch
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot parse json request -> %v\n", err)
+		helpers.Error(response, "Invalid Request Body", http.StatusBadRequest)
+		return
+	}
+	if body.Monitor == nil {
+		helpers.Error(response, "monitor is required", http.StatusBadRequest)
+		return
+	}
+	before := app.settings()
+	err = app.setMonitor(*body.Monitor)
+	app.audit(requestActor(request), auditSettings, "monitor", before, app.settings(), err)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot write the settings map -> %v", err)
+		helpers.Error(response, "Unable to change the settings", http.StatusInternalServerError)
+		return
+	}
+	if before.Monitor != *body.Monitor {
+		app.InfoLog.Printf("Monitor mode of every rule is set to %v by %s", *body.Monitor, requestActor(request))
+	}
+	app.writeJSON(response, http.StatusOK, app.settings())
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ahsifer/goxdp/helpers"
+)
+
+// actors of the changes that are not made through the API
+const (
+	actorTimeoutWorker = "timeout-worker"
+	actorConfig        = "config"
+	actorFeedPrefix    = "feed:"
+)
+
+// actions of the audit records
+const (
+	auditLoad         = "load"
+	auditUnload       = "unload"
+	auditBlock        = "block"
+	auditUpdate       = "update"
+	auditUnblock      = "unblock"
+	auditExpire       = "expire"
+	auditFlushBlocked = "flushblocked"
+	auditFlushStatus  = "flushstatus"
+	auditAllowList    = "allowlist"
+	auditUnallowList  = "unallowlist"
+	auditFlushAllowed = "flushallowed"
+	auditSettings     = "settings"
+)
+
+// results of the audited changes
+const (
+	auditOK    = "ok"
+	auditError = "error"
+)
+
+// default and largest number of records returned by GET /audit
+const (
+	auditDefaultLimit = 1000
+	auditMaxLimit     = 100000
+)
+
+// auditRecord is a single line of the audit log
+type auditRecord struct {
+	Time   time.Time       `json:"time"`
+	Actor  string          `json:"actor"`
+	Action string          `json:"action"`
+	Target string          `json:"target,omitempty"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	Result string          `json:"result" enum:"ok,error"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// auditLog appends the records to a JSONL file that is rotated once it reaches maxSize,
+// the rotated files are renamed to path.1 (the newest) up to path.maxBackups
+type auditLog struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	lock       sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// openAuditLog opens the audit log for appending, the records already written are never changed
+func openAuditLog(path string, maxSize int64, maxBackups int) (*auditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	log := &auditLog{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := log.open(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+func (log *auditLog) open() error {
+	file, err := os.OpenFile(log.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	log.file = file
+	log.size = info.Size()
+	return nil
+}
+
+// rotate shifts the rotated files by one, drops the oldest one, and starts a new file
+func (log *auditLog) rotate() error {
+	if err := log.file.Close(); err != nil {
+		return err
+	}
+	for i := log.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(log.path+"."+strconv.Itoa(i), log.path+"."+strconv.Itoa(i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if log.maxBackups > 0 {
+		if err := os.Rename(log.path, log.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(log.path); err != nil {
+		return err
+	}
+	return log.open()
+}
+
+// write appends the record as one line
+func (log *auditLog) write(record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	if log.size > 0 && log.size+int64(len(line)) > log.maxSize {
+		if err := log.rotate(); err != nil {
+			return fmt.Errorf("cannot rotate the audit log -> %w", err)
+		}
+	}
+	n, err := log.file.Write(line)
+	log.size += int64(n)
+	return err
+}
+
+// close closes the current file of the audit log
+func (log *auditLog) close() error {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	return log.file.Close()
+}
+
+// read returns the records of the rotated and current files, the oldest first, that match the filter
+func (log *auditLog) read(match func(auditRecord) bool) ([]auditRecord, error) {
+	//the files are not rotated while they are read
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	var records []auditRecord
+	for i := log.maxBackups; i >= 0; i-- {
+		path := log.path
+		if i > 0 {
+			path += "." + strconv.Itoa(i)
+		}
+		file, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16<<20)
+		for scanner.Scan() {
+			var record auditRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				continue
+			}
+			if match(record) {
+				records = append(records, record)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// requestActor returns who made the request, the name of its API token or client certificate,
+// or else the address it came from as set by the RealIP middleware
+func requestActor(request *http.Request) string {
+	if identity, ok := requestIdentity(request); ok {
+		return identity
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// audit writes a record of the change to the audit log and publishes the successful changes to the
+// subscribers of GET /events, before and after are the state of the target around the change and are
+// left out when nil, failures to write are logged and never fail the change
+func (app *Application) audit(actor string, action string, target string, before any, after any, err error) {
+	record := auditRecord{Time: time.Now().UTC(), Actor: actor, Action: action, Target: target, Result: auditOK}
+	record.Before = auditValue(before)
+	record.After = auditValue(after)
+	if err != nil {
+		record.Result = auditError
+		record.Error = err.Error()
+	} else {
+		app.publishChange(actor, action, target, record.Before, record.After)
+	}
+	if app.auditLog == nil {
+		return
+	}
+	if err := app.auditLog.write(record); err != nil {
+		app.ErrorLog.Printf("Cannot write the audit log -> %v", err)
+	}
+}
+
+// auditValue encodes the state of a target, typed nil pointers are left out too
+func auditValue(value any) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil || string(encoded) == "null" {
+		return nil
+	}
+	return encoded
+}
+
+// ruleSnapshot returns the rule of the prefix with its metadata for the audit log, nil when the prefix has no rule
+func (app *Application) ruleSnapshot(prefix netip.Prefix) *ruleEntry {
+	rule, err := app.lookupPrefix(prefix)
+	if err != nil {
+		return nil
+	}
+	entry := ruleEntryOf(prefix, rule)
+	metadata := app.ruleMetadataOf([]blockedRule{{Prefix: prefix}})[prefix]
+	entry.Comment = metadata.Comment
+	entry.Owner = metadata.Owner
+	return &entry
+}
+
+// allowedEntry is the state of an allow list entry in the audit log
+type allowedEntry struct {
+	Target string `json:"target"`
+}
+
+// allowedSnapshot returns the allow list entry of the prefix for the audit log, nil when the prefix is not in the allow list
+func (app *Application) allowedSnapshot(prefix netip.Prefix) *allowedEntry {
+	prefixes, err := app.allowedPrefixes()
+	if err != nil {
+		return nil
+	}
+	for _, value := range prefixes {
+		if value == prefix {
+			return &allowedEntry{Target: prefix.String()}
+		}
+	}
+	return nil
+}
+
+// interfaceSnapshot returns the attachment of the interface for the audit log, nil when the program is not attached to it
+func (app *Application) interfaceSnapshot(name string) *interfaceOutput {
+	mode, attached := app.interfaceMode(name)
+	if !attached {
+		return nil
+	}
+	return &interfaceOutput{Name: name, Mode: mode, Attached: true}
+}
+
+// auditFilter returns the filter of the GET /audit query, a target that is an IP address or subnet
+// matches the records of every overlapping subnet and any other target matches exactly
+func auditFilter(request *http.Request) (func(auditRecord) bool, error) {
+	query := request.URL.Query()
+	var since, until time.Time
+	var err error
+	if value := query.Get("since"); value != "" {
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, errors.New("since should be an RFC 3339 time")
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		if until, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, errors.New("until should be an RFC 3339 time")
+		}
+	}
+	target := query.Get("target")
+	var targetPrefix netip.Prefix
+	if target != "" {
+		if cidr, err := helpers.IpChecker(target); err == nil {
+			targetPrefix, _ = parsePrefix(*cidr)
+		}
+	}
+	actor := query.Get("actor")
+	action := query.Get("action")
+	return func(record auditRecord) bool {
+		if !since.IsZero() && record.Time.Before(since) {
+			return false
+		}
+		if !until.IsZero() && record.Time.After(until) {
+			return false
+		}
+		if actor != "" && record.Actor != actor {
+			return false
+		}
+		if action != "" && record.Action != action {
+			return false
+		}
+		if target == "" {
+			return true
+		}
+		if targetPrefix.IsValid() {
+			prefix, err := netip.ParsePrefix(record.Target)
+			return err == nil && prefix.Overlaps(targetPrefix)
+		}
+		return record.Target == target
+	}, nil
+}
+
+// show the records of the audit log that match the query, the newest ones when there are more than the limit
+func (app *Application) auditQuery(response http.ResponseWriter, request *http.Request) {
+	if app.auditLog == nil {
+		helpers.Error(response, "The audit log is disabled", http.StatusNotFound)
+		return
+	}
+	match, err := auditFilter(request)
+	if err != nil {
+		helpers.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := auditDefaultLimit
+	if value := request.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > auditMaxLimit {
+			helpers.Error(response, "limit should be a number between 1 and "+strconv.Itoa(auditMaxLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	records, err := app.auditLog.read(match)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot read the audit log -> %v", err)
+		helpers.Error(response, "Unable to read the audit log", http.StatusInternalServerError)
+		return
+	}
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	if records == nil {
+		records = []auditRecord{}
+	}
+	app.writeJSON(response, http.StatusOK, records)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ahsifer/goxdp/helpers"
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// scopes granted to the tokens, a route needs the scope documented in routeDocs
+const (
+	scopeRulesRead       = "rules:read"
+	scopeRulesWrite      = "rules:write"
+	scopeInterfacesRead  = "interfaces:read"
+	scopeInterfacesWrite = "interfaces:write"
+	scopeStatsRead       = "stats:read"
+	scopeStatsWrite      = "stats:write"
+	scopeAuditRead       = "audit:read"
+	scopeEventsRead      = "events:read"
+	scopeCaptureRead     = "capture:read"
+	// scopeAll grants every scope
+	scopeAll = "*"
+)
+
+var knownScopes = map[string]bool{
+	scopeRulesRead: true, scopeRulesWrite: true,
+	scopeInterfacesRead: true, scopeInterfacesWrite: true,
+	scopeStatsRead: true, scopeStatsWrite: true,
+	scopeAuditRead: true, scopeEventsRead: true, scopeCaptureRead: true,
+	scopeAll: true,
+}
+
+// prefix of the token hashes in the tokens file
+const tokenHashPrefix = "sha256:"
+
+// tokensFile is the content of the tokens file, only the SHA-256 hashes of the tokens are stored
+type tokensFile struct {
+	Tokens []apiToken `yaml:"tokens"`
+}
+
+// apiToken is a single token of the tokens file, its name is the identity of the requests made with it
+type apiToken struct {
+	Name   string   `yaml:"name"`
+	Hash   string   `yaml:"hash"`
+	Scopes []string `yaml:"scopes"`
+}
+
+type contextKey string
+
+// identityKey holds the name of the token of the request in its context
+const identityKey contextKey = "identity"
+
+// hashToken returns the hash of the token as stored in the tokens file
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random token
+func newToken() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return "goxdp_" + hex.EncodeToString(random), nil
+}
+
+// loadTokens reads and validates the tokens file and returns the tokens keyed by their hash
+func loadTokens(path string) (map[string]apiToken, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file tokensFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&file)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	tokens := map[string]apiToken{}
+	for _, token := range file.Tokens {
+		if token.Name == "" {
+			return nil, errors.New("every token needs a name")
+		}
+		hash := strings.ToLower(token.Hash)
+		digest, err := hex.DecodeString(strings.TrimPrefix(hash, tokenHashPrefix))
+		if !strings.HasPrefix(hash, tokenHashPrefix) || err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid hash of the token %s, expected sha256:<64 hex digits>", token.Name)
+		}
+		for _, scope := range token.Scopes {
+			if !knownScopes[scope] {
+				return nil, fmt.Errorf("unknown scope %s of the token %s", scope, token.Name)
+			}
+		}
+		if _, ok := tokens[hash]; ok {
+			return nil, fmt.Errorf("the token %s has the same hash as another token", token.Name)
+		}
+		tokens[hash] = token
+	}
+	return tokens, nil
+}
+
+// reloadTokens replaces the tokens accepted by the private router with the ones of the tokens file
+func (app *Application) reloadTokens() error {
+	tokens, err := loadTokens(app.TokensPath)
+	if err != nil {
+		return err
+	}
+	app.tokensLock.Lock()
+	app.tokens = tokens
+	app.tokensLock.Unlock()
+	app.InfoLog.Printf("Loaded %d API tokens from %s", len(tokens), app.TokensPath)
+	return nil
+}
+
+// hasScope reports whether the token grants the scope
+func (token apiToken) hasScope(scope string) bool {
+	for _, granted := range token.Scopes {
+		if granted == scope || granted == scopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate rejects the requests without a valid bearer token with 401 and the requests whose token
+// lacks the scope of the route with 403, it does nothing when no tokens file is configured
+func (app *Application) authenticate(mux *chi.Mux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			if app.TokensPath == "" {
+				next.ServeHTTP(response, request)
+				return
+			}
+			routePath := request.URL.RawPath
+			if routePath == "" {
+				routePath = request.URL.Path
+			}
+			rctx := chi.NewRouteContext()
+			if !mux.Match(rctx, request.Method, path.Clean(routePath)) {
+				//unknown routes get their 404 or 405
+				next.ServeHTTP(response, request)
+				return
+			}
+			scope := routeDocs[request.Method+" "+rctx.RoutePattern()].Scope
+			if scope == "" {
+				next.ServeHTTP(response, request)
+				return
+			}
+
+			authorization := request.Header.Get("Authorization")
+			bearer, found := strings.CutPrefix(authorization, "Bearer ")
+			if !found || bearer == "" {
+				response.Header().Set("WWW-Authenticate", `Bearer realm="goxdp"`)
+				helpers.Error(response, "Missing bearer token", http.StatusUnauthorized)
+				return
+			}
+			app.tokensLock.RLock()
+			token, ok := app.tokens[hashToken(strings.TrimSpace(bearer))]
+			app.tokensLock.RUnlock()
+			if !ok {
+				app.ErrorLog.Printf("Invalid API token from %s for %s %s", request.RemoteAddr, request.Method, request.URL.Path)
+				response.Header().Set("WWW-Authenticate", `Bearer realm="goxdp", error="invalid_token"`)
+				helpers.Error(response, "Invalid bearer token", http.StatusUnauthorized)
+				return
+			}
+			if !token.hasScope(scope) {
+				app.ErrorLog.Printf("API token %s lacks the scope %s for %s %s", token.Name, scope, request.Method, request.URL.Path)
+				response.Header().Set("WWW-Authenticate", `Bearer realm="goxdp", error="insufficient_scope", scope="`+scope+`"`)
+				helpers.Error(response, "The token does not grant the scope "+scope, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(response, request.WithContext(context.WithValue(request.Context(), identityKey, token.Name)))
+		})
+	}
+}
+
+// requestIdentity returns the name of the token the request was authenticated with
+func requestIdentity(request *http.Request) (string, bool) {
+	identity, ok := request.Context().Value(identityKey).(string)
+	return identity, ok && identity != ""
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{scopes: []string{scopeRulesRead}, scope: scopeRulesRead, want: true},
+		{scopes: []string{scopeRulesRead}, scope: scopeRulesWrite, want: false},
+		{scopes: []string{scopeStatsRead, scopeRulesWrite}, scope: scopeRulesWrite, want: true},
+		{scopes: []string{scopeAll}, scope: scopeCaptureRead, want: true},
+		{scopes: nil, scope: scopeStatsRead, want: false},
+	}
+	for _, test := range tests {
+		token := apiToken{Name: "test", Scopes: test.scopes}
+		if got := token.hasScope(test.scope); got != test.want {
+			t.Errorf("hasScope(%v, %s) = %v, want %v", test.scopes, test.scope, got, test.want)
+		}
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	hash := hashToken("secret")
+	tests := []struct {
+		name    string
+		file    string
+		want    int
+		wantErr string
+	}{
+		{name: "empty file", file: "", want: 0},
+		{name: "valid tokens", file: "tokens:\n- name: ci\n  hash: " + hash + "\n  scopes: [rules:write]\n- name: admin\n  hash: " + hashToken("other") + "\n  scopes: ['*']\n", want: 2},
+		{name: "uppercase hash", file: "tokens:\n- name: ci\n  hash: " + strings.ToUpper(hash) + "\n", want: 1},
+		{name: "missing name", file: "tokens:\n- hash: " + hash + "\n", wantErr: "needs a name"},
+		{name: "invalid hash", file: "tokens:\n- name: ci\n  hash: md5:abc\n", wantErr: "invalid hash"},
+		{name: "short hash", file: "tokens:\n- name: ci\n  hash: sha256:abcd\n", wantErr: "invalid hash"},
+		{name: "unknown scope", file: "tokens:\n- name: ci\n  hash: " + hash + "\n  scopes: [rules:delete]\n", wantErr: "unknown scope"},
+		{name: "duplicate hash", file: "tokens:\n- name: ci\n  hash: " + hash + "\n- name: cd\n  hash: " + hash + "\n", wantErr: "same hash"},
+		{name: "unknown field", file: "tokens:\n- name: ci\n  hash: " + hash + "\n  token: secret\n", wantErr: "not found"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "tokens.yaml")
+		if err := os.WriteFile(path, []byte(test.file), 0600); err != nil {
+			t.Fatal(err)
+		}
+		tokens, err := loadTokens(path)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: loadTokens returned %v, want an error with %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: loadTokens returned %v", test.name, err)
+			continue
+		}
+		if len(tokens) != test.want {
+			t.Errorf("%s: loadTokens returned %d tokens, want %d", test.name, len(tokens), test.want)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	app := &Application{
+		InfoLog:    log.New(io.Discard, "", 0),
+		ErrorLog:   log.New(io.Discard, "", 0),
+		TokensPath: "tokens.yaml",
+		tokens: map[string]apiToken{
+			hashToken("reader"): {Name: "reader", Scopes: []string{scopeStatsRead, scopeRulesRead}},
+			hashToken("writer"): {Name: "writer", Scopes: []string{scopeRulesWrite}},
+			hashToken("admin"):  {Name: "admin", Scopes: []string{scopeAll}},
+		},
+	}
+	mux := chi.NewRouter()
+	mux.Use(app.authenticate(mux))
+	identity := func(response http.ResponseWriter, request *http.Request) {
+		name, _ := requestIdentity(request)
+		io.WriteString(response, name)
+	}
+	mux.Get("/status", identity)
+	mux.Post("/flushblocked", identity)
+	mux.Get("/v1/rules/*", identity)
+	mux.Get("/openapi.json", identity)
+
+	tests := []struct {
+		method   string
+		target   string
+		token    string
+		want     int
+		identity string
+	}{
+		{method: http.MethodGet, target: "/status", token: "reader", want: http.StatusOK, identity: "reader"},
+		{method: http.MethodGet, target: "/status", token: "writer", want: http.StatusForbidden},
+		{method: http.MethodGet, target: "/status", token: "admin", want: http.StatusOK, identity: "admin"},
+		{method: http.MethodGet, target: "/status", want: http.StatusUnauthorized},
+		{method: http.MethodGet, target: "/status", token: "unknown", want: http.StatusUnauthorized},
+		{method: http.MethodPost, target: "/flushblocked", token: "reader", want: http.StatusForbidden},
+		{method: http.MethodPost, target: "/flushblocked", token: "writer", want: http.StatusOK, identity: "writer"},
+		{method: http.MethodGet, target: "/v1/rules/10.0.0.0%2F8", token: "reader", want: http.StatusOK, identity: "reader"},
+		{method: http.MethodGet, target: "/v1/rules/10.0.0.0%2F8", token: "writer", want: http.StatusForbidden},
+		{method: http.MethodGet, target: "/openapi.json", want: http.StatusOK},
+		{method: http.MethodGet, target: "/missing", want: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.target, nil)
+		if test.token != "" {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		if recorder.Code != test.want {
+			t.Errorf("%s %s with %q = %d, want %d", test.method, test.target, test.token, recorder.Code, test.want)
+			continue
+		}
+		if test.want == http.StatusOK && recorder.Body.String() != test.identity {
+			t.Errorf("%s %s with %q has the identity %q, want %q", test.method, test.target, test.token, recorder.Body.String(), test.identity)
+		}
+	}
+}
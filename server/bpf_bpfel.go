@@ -12,6 +12,49 @@ import (
 	"github.com/cilium/ebpf"
 )
 
+type bpfDropSample struct {
+	TimestampNs uint64
+	Ifindex     uint32
+	RuleId      uint32
+	PacketSize  uint32
+	Captured    uint32
+	Verdict     uint8
+	Data        [128]uint8
+	_           [7]byte
+}
+
+type bpfRatelimitKey struct {
+	RuleId uint32
+	Addr   [16]uint8
+}
+
+type bpfRule struct {
+	SrcPortMin uint16
+	SrcPortMax uint16
+	DstPortMin uint16
+	DstPortMax uint16
+	Protocol   uint8
+	Action     uint8
+	Mode       uint8
+	Prefixlen  uint8
+	RatePps    uint32
+	RateBytes  uint32
+	Id         uint32
+	ExpiresNs  uint64
+}
+
+type bpfRuleCounters struct {
+	Packets          uint64
+	Bytes            uint64
+	MonitoredPackets uint64
+	MonitoredBytes   uint64
+}
+
+type bpfSettings struct {
+	Flags      uint32
+	SampleRate uint32
+}
+
 type bpfStatusMapVal struct {
 	SrcPackets     uint64
 	SrcSizePackets uint64
@@ -19,6 +62,19 @@ type bpfStatusMapVal struct {
 	DstSizePackets uint64
 }
 
+type bpfTokenBucket struct {
+	LastNs         uint64
+	PacketTokens   uint64
+	ByteTokens     uint64
+	PassedPackets  uint64
+	LimitedPackets uint64
+}
+
+type bpfVerdictCounters struct {
+	Packets [7]uint64
+	Bytes   [7]uint64
+}
+
 // loadBpf returns the embedded CollectionSpec for bpf.
 func loadBpf() (*ebpf.CollectionSpec, error) {
 	reader := bytes.NewReader(_BpfBytes)
@@ -67,8 +123,17 @@ type bpfProgramSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type bpfMapSpecs struct {
+	AllowedIpv4 *ebpf.MapSpec `ebpf:"allowed_ipv4"`
+	AllowedIpv6 *ebpf.MapSpec `ebpf:"allowed_ipv6"`
 	BlockedIpv4 *ebpf.MapSpec `ebpf:"blocked_ipv4"`
+	BlockedIpv6 *ebpf.MapSpec `ebpf:"blocked_ipv6"`
+	DropSamples *ebpf.MapSpec `ebpf:"drop_samples"`
+	Ratelimit   *ebpf.MapSpec `ebpf:"ratelimit"`
+	RuleStats   *ebpf.MapSpec `ebpf:"rule_stats"`
+	Settings    *ebpf.MapSpec `ebpf:"settings"`
 	Status      *ebpf.MapSpec `ebpf:"status"`
+	StatusIpv6  *ebpf.MapSpec `ebpf:"status_ipv6"`
+	Verdicts    *ebpf.MapSpec `ebpf:"verdicts"`
 }
 
 // bpfObjects contains all objects after they have been loaded into the kernel.
@@ -90,14 +155,32 @@ func (o *bpfObjects) Close() error {
 //
 // It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
 type bpfMaps struct {
+	AllowedIpv4 *ebpf.Map `ebpf:"allowed_ipv4"`
+	AllowedIpv6 *ebpf.Map `ebpf:"allowed_ipv6"`
 	BlockedIpv4 *ebpf.Map `ebpf:"blocked_ipv4"`
+	BlockedIpv6 *ebpf.Map `ebpf:"blocked_ipv6"`
+	DropSamples *ebpf.Map `ebpf:"drop_samples"`
+	Ratelimit   *ebpf.Map `ebpf:"ratelimit"`
+	RuleStats   *ebpf.Map `ebpf:"rule_stats"`
+	Settings    *ebpf.Map `ebpf:"settings"`
 	Status      *ebpf.Map `ebpf:"status"`
+	StatusIpv6  *ebpf.Map `ebpf:"status_ipv6"`
+	Verdicts    *ebpf.Map `ebpf:"verdicts"`
 }
 
 func (m *bpfMaps) Close() error {
 	return _BpfClose(
+		m.AllowedIpv4,
+		m.AllowedIpv6,
 		m.BlockedIpv4,
+		m.BlockedIpv6,
+		m.DropSamples,
+		m.Ratelimit,
+		m.RuleStats,
+		m.Settings,
 		m.Status,
+		m.StatusIpv6,
+		m.Verdicts,
 	)
 }
 
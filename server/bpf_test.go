@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cField is a field of a struct of the XDP program
+type cField struct {
+	name   string
+	size   int
+	length int
+}
+
+var (
+	cDefine = regexp.MustCompile(`(?m)^#define\s+(\w+)\s+(\d+)\s*$`)
+	cStruct = regexp.MustCompile(`(?s)struct\s+(\w+)\s*\{(.*?)\n\};`)
+	cMember = regexp.MustCompile(`^(__u8|__u16|__be16|__u32|__be32|__u64)\s+(\w+)(?:\[(\w+)\])?;$`)
+	cSizes  = map[string]int{"__u8": 1, "__u16": 2, "__be16": 2, "__u32": 4, "__be32": 4, "__u64": 8}
+)
+
+// parseXDPStructs reads the named structs of source/xdp.c, the array lengths may be numbers
+// or the #define constants of the file
+func parseXDPStructs(t *testing.T) map[string][]cField {
+	data, err := os.ReadFile("../source/xdp.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := string(data)
+	defines := map[string]int{}
+	for _, match := range cDefine.FindAllStringSubmatch(source, -1) {
+		value, _ := strconv.Atoi(match[2])
+		defines[match[1]] = value
+	}
+	structs := map[string][]cField{}
+	for _, match := range cStruct.FindAllStringSubmatch(source, -1) {
+		fields := []cField{}
+		for _, line := range strings.Split(match[2], "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "/*") {
+				continue
+			}
+			member := cMember.FindStringSubmatch(line)
+			if member == nil {
+				t.Fatalf("struct %s: cannot parse the member %q", match[1], line)
+			}
+			field := cField{name: member[2], size: cSizes[member[1]], length: 1}
+			if member[3] != "" {
+				length, err := strconv.Atoi(member[3])
+				if err != nil {
+					length = defines[member[3]]
+				}
+				if length == 0 {
+					t.Fatalf("struct %s: unknown length %s of %s", match[1], member[3], member[2])
+				}
+				field.length = length
+			}
+			fields = append(fields, field)
+		}
+		structs[match[1]] = fields
+	}
+	return structs
+}
+
+// goFieldName is the name bpf2go gives to a member of a C struct
+func goFieldName(name string) string {
+	parts := strings.Split(name, "_")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// TestBpfTypes checks that the types of bpf_bpfel.go and bpf_bpfeb.go still match the structs of
+// source/xdp.c, the offsets follow the natural alignment of the C members
+func TestBpfTypes(t *testing.T) {
+	structs := parseXDPStructs(t)
+	tests := []struct {
+		cName  string
+		goType any
+	}{
+		{cName: "rule", goType: bpfRule{}},
+		{cName: "ratelimit_key", goType: bpfRatelimitKey{}},
+		{cName: "token_bucket", goType: bpfTokenBucket{}},
+		{cName: "statusMapVal", goType: bpfStatusMapVal{}},
+		{cName: "settings", goType: bpfSettings{}},
+		{cName: "drop_sample", goType: bpfDropSample{}},
+		{cName: "rule_counters", goType: bpfRuleCounters{}},
+		{cName: "verdict_counters", goType: bpfVerdictCounters{}},
+	}
+	for _, test := range tests {
+		fields, ok := structs[test.cName]
+		if !ok {
+			t.Errorf("struct %s not found in xdp.c", test.cName)
+			continue
+		}
+		goType := reflect.TypeOf(test.goType)
+		goFields := []reflect.StructField{}
+		for i := 0; i < goType.NumField(); i++ {
+			if goType.Field(i).Name != "_" {
+				goFields = append(goFields, goType.Field(i))
+			}
+		}
+		if len(goFields) != len(fields) {
+			t.Errorf("%s has %d fields, struct %s has %d", goType.Name(), len(goFields), test.cName, len(fields))
+			continue
+		}
+		offset, align := 0, 1
+		for i, field := range fields {
+			if offset%field.size != 0 {
+				offset += field.size - offset%field.size
+			}
+			align = max(align, field.size)
+			goField := goFields[i]
+			if goField.Name != goFieldName(field.name) {
+				t.Errorf("%s field %d is %s, struct %s has %s", goType.Name(), i, goField.Name, test.cName, field.name)
+			}
+			if goField.Offset != uintptr(offset) || goField.Type.Size() != uintptr(field.size*field.length) {
+				t.Errorf("%s.%s is %d bytes at %d, struct %s has %d bytes at %d", goType.Name(), goField.Name,
+					goField.Type.Size(), goField.Offset, test.cName, field.size*field.length, offset)
+			}
+			offset += field.size * field.length
+		}
+		if offset%align != 0 {
+			offset += align - offset%align
+		}
+		if goType.Size() != uintptr(offset) {
+			t.Errorf("%s is %d bytes, struct %s is %d", goType.Name(), goType.Size(), test.cName, offset)
+		}
+	}
+}
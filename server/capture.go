@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ahsifer/goxdp/helpers"
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// default and longest duration of GET /capture
+const (
+	captureDefaultDuration = 10 * time.Second
+	captureMaxDuration     = 5 * time.Minute
+)
+
+// sampleSnaplen is the bytes of the start of the dropped packets copied to the samples, SAMPLE_SNAPLEN of the XDP program
+const sampleSnaplen = 128
+
+// verdictNames are the names of the verdicts of the samples
+var verdictNames = map[uint8]string{
+	verdictDroppedSrc: "dropped_src",
+	verdictDroppedDst: "dropped_dst",
+	verdictDroppedGre: "dropped_gre",
+	verdictMonitored:  "monitored",
+}
+
+// capture the samples of the dropped packets for the duration of the query and return them as a pcapng file,
+// the comment of every packet holds its verdict and the rule that dropped it
+func (app *Application) captureDrops(response http.ResponseWriter, request *http.Request) {
+	duration := captureDefaultDuration
+	if value := request.URL.Query().Get("duration"); value != "" {
+		var err error
+		duration, err = time.ParseDuration(value)
+		if err != nil || duration <= 0 || duration > captureMaxDuration {
+			helpers.Error(response, "duration should be a positive duration up to "+captureMaxDuration.String()+" (Example '10s')", http.StatusBadRequest)
+			return
+		}
+	}
+	if app.SampleRate == 0 {
+		helpers.Error(response, "Sampling of the dropped packets is disabled by -sample-rate=0", http.StatusNotFound)
+		return
+	}
+	//the samples of the ring buffer can only be read by a single reader
+	if !app.captureLock.TryLock() {
+		helpers.Error(response, "Another capture is running", http.StatusConflict)
+		return
+	}
+	defer app.captureLock.Unlock()
+
+	reader, err := ringbuf.NewReader(app.BpfObjects.DropSamples)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot read the drop_samples ring buffer -> %v", err)
+		helpers.Error(response, "Unable to read the drop samples", http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+	app.capturing.Store(true)
+	err = app.applySettings()
+	defer func() {
+		app.capturing.Store(false)
+		if err := app.applySettings(); err != nil {
+			app.ErrorLog.Printf("Cannot stop the sampling of the dropped packets -> %v", err)
+		}
+	}()
+	if err != nil {
+		app.ErrorLog.Printf("Cannot start the sampling of the dropped packets -> %v", err)
+		helpers.Error(response, "Unable to start the sampling of the dropped packets", http.StatusInternalServerError)
+		return
+	}
+	//the samples carry the bpf_ktime_get_ns time, the offset turns it into the wall clock time
+	now, err := monotonicNow()
+	if err != nil {
+		app.ErrorLog.Printf("Cannot read the monotonic clock -> %s", err)
+		helpers.Error(response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	offset := uint64(time.Now().UnixNano()) - now
+
+	response.Header().Set("Content-Type", "application/x-pcapng")
+	response.Header().Set("Content-Disposition", `attachment; filename="drops.pcapng"`)
+	response.WriteHeader(http.StatusOK)
+	writer, err := newPcapngWriter(response, sampleSnaplen)
+	if err != nil {
+		return
+	}
+	//stop reading when the requester goes away before the end of the capture
+	stop := context.AfterFunc(request.Context(), func() { reader.Close() })
+	defer stop()
+	reader.SetDeadline(time.Now().Add(duration))
+
+	prefixes := app.rulePrefixes()
+	names := map[uint32]string{}
+	captured := 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, ringbuf.ErrClosed) {
+			break
+		}
+		if err != nil {
+			app.ErrorLog.Printf("Cannot read the drop_samples ring buffer -> %v", err)
+			break
+		}
+		var sample bpfDropSample
+		if err := binary.Read(bytes.NewReader(record.RawSample), binary.NativeEndian, &sample); err != nil {
+			continue
+		}
+		//the rule may be newer than the last list of the prefixes
+		prefix, ok := prefixes[sample.RuleId]
+		if !ok && sample.RuleId != 0 {
+			prefixes = app.rulePrefixes()
+			prefix = prefixes[sample.RuleId]
+		}
+		name, ok := names[sample.Ifindex]
+		if !ok {
+			name = strconv.Itoa(int(sample.Ifindex))
+			if iface, err := net.InterfaceByIndex(int(sample.Ifindex)); err == nil {
+				name = iface.Name
+			}
+			names[sample.Ifindex] = name
+		}
+		data := sample.Data[:min(sample.Captured, sampleSnaplen)]
+		err = writer.writePacket(sample.Ifindex, name, sample.TimestampNs+offset, sample.PacketSize, data, sampleComment(sample.Verdict, prefix))
+		if err != nil {
+			break
+		}
+		captured++
+	}
+	app.InfoLog.Printf("Captured %d samples of the dropped packets for %s", captured, requestActor(request))
+}
+
+// sampleComment describes why the packet of the sample was dropped, or would have been by a monitored rule
+func sampleComment(verdict uint8, prefix netip.Prefix) string {
+	comment := verdictNames[verdict]
+	if prefix.IsValid() {
+		comment += fmt.Sprintf(" by the rule %s", prefix)
+	}
+	return comment
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the declarative configuration file of the service, the listen addresses and
+// workers are read on start while the interfaces and rules are reconciled on start and on SIGHUP
+type Config struct {
+	Listen struct {
+		Private string `yaml:"private"`
+		Public  string `yaml:"public"`
+	} `yaml:"listen"`
+	Interfaces []configInterface `yaml:"interfaces"`
+	Blocked    []ruleEntry       `yaml:"blocked"`
+	Allowed    []string          `yaml:"allowed"`
+	Feeds      []configFeed      `yaml:"feeds"`
+	Workers    struct {
+		TimeoutInterval int `yaml:"timeout_interval"`
+	} `yaml:"workers"`
+}
+
+type configInterface struct {
+	Name string `yaml:"name"`
+	Mode string `yaml:"mode"`
+}
+
+// configState is what the last reconciled configuration file added, so that removing
+// an entry from the file removes it from the maps without touching the rules added by the API.
+// The blocked rules of the file are found through their metadata instead, so they are still
+// removed when the file changed while the service was down
+type configState struct {
+	Interfaces map[string]bool
+	Allowed    map[netip.Prefix]bool
+}
+
+// ownerConfig is the owner of the blocked rules added by the configuration file
+const ownerConfig = "config"
+
+// staleConfigRules returns the sorted prefixes of the live rules added by a configuration file
+// that the new file no longer holds
+func staleConfigRules(live []blockedRule, metadata map[netip.Prefix]ruleMetadata, configured map[netip.Prefix]bool) []netip.Prefix {
+	stale := []netip.Prefix{}
+	for _, value := range live {
+		saved, ok := metadata[value.Prefix]
+		if !ok || saved.Origin != originAuto || saved.Owner != ownerConfig || configured[value.Prefix] {
+			continue
+		}
+		stale = append(stale, value.Prefix)
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].Addr().Less(stale[j].Addr()) ||
+			stale[i].Addr() == stale[j].Addr() && stale[i].Bits() < stale[j].Bits()
+	})
+	return stale
+}
+
+// loadConfig reads and validates the configuration file
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&config)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	for _, iface := range config.Interfaces {
+		if _, ok := xdpModes[iface.Mode]; !ok {
+			return nil, fmt.Errorf("invalid mode %q of the interface %s", iface.Mode, iface.Name)
+		}
+	}
+	for _, value := range config.Blocked {
+		if _, _, err := value.rule(); err != nil {
+			return nil, err
+		}
+	}
+	for _, target := range config.Allowed {
+		if _, err := parsePrefix(target); err != nil {
+			return nil, fmt.Errorf("invalid allowed target %s -> %w", target, err)
+		}
+	}
+	names := map[string]bool{}
+	for i, feed := range config.Feeds {
+		if feed.Name == "" || feed.URL == "" {
+			return nil, errors.New("every feed needs a name and an url")
+		}
+		if names[feed.Name] {
+			return nil, fmt.Errorf("duplicate feed name %s", feed.Name)
+		}
+		names[feed.Name] = true
+		if _, err := parseRuleMode(feed.Mode); err != nil {
+			return nil, fmt.Errorf("invalid feed %s -> %w", feed.Name, err)
+		}
+		if feed.Interval == 0 {
+			config.Feeds[i].Interval = feedDefaultInterval
+		} else if feed.Interval < feedMinInterval {
+			return nil, fmt.Errorf("interval of the feed %s should be %s or greater", feed.Name, feedMinInterval)
+		}
+	}
+	if config.Workers.TimeoutInterval != 0 && config.Workers.TimeoutInterval < 5 {
+		return nil, errors.New("timeout_interval should 5 or greater")
+	}
+	return &config, nil
+}
+
+// reconcile brings the interfaces and maps in line with the configuration file, live entries
+// that already match the file are left untouched so their timeouts keep running
+func (app *Application) reconcile(config *Config) error {
+	app.configLock.Lock()
+	defer app.configLock.Unlock()
+
+	applied := configState{
+		Interfaces: map[string]bool{},
+		Allowed:    map[netip.Prefix]bool{},
+	}
+	var errs []error
+
+	//interfaces
+	for _, iface := range config.Interfaces {
+		applied.Interfaces[iface.Name] = true
+		before := app.interfaceSnapshot(iface.Name)
+		changed, err := app.ensureXDP(iface.Name, iface.Mode)
+		if changed || err != nil {
+			app.audit(actorConfig, auditLoad, iface.Name, before, app.interfaceSnapshot(iface.Name), err)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if changed {
+			app.InfoLog.Printf("Config: XDP is loaded to the interface %s with mode %s", iface.Name, iface.Mode)
+		}
+	}
+	for name := range app.config.Interfaces {
+		if applied.Interfaces[name] {
+			continue
+		}
+		before := app.interfaceSnapshot(name)
+		err := app.detachXDP(name)
+		if errors.Is(err, errNotLoaded) {
+			continue
+		}
+		app.audit(actorConfig, auditUnload, name, before, app.interfaceSnapshot(name), err)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		app.InfoLog.Printf("Config: XDP is unloaded from the interface %s", name)
+	}
+
+	//blocked rules
+	liveRules, err := app.blockedRules()
+	if err != nil {
+		return err
+	}
+	live := map[netip.Prefix]bpfRule{}
+	for _, value := range liveRules {
+		live[value.Prefix] = value.Rule
+	}
+	configured := map[netip.Prefix]bool{}
+	for _, value := range config.Blocked {
+		prefix, rule, err := value.rule()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		configured[prefix] = true
+		current, ok := live[prefix]
+		if ok && sameRule(current, rule) {
+			continue
+		}
+		rule.ExpiresNs, err = ruleExpiry(value.Timeout)
+		if errors.Is(err, errTimeoutTooLong) {
+			errs = append(errs, fmt.Errorf("cannot block %s -> %w", prefix, err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		before := app.ruleSnapshot(prefix)
+		if err := app.blockPrefix(prefix, rule); err != nil {
+			app.audit(actorConfig, auditBlock, prefix.String(), before, before, err)
+			errs = append(errs, fmt.Errorf("cannot block %s -> %w", prefix, err))
+			continue
+		}
+		app.setRuleMetadata(prefix, originAuto, ownerConfig, value.Comment)
+		app.audit(actorConfig, auditBlock, prefix.String(), before, app.ruleSnapshot(prefix), nil)
+		app.InfoLog.Printf("Config: %s is blocked", prefix)
+	}
+	for _, prefix := range staleConfigRules(liveRules, app.ruleMetadataOf(liveRules), configured) {
+		before := app.ruleSnapshot(prefix)
+		if err := app.unblockPrefix(prefix); err != nil {
+			app.audit(actorConfig, auditUnblock, prefix.String(), before, before, err)
+			errs = append(errs, fmt.Errorf("cannot unblock %s -> %w", prefix, err))
+			continue
+		}
+		app.deleteRuleMetadata(prefix)
+		app.audit(actorConfig, auditUnblock, prefix.String(), before, nil, nil)
+		app.InfoLog.Printf("Config: %s is unblocked", prefix)
+	}
+
+	//allowed prefixes
+	allowedPrefixes, err := app.allowedPrefixes()
+	if err != nil {
+		return err
+	}
+	allowed := map[netip.Prefix]bool{}
+	for _, prefix := range allowedPrefixes {
+		allowed[prefix] = true
+	}
+	for _, target := range config.Allowed {
+		prefix, err := parsePrefix(target)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		applied.Allowed[prefix] = true
+		if allowed[prefix] {
+			continue
+		}
+		err = app.allowPrefix(prefix)
+		if err != nil {
+			app.audit(actorConfig, auditAllowList, prefix.String(), nil, nil, err)
+			errs = append(errs, fmt.Errorf("cannot allow %s -> %w", prefix, err))
+			continue
+		}
+		app.audit(actorConfig, auditAllowList, prefix.String(), nil, &allowedEntry{Target: prefix.String()}, nil)
+		app.InfoLog.Printf("Config: %s is added to the allow list", prefix)
+	}
+	for prefix := range app.config.Allowed {
+		if applied.Allowed[prefix] || !allowed[prefix] {
+			continue
+		}
+		before := &allowedEntry{Target: prefix.String()}
+		err := app.disallowPrefix(prefix)
+		if err != nil {
+			app.audit(actorConfig, auditUnallowList, prefix.String(), before, before, err)
+			errs = append(errs, fmt.Errorf("cannot remove %s from the allow list -> %w", prefix, err))
+			continue
+		}
+		app.audit(actorConfig, auditUnallowList, prefix.String(), before, nil, nil)
+		app.InfoLog.Printf("Config: %s is removed from the allow list", prefix)
+	}
+
+	//the feeds are applied by the feed worker
+	app.setFeeds(config.Feeds)
+
+	app.config = applied
+	app.persistState()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestStaleConfigRules(t *testing.T) {
+	config := ruleMetadata{Origin: originAuto, Owner: ownerConfig}
+	tests := []struct {
+		name       string
+		live       []string
+		metadata   map[string]ruleMetadata
+		configured []string
+		want       []netip.Prefix
+	}{
+		{
+			name:       "rule still in the file",
+			live:       []string{"10.0.0.0/8"},
+			metadata:   map[string]ruleMetadata{"10.0.0.0/8": config},
+			configured: []string{"10.0.0.0/8"},
+			want:       []netip.Prefix{},
+		},
+		{
+			name:     "rule removed from the file",
+			live:     []string{"10.0.0.0/8", "192.0.2.0/24"},
+			metadata: map[string]ruleMetadata{"10.0.0.0/8": config, "192.0.2.0/24": config},
+			want:     []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.0/24")},
+		},
+		{
+			name: "rules of the api and the feeds are left alone",
+			live: []string{"10.0.0.0/8", "192.0.2.0/24", "2001:db8::/32"},
+			metadata: map[string]ruleMetadata{
+				"10.0.0.0/8":    {Origin: originAPI, Owner: "ci"},
+				"192.0.2.0/24":  {Origin: originFeed, Owner: "feed:spamhaus"},
+				"2001:db8::/32": {Origin: originAuto, Owner: "optimizer"},
+			},
+			want: []netip.Prefix{},
+		},
+		{
+			name: "rule without metadata is left alone",
+			live: []string{"10.0.0.0/8"},
+			want: []netip.Prefix{},
+		},
+		{
+			name:     "config rule taken over by the api",
+			live:     []string{"10.0.0.0/8"},
+			metadata: map[string]ruleMetadata{"10.0.0.0/8": {Origin: originAPI, Owner: ownerConfig}},
+			want:     []netip.Prefix{},
+		},
+		{
+			name:     "sorted by address then length",
+			live:     []string{"2001:db8::/32", "10.0.0.0/16", "10.0.0.0/8"},
+			metadata: map[string]ruleMetadata{"2001:db8::/32": config, "10.0.0.0/16": config, "10.0.0.0/8": config},
+			want: []netip.Prefix{
+				netip.MustParsePrefix("10.0.0.0/8"),
+				netip.MustParsePrefix("10.0.0.0/16"),
+				netip.MustParsePrefix("2001:db8::/32"),
+			},
+		},
+	}
+	for _, test := range tests {
+		live := []blockedRule{}
+		for _, target := range test.live {
+			live = append(live, blockedRule{Prefix: netip.MustParsePrefix(target)})
+		}
+		metadata := map[netip.Prefix]ruleMetadata{}
+		for target, saved := range test.metadata {
+			metadata[netip.MustParsePrefix(target)] = saved
+		}
+		configured := map[netip.Prefix]bool{}
+		for _, target := range test.configured {
+			configured[netip.MustParsePrefix(target)] = true
+		}
+		got := staleConfigRules(live, metadata, configured)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: staleConfigRules = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
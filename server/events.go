@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahsifer/goxdp/helpers"
+)
+
+// types of the events streamed by GET /events
+const (
+	eventRuleAdded         = "rule_added"
+	eventRuleUpdated       = "rule_updated"
+	eventRuleRemoved       = "rule_removed"
+	eventRuleExpired       = "rule_expired"
+	eventAllowAdded        = "allow_added"
+	eventAllowRemoved      = "allow_removed"
+	eventInterfaceAttached = "interface_attached"
+	eventInterfaceDetached = "interface_detached"
+	eventSettingsChanged   = "settings_changed"
+	eventDrops             = "drops"
+)
+
+var knownEvents = map[string]bool{
+	eventRuleAdded: true, eventRuleUpdated: true, eventRuleRemoved: true, eventRuleExpired: true,
+	eventAllowAdded: true, eventAllowRemoved: true,
+	eventInterfaceAttached: true, eventInterfaceDetached: true,
+	eventSettingsChanged: true, eventDrops: true,
+}
+
+// events buffered for every subscriber, a subscriber that reads slower misses the next events
+const eventsBuffer = 256
+
+// eventsKeepalive is how often a comment is sent on an idle stream so that proxies keep it open
+const eventsKeepalive = 15 * time.Second
+
+// firewallEvent is a single event of the stream
+type firewallEvent struct {
+	ID     uint64    `json:"id"`
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type" enum:"rule_added,rule_updated,rule_removed,rule_expired,allow_added,allow_removed,interface_attached,interface_detached,settings_changed,drops"`
+	Actor  string    `json:"actor,omitempty"`
+	Target string    `json:"target,omitempty"`
+	// State is the rule, interface or settings after the change, or before it for the removals
+	State json.RawMessage `json:"state,omitempty"`
+	// Drops holds the rules that dropped packets since the previous drops event
+	Drops []ruleDrops `json:"drops,omitempty"`
+}
+
+// ruleDrops is the packets and bytes dropped by a rule during the interval of a drops event
+type ruleDrops struct {
+	Target  string  `json:"target"`
+	Packets uint64  `json:"packets"`
+	Bytes   uint64  `json:"bytes"`
+	Pps     float64 `json:"pps"`
+	Bps     float64 `json:"bps"`
+}
+
+// eventBroker hands the events to the subscribers of GET /events, the IDs increase by one
+// for every event so that a subscriber can tell when it missed some
+type eventBroker struct {
+	lock        sync.Mutex
+	subscribers map[chan firewallEvent]struct{}
+	nextID      uint64
+	closed      bool
+}
+
+// subscribe returns the channel of a new subscriber, false once the broker is closed
+func (broker *eventBroker) subscribe() (chan firewallEvent, bool) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	if broker.closed {
+		return nil, false
+	}
+	if broker.subscribers == nil {
+		broker.subscribers = map[chan firewallEvent]struct{}{}
+	}
+	events := make(chan firewallEvent, eventsBuffer)
+	broker.subscribers[events] = struct{}{}
+	return events, true
+}
+
+// unsubscribe removes the subscriber, its channel is left for the garbage collector
+func (broker *eventBroker) unsubscribe(events chan firewallEvent) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	delete(broker.subscribers, events)
+}
+
+// active reports whether anyone is subscribed
+func (broker *eventBroker) active() bool {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	return len(broker.subscribers) > 0
+}
+
+// publish sends the event to every subscriber without waiting for the slow ones
+func (broker *eventBroker) publish(event firewallEvent) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	if broker.closed || len(broker.subscribers) == 0 {
+		return
+	}
+	broker.nextID++
+	event.ID = broker.nextID
+	event.Time = time.Now().UTC()
+	for events := range broker.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// close ends the streams of every subscriber so that the server can shut down
+func (broker *eventBroker) close() {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	broker.closed = true
+	for events := range broker.subscribers {
+		close(events)
+	}
+	broker.subscribers = nil
+}
+
+// publishChange turns a successful change of the audit log into an event, before and after
+// are the encoded state of the target and the changes that leave it as it was are not published
+func (app *Application) publishChange(actor string, action string, target string, before json.RawMessage, after json.RawMessage) {
+	var eventType string
+	state := after
+	switch action {
+	case auditBlock, auditUpdate:
+		if string(before) == string(after) {
+			return
+		}
+		eventType = eventRuleUpdated
+		if before == nil {
+			eventType = eventRuleAdded
+		}
+	case auditUnblock, auditFlushBlocked:
+		eventType, state = eventRuleRemoved, before
+	case auditExpire:
+		eventType, state = eventRuleExpired, before
+	case auditAllowList:
+		eventType = eventAllowAdded
+	case auditUnallowList, auditFlushAllowed:
+		eventType, state = eventAllowRemoved, before
+	case auditLoad:
+		if string(before) == string(after) {
+			return
+		}
+		eventType = eventInterfaceAttached
+	case auditUnload:
+		eventType, state = eventInterfaceDetached, before
+	case auditSettings:
+		if string(before) == string(after) {
+			return
+		}
+		eventType = eventSettingsChanged
+	default:
+		return
+	}
+	app.events.publish(firewallEvent{Type: eventType, Actor: actor, Target: target, State: state})
+}
+
+// dropsWorker publishes the packets and bytes dropped by every rule since the previous tick
+// while someone is subscribed, until the context is cancelled
+func (app *Application) dropsWorker(ctx context.Context, interval int) {
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	//the counters of the previous tick, keyed by the prefix and ID so that a reused ID starts over
+	type ruleKey struct {
+		prefix netip.Prefix
+		id     uint32
+	}
+	previous := map[ruleKey]bpfRuleCounters{}
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !app.events.active() {
+			clear(previous)
+			continue
+		}
+		rules, err := app.blockedRules()
+		if err != nil {
+			app.InfoLog.Print("Cannot read the blocked maps for the drops event -> ", err)
+			continue
+		}
+		now := time.Now()
+		seconds := now.Sub(last).Seconds()
+		first := len(previous) == 0
+		current := map[ruleKey]bpfRuleCounters{}
+		var drops []ruleDrops
+		for _, value := range rules {
+			counters, err := app.ruleCounters(value.Rule.Id)
+			if err != nil {
+				continue
+			}
+			key := ruleKey{value.Prefix, value.Rule.Id}
+			current[key] = counters
+			//the first tick after a subscription only takes the starting point
+			if first {
+				continue
+			}
+			delta := counters
+			//the counters were zeroed by a flush of the status in between
+			if before, ok := previous[key]; ok && counters.Packets >= before.Packets {
+				delta.Packets -= before.Packets
+				delta.Bytes -= before.Bytes
+			}
+			if delta.Packets == 0 {
+				continue
+			}
+			drops = append(drops, ruleDrops{
+				Target:  value.Prefix.String(),
+				Packets: delta.Packets,
+				Bytes:   delta.Bytes,
+				Pps:     float64(delta.Packets) / seconds,
+				Bps:     float64(delta.Bytes) * 8 / seconds,
+			})
+		}
+		previous = current
+		last = now
+		if len(drops) > 0 {
+			app.events.publish(firewallEvent{Type: eventDrops, Drops: drops})
+		}
+	}
+}
+
+// stream the firewall events as Server-Sent Events, the types query parameter keeps only some of them
+func (app *Application) eventsStream(response http.ResponseWriter, request *http.Request) {
+	types := map[string]bool{}
+	if value := request.URL.Query().Get("types"); value != "" {
+		for _, eventType := range strings.Split(value, ",") {
+			if !knownEvents[eventType] {
+				helpers.Error(response, "Unknown event type "+eventType, http.StatusBadRequest)
+				return
+			}
+			types[eventType] = true
+		}
+	}
+	events, ok := app.events.subscribe()
+	if !ok {
+		helpers.Error(response, "The service is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer app.events.unsubscribe(events)
+
+	controller := http.NewResponseController(response)
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+	fmt.Fprint(response, ": connected\n\n")
+	if err := controller.Flush(); err != nil {
+		app.ErrorLog.Printf("Cannot stream the events -> %v", err)
+		return
+	}
+	keepalive := time.NewTicker(eventsKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(response, ": keepalive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(types) > 0 && !types[event.Type] {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				app.ErrorLog.Println("Unable to parse json data", err)
+				continue
+			}
+			fmt.Fprintf(response, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ahsifer/goxdp/helpers"
+	"github.com/cilium/ebpf"
+)
+
+const (
+	// how often a feed is refreshed when the configuration file does not set its interval
+	feedDefaultInterval = time.Hour
+	feedMinInterval     = time.Minute
+	// limits of a single download of a feed
+	feedFetchTimeout = time.Minute
+	feedMaxSize      = 64 << 20
+)
+
+// configFeed is a threat feed of the configuration file, the source is a local file or an HTTP(S) URL
+// in the FireHOL netset, Spamhaus DROP/EDROP, or one CIDR per line format, its rules are in the mode of the feed
+type configFeed struct {
+	Name     string        `yaml:"name"`
+	URL      string        `yaml:"url"`
+	Interval time.Duration `yaml:"interval"`
+	Mode     string        `yaml:"mode"`
+}
+
+// setFeeds hands the feeds of the configuration file to the feed worker, an update that
+// the worker did not pick up yet is replaced
+func (app *Application) setFeeds(feeds []configFeed) {
+	select {
+	case <-app.feedUpdates:
+	default:
+	}
+	app.feedUpdates <- feeds
+}
+
+// feedWorker refreshes every feed on its interval until the context is cancelled, the prefixes of
+// the feeds removed from the configuration file are removed from the blocked maps
+func (app *Application) feedWorker(ctx context.Context) {
+	app.InfoLog.Print("Starting feed worker")
+	feeds := map[string]configFeed{}
+	next := map[string]time.Time{}
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		//sleep until the next feed is due
+		wake := time.Now().Add(time.Hour)
+		for _, due := range next {
+			if due.Before(wake) {
+				wake = due
+			}
+		}
+		timer.Reset(time.Until(wake))
+
+		select {
+		case <-ctx.Done():
+			app.InfoLog.Print("Feed worker stopped")
+			return
+		case update := <-app.feedUpdates:
+			configured := map[string]configFeed{}
+			for _, feed := range update {
+				configured[feed.Name] = feed
+				//new and changed feeds are refreshed right away
+				if feeds[feed.Name] != feed {
+					next[feed.Name] = time.Now()
+				}
+			}
+			feeds = configured
+			for name := range next {
+				if _, ok := feeds[name]; !ok {
+					delete(next, name)
+				}
+			}
+			for _, name := range app.feedOwners() {
+				if _, ok := feeds[name]; !ok {
+					app.removeFeed(name)
+				}
+			}
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		for name, due := range next {
+			if due.After(now) {
+				continue
+			}
+			feed := feeds[name]
+			next[name] = now.Add(feed.Interval)
+			if err := app.refreshFeed(ctx, feed); err != nil {
+				app.ErrorLog.Printf("Cannot refresh the feed %s, keeping its current entries -> %v", name, err)
+			}
+		}
+	}
+}
+
+// fetchFeed downloads or reads the feed and returns its valid prefixes, entries that are not
+// valid IP addresses or subnets are counted and skipped
+func fetchFeed(ctx context.Context, feed configFeed) (map[netip.Prefix]bool, int, error) {
+	var body io.ReadCloser
+	if strings.HasPrefix(feed.URL, "http://") || strings.HasPrefix(feed.URL, "https://") {
+		ctx, cancel := context.WithTimeout(ctx, feedFetchTimeout)
+		defer cancel()
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.URL, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return nil, 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("unexpected response %s", resp.Status)
+		}
+		body = resp.Body
+	} else {
+		file, err := os.Open(strings.TrimPrefix(feed.URL, "file://"))
+		if err != nil {
+			return nil, 0, err
+		}
+		body = file
+	}
+	defer body.Close()
+
+	prefixes := map[netip.Prefix]bool{}
+	invalid := 0
+	scanner := bufio.NewScanner(io.LimitReader(body, feedMaxSize))
+	for scanner.Scan() {
+		//netset files comment with # and the Spamhaus DROP lists with ;
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line, _, _ = strings.Cut(line, ";")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		target, err := helpers.IpChecker(fields[0])
+		if err != nil {
+			invalid++
+			continue
+		}
+		prefix, err := parsePrefix(*target)
+		if err != nil {
+			invalid++
+			continue
+		}
+		prefixes[prefix] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	return prefixes, invalid, nil
+}
+
+// refreshFeed applies the difference between the feed and the prefixes it owns, the rules of the feed are switched
+// to its mode, prefixes that are already blocked by the API or another feed are left to their owner. The prefixes
+// inside a broader rule are written too, so they stay blocked when the owner of that rule removes it
+func (app *Application) refreshFeed(ctx context.Context, feed configFeed) error {
+	entries, invalid, err := fetchFeed(ctx, feed)
+	if err != nil {
+		return err
+	}
+	//the adjacent prefixes of the feed take a single slot of the blocked maps
+	desired := aggregatePrefixes(entries)
+	rules, err := app.blockedRules()
+	if err != nil {
+		return err
+	}
+	live := map[netip.Prefix]bpfRule{}
+	for _, value := range rules {
+		live[value.Prefix] = value.Rule
+	}
+
+	//the mode is checked when the configuration file is read
+	mode, _ := parseRuleMode(feed.Mode)
+
+	app.metadataLock.Lock()
+	var added, changed []blockedRule
+	var removed []netip.Prefix
+	for prefix, metadata := range app.ruleMetadata {
+		if !metadata.ownedByFeed(feed.Name) || desired[prefix] {
+			continue
+		}
+		if _, ok := live[prefix]; ok {
+			removed = append(removed, prefix)
+		} else {
+			delete(app.ruleMetadata, prefix)
+		}
+	}
+	for prefix := range desired {
+		rule, ok := live[prefix]
+		if !ok {
+			added = append(added, blockedRule{Prefix: prefix, Rule: bpfRule{Action: ruleActionDrop, Mode: mode}})
+		} else if rule.Mode != mode && app.ruleMetadata[prefix].ownedByFeed(feed.Name) {
+			rule.Mode = mode
+			changed = append(changed, blockedRule{Prefix: prefix, Rule: rule})
+		}
+	}
+
+	//the metadata lock is held, so the audit records are built from the rules read above
+	actor := actorFeedPrefix + feed.Name
+	failed := 0
+	for i, err := range app.blockPrefixes(added) {
+		if err != nil {
+			app.audit(actor, auditBlock, added[i].Prefix.String(), nil, nil, err)
+			failed++
+			app.ErrorLog.Printf("Feed %s cannot block %s -> %v", feed.Name, added[i].Prefix, err)
+			continue
+		}
+		app.setRuleMetadataLocked(added[i].Prefix, originFeed, feed.Name, "")
+		after := ruleEntryOf(added[i].Prefix, added[i].Rule)
+		after.Owner = feed.Name
+		app.audit(actor, auditBlock, added[i].Prefix.String(), nil, after, nil)
+	}
+	for i, err := range app.blockPrefixes(changed) {
+		before := ruleEntryOf(changed[i].Prefix, live[changed[i].Prefix])
+		before.Owner = feed.Name
+		if err != nil {
+			app.audit(actor, auditUpdate, changed[i].Prefix.String(), before, before, err)
+			failed++
+			app.ErrorLog.Printf("Feed %s cannot switch %s to the %s mode -> %v", feed.Name, changed[i].Prefix, ruleModeName(mode), err)
+			continue
+		}
+		after := ruleEntryOf(changed[i].Prefix, changed[i].Rule)
+		after.Owner = feed.Name
+		app.audit(actor, auditUpdate, changed[i].Prefix.String(), before, after, nil)
+	}
+	for i, err := range app.unblockPrefixes(removed) {
+		before := ruleEntryOf(removed[i], live[removed[i]])
+		before.Owner = feed.Name
+		if err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			app.audit(actor, auditUnblock, removed[i].String(), before, before, err)
+			failed++
+			app.ErrorLog.Printf("Feed %s cannot unblock %s -> %v", feed.Name, removed[i], err)
+			continue
+		}
+		app.audit(actor, auditUnblock, removed[i].String(), before, nil, nil)
+		delete(app.ruleMetadata, removed[i])
+	}
+	app.metadataLock.Unlock()
+	app.InfoLog.Printf("Feed %s refreshed with %d entries (%d invalid) aggregated to %d prefixes: %d added, %d switched to the %s mode, %d removed, %d failed",
+		feed.Name, len(entries), invalid, len(desired), len(added), len(changed), ruleModeName(mode), len(removed), failed)
+	if len(added) > 0 || len(changed) > 0 || len(removed) > 0 {
+		app.persistState()
+	}
+	return nil
+}
+
+// removeFeed removes the prefixes owned by the feed from the blocked maps
+func (app *Application) removeFeed(name string) {
+	app.metadataLock.Lock()
+	var removed []netip.Prefix
+	for prefix, metadata := range app.ruleMetadata {
+		if metadata.ownedByFeed(name) {
+			removed = append(removed, prefix)
+		}
+	}
+	actor := actorFeedPrefix + name
+	for i, err := range app.unblockPrefixes(removed) {
+		if err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			app.audit(actor, auditUnblock, removed[i].String(), nil, nil, err)
+			app.ErrorLog.Printf("Feed %s cannot unblock %s -> %v", name, removed[i], err)
+			continue
+		}
+		app.audit(actor, auditUnblock, removed[i].String(), nil, nil, nil)
+		delete(app.ruleMetadata, removed[i])
+	}
+	app.metadataLock.Unlock()
+	app.InfoLog.Printf("Feed %s removed with its %d entries", name, len(removed))
+	app.persistState()
+}
+
+// feedOwners returns the names of the feeds that own at least one blocked prefix
+func (app *Application) feedOwners() []string {
+	app.metadataLock.Lock()
+	defer app.metadataLock.Unlock()
+	names := map[string]bool{}
+	for _, metadata := range app.ruleMetadata {
+		if metadata.Origin == originFeed {
+			names[metadata.Owner] = true
+		}
+	}
+	feeds := []string{}
+	for name := range names {
+		feeds = append(feeds, name)
+	}
+	return feeds
+}
+
+// ownedByFeed reports whether the rule was added by the feed
+func (metadata ruleMetadata) ownedByFeed(name string) bool {
+	return metadata.Origin == originFeed && metadata.Owner == name
+}
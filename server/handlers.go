@@ -1,11 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"github.com/ahsifer/goxdp/helpers"
 	"github.com/cilium/ebpf"
-	"github.com/cilium/ebpf/link"
 	"net"
 	"net/http"
 	"net/netip"
@@ -38,47 +38,21 @@ func (app *Application) xdpLoad(response http.ResponseWriter, request *http.Requ
 	stringSlice := strings.Split(*body.Interfaces, ",")
 	app.Interfaces = &stringSlice
 
-	//Create Modes Array
-	modes := make(map[string]link.XDPAttachFlags)
-	modes["hw"] = link.XDPOffloadMode
-	modes["skb"] = link.XDPGenericMode
-	modes["nv"] = link.XDPDriverMode
-	loadMode, ok := modes[*body.Mode]
-	if !ok {
+	if _, ok := xdpModes[*body.Mode]; !ok {
 		app.ErrorLog.Printf("Invalid Mode")
 		helpers.Error(response, "Invalid Mode", http.StatusBadRequest)
 		return
 	}
 
-	//default interface details
-	interLink := link.XDPOptions{
-		Program: app.BpfObjects.Firewall,
-		Flags:   loadMode,
-	}
 	for _, value := range *app.Interfaces {
-		//check if XDP code is already loaded
-		_, ok := app.LoadedInterfaces[value]
-		if ok {
-			errMsg := "XDP is already loaded to the interface: " + value
-			app.InfoLog.Print(errMsg)
-			continue
-		}
-		iface, err := net.InterfaceByName(value)
+		before := app.interfaceSnapshot(value)
+		err := app.attachXDP(value, *body.Mode)
+		app.audit(requestActor(request), auditLoad, value, before, app.interfaceSnapshot(value), err)
 		if err != nil {
-			errMessage := "interface does not exists " + value + " -> " + err.Error()
-			app.ErrorLog.Printf(errMessage)
-			helpers.Error(response, errMessage, http.StatusBadRequest)
+			app.ErrorLog.Print(err.Error())
+			helpers.Error(response, err.Error(), http.StatusBadRequest)
 			return
 		}
-		interLink.Interface = iface.Index
-		l, err := link.AttachXDP(interLink)
-		if err != nil {
-			errorMSG := "Cannot attach XDP to " + value + " XDP might be already loaded to the interface  -> " + err.Error()
-			app.ErrorLog.Printf(errorMSG)
-			helpers.Error(response, errorMSG, http.StatusBadRequest)
-			return
-		}
-		app.LoadedInterfaces[value] = l
 	}
 	response.WriteHeader(200)
 	return
@@ -87,7 +61,7 @@ func (app *Application) xdpLoad(response http.ResponseWriter, request *http.Requ
 // unload XDP programs
 func (app *Application) xdpUnload(response http.ResponseWriter, request *http.Request) {
 	response.Header().Set("Content-Type", "application/json")
-	if len(app.LoadedInterfaces) == 0 {
+	if len(app.loadedInterfaces()) == 0 {
 		app.ErrorLog.Printf("XDP program is not loaded")
 		helpers.Error(response, "XDP program is not loaded to any of the interfaces", http.StatusBadRequest)
 		return
@@ -111,34 +85,35 @@ func (app *Application) xdpUnload(response http.ResponseWriter, request *http.Re
 	//parse input interfaces
 	stringSlice := strings.Split(*body.Interfaces, ",")
 	if stringSlice[0] == "all" {
-		if len(app.LoadedInterfaces) == 0 {
+		loadedInterfaces := app.loadedInterfaces()
+		if len(loadedInterfaces) == 0 {
 			helpers.Error(response, "No XDP program loaded", http.StatusBadRequest)
 			return
 		}
-		for key, value := range app.LoadedInterfaces {
-			err = value.Close()
+		for _, value := range loadedInterfaces {
+			before := app.interfaceSnapshot(value)
+			err = app.detachXDP(value)
+			app.audit(requestActor(request), auditUnload, value, before, app.interfaceSnapshot(value), err)
 			if err != nil {
 				app.ErrorLog.Printf("Cannot remove XDP from the interface -> %v\n", err)
 				helpers.Error(response, "Cannot remove XDP from the interface", http.StatusBadRequest)
 				return
 			}
-			//delete the xdp program from the map
-			delete(app.LoadedInterfaces, key)
 		}
 	} else {
 		for _, value := range stringSlice {
-			val, ok := app.LoadedInterfaces[value]
-			if !ok {
+			before := app.interfaceSnapshot(value)
+			err = app.detachXDP(value)
+			if errors.Is(err, errNotLoaded) {
 				response.Write([]byte("no XDP code loaded to the interface: " + value))
 				continue
 			}
-			err = val.Close()
+			app.audit(requestActor(request), auditUnload, value, before, app.interfaceSnapshot(value), err)
 			if err != nil {
 				app.ErrorLog.Printf("Cannot remove XDP from the interface -> %v\n", err)
 				helpers.Error(response, "Cannot remove XDP from the interface: "+value, http.StatusBadRequest)
 				return
 			}
-			delete(app.LoadedInterfaces, value)
 		}
 	}
 	response.WriteHeader(200)
@@ -149,7 +124,7 @@ func (app *Application) xdpUnload(response http.ResponseWriter, request *http.Re
 func (app *Application) xdpBlock(response http.ResponseWriter, request *http.Request) {
 	response.Header().Set("Content-Type", "application/json")
 	//Request body parsing
-	var body load
+	var body blockLoad
 	err := json.NewDecoder(request.Body).Decode(&body)
 	if err != nil {
 		app.ErrorLog.Printf("Cannot parse json request -> %v\n", err)
@@ -164,122 +139,320 @@ func (app *Application) xdpBlock(response http.ResponseWriter, request *http.Req
 		return
 	}
 
-	//Check if input IP is valid
-	validIP, err := helpers.IpChecker(*body.Target)
+	//Check if input IP or subnet is valid
+	prefix, err := parsePrefix(*body.Target)
 	if err != nil {
 		app.ErrorLog.Printf("Invalid IP address or subnet -> %s", err)
 		helpers.Error(response, "Invalid Request Body", http.StatusBadRequest)
-	}
-	stringSlice := strings.Split(*validIP, "/")
-	prefix, err := strconv.ParseUint(stringSlice[1], 10, 32)
-	if err != nil {
-		errMsg := "Input prefix cannot be parsed to unit32 -> " + err.Error()
-		app.ErrorLog.Print(errMsg)
-		helpers.Error(response, errMsg, http.StatusBadRequest)
 		return
 	}
-	//Convert the IP address to decimal with big endian format
-	decimalIP, err := helpers.IP4toInt(stringSlice[0])
-	if err != nil {
-		app.ErrorLog.Printf("Cannot convert input IP address to big endian decimal format -> %s", err)
-		helpers.Error(response, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-		return
-	}
-	key := BpfIpv4LpmKey{
-		Prefixlen: uint32(prefix),
-		Target:    *decimalIP,
-	}
+	output := blockOutput{Target: prefix.String()}
 
-	if *body.Action == "block" {
-		err = app.BpfObjects.BlockedIpv4.Update(&key, uint8(1), ebpf.UpdateAny)
+	if *body.Action == "block" || *body.Action == "ratelimit" {
+		var protocol, srcPort, dstPort string
+		if body.Protocol != nil {
+			protocol = *body.Protocol
+		}
+		if body.SrcPort != nil {
+			srcPort = *body.SrcPort
+		}
+		if body.DstPort != nil {
+			dstPort = *body.DstPort
+		}
+		rule, err := parseRule(prefix, protocol, srcPort, dstPort)
+		if err != nil {
+			app.ErrorLog.Printf("Invalid protocol or ports -> %s", err)
+			helpers.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var mode string
+		if body.Mode != nil {
+			mode = *body.Mode
+		}
+		rule.Mode, err = parseRuleMode(mode)
+		if err != nil {
+			helpers.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if *body.Action == "ratelimit" {
+			var pps, bps uint64
+			if body.Pps != nil {
+				pps = *body.Pps
+			}
+			if body.Bps != nil {
+				bps = *body.Bps
+			}
+			err = setRateLimit(&rule, pps, bps)
+			if err != nil {
+				app.ErrorLog.Printf("Invalid rate limit -> %s", err)
+				helpers.Error(response, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		//the XDP program ignores the rule once its timeout is finished
+		rule.ExpiresNs, err = ruleExpiry(*body.Timeout)
+		if errors.Is(err, errTimeoutTooLong) {
+			helpers.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
+			app.ErrorLog.Printf("Cannot read the monotonic clock -> %s", err)
+			helpers.Error(response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		before := app.ruleSnapshot(prefix)
+		err = app.addPrefix(prefix, rule)
+		if err != nil {
+			app.audit(requestActor(request), auditBlock, prefix.String(), before, before, err)
 			app.InfoLog.Print(err)
-			helpers.Error(response, "Unable to update blocked_ipv4 LPM map", http.StatusInternalServerError)
+			if errors.Is(err, errRuleConflict) {
+				helpers.Error(response, err.Error(), http.StatusConflict)
+				return
+			}
+			if errors.Is(err, errRuleTableFull) {
+				helpers.Error(response, err.Error(), http.StatusInsufficientStorage)
+				return
+			}
+			helpers.Error(response, "Unable to update blocked LPM map", http.StatusInternalServerError)
 			return
 		}
-
-		// Check if the key exists in the timeout map or not
-		// _, ok := app.TimeoutList[key]
-
-		if *body.Timeout != 0 {
-			app.TimeoutList[key] = time.Now().Add(time.Duration(*body.Timeout) * time.Second)
+		//rules set through the API are never removed by a threat feed
+		var comment string
+		if body.Comment != nil {
+			comment = *body.Comment
+		}
+		app.setRuleMetadata(prefix, originAPI, requestOwner(request, body.Owner), comment)
+		app.audit(requestActor(request), auditBlock, prefix.String(), before, app.ruleSnapshot(prefix), nil)
+		output.Note = app.ruleNotes([]blockedRule{{Prefix: prefix, Rule: rule}})[0]
+		if output.Note != "" {
+			app.InfoLog.Printf("Rule %s is %s, POST /rules/optimize compacts the blocked rules", prefix, output.Note)
 		}
 
 	} else if *body.Action == "allow" {
-		err = app.BpfObjects.BlockedIpv4.Delete(&key)
+		before := app.ruleSnapshot(prefix)
+		err = app.unblockPrefix(prefix)
+		app.audit(requestActor(request), auditUnblock, prefix.String(), before, app.ruleSnapshot(prefix), err)
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			message, status := app.notBlockedError(prefix)
+			helpers.Error(response, message, status)
+			return
+		}
 		if err != nil {
 			app.InfoLog.Print(err.Error())
-			helpers.Error(response, "IP address or subnet already not blocked", http.StatusInternalServerError)
+			helpers.Error(response, "Unable to update blocked LPM map", http.StatusInternalServerError)
 			return
 		}
-		delete(app.TimeoutList, key)
+		app.deleteRuleMetadata(prefix)
 
 	} else {
 		helpers.Error(response, "Bad input action", http.StatusBadRequest)
+		return
 	}
-	response.WriteHeader(200)
+	app.persistState()
+	app.writeJSON(response, http.StatusOK, output)
+}
+
+// apply the rules of a batch to the blocked maps and return the result of every rule
+func (app *Application) xdpBlockBatch(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "application/json")
+	//Request body parsing
+	var body batchLoad
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot parse json request -> %v\n", err)
+		helpers.Error(response, "Invalid Request Body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Rules) == 0 {
+		app.ErrorLog.Printf("Request body does not include rules")
+		helpers.Error(response, "Request body does not include rules", http.StatusBadRequest)
+		return
+	}
+
+	output := batchOutput{Results: make([]batchResult, len(body.Rules))}
+	//the rules are split between the block and allow actions, index keeps their position in the results
+	var blocked []blockedRule
+	var blockedIndex []int
+	var allowed []netip.Prefix
+	var allowedIndex []int
+	for i, entry := range body.Rules {
+		output.Results[i] = batchResult{Target: entry.Target, Status: http.StatusOK}
+		if entry.Action == "allow" {
+			prefix, err := parsePrefix(entry.Target)
+			if err != nil {
+				output.Results[i].Status = http.StatusBadRequest
+				output.Results[i].Message = "Invalid IP address or subnet"
+				continue
+			}
+			allowed = append(allowed, prefix)
+			allowedIndex = append(allowedIndex, i)
+			continue
+		}
+		prefix, rule, err := entry.rule()
+		if err != nil {
+			output.Results[i].Status = http.StatusBadRequest
+			output.Results[i].Message = err.Error()
+			continue
+		}
+		//the XDP program ignores the rule once its timeout is finished
+		rule.ExpiresNs, err = ruleExpiry(entry.Timeout)
+		if errors.Is(err, errTimeoutTooLong) {
+			output.Results[i].Status = http.StatusBadRequest
+			output.Results[i].Message = err.Error()
+			continue
+		}
+		if err != nil {
+			app.ErrorLog.Printf("Cannot read the monotonic clock -> %s", err)
+			helpers.Error(response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		blocked = append(blocked, blockedRule{Prefix: prefix, Rule: rule})
+		blockedIndex = append(blockedIndex, i)
+	}
+
+	//the rules before the change for the audit log
+	actor := requestActor(request)
+	blockedBefore := make([]*ruleEntry, len(blocked))
+	for i := range blocked {
+		blockedBefore[i] = app.ruleSnapshot(blocked[i].Prefix)
+	}
+	allowedBefore := make([]*ruleEntry, len(allowed))
+	for i := range allowed {
+		allowedBefore[i] = app.ruleSnapshot(allowed[i])
+	}
+
+	var written []blockedRule
+	var writtenIndex []int
+	for i, err := range app.addPrefixes(blocked) {
+		if err != nil {
+			app.audit(actor, auditBlock, blocked[i].Prefix.String(), blockedBefore[i], blockedBefore[i], err)
+			app.InfoLog.Print(err)
+			output.Results[blockedIndex[i]].Status = http.StatusInternalServerError
+			output.Results[blockedIndex[i]].Message = "Unable to update blocked LPM map"
+			if errors.Is(err, errRuleConflict) {
+				output.Results[blockedIndex[i]].Status = http.StatusConflict
+				output.Results[blockedIndex[i]].Message = err.Error()
+			}
+			if errors.Is(err, errRuleTableFull) {
+				output.Results[blockedIndex[i]].Status = http.StatusInsufficientStorage
+				output.Results[blockedIndex[i]].Message = err.Error()
+			}
+			continue
+		}
+		//rules set through the API are never removed by a threat feed
+		entry := body.Rules[blockedIndex[i]]
+		app.setRuleMetadata(blocked[i].Prefix, originAPI, requestOwner(request, &entry.Owner), entry.Comment)
+		app.audit(actor, auditBlock, blocked[i].Prefix.String(), blockedBefore[i], app.ruleSnapshot(blocked[i].Prefix), nil)
+		written = append(written, blocked[i])
+		writtenIndex = append(writtenIndex, blockedIndex[i])
+	}
+	//the rules are written as requested, the shadowed and mergeable ones are pointed out
+	if len(written) > 0 {
+		for i, note := range app.ruleNotes(written) {
+			output.Results[writtenIndex[i]].Message = note
+		}
+	}
+	for i, err := range app.unblockPrefixes(allowed) {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			app.audit(actor, auditUnblock, allowed[i].String(), nil, nil, err)
+		} else if err != nil {
+			app.audit(actor, auditUnblock, allowed[i].String(), allowedBefore[i], allowedBefore[i], err)
+		} else {
+			app.audit(actor, auditUnblock, allowed[i].String(), allowedBefore[i], nil, nil)
+		}
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			output.Results[allowedIndex[i]].Message, output.Results[allowedIndex[i]].Status = app.notBlockedError(allowed[i])
+		} else if err != nil {
+			app.InfoLog.Print(err)
+			output.Results[allowedIndex[i]].Status = http.StatusInternalServerError
+			output.Results[allowedIndex[i]].Message = "Unable to update blocked LPM map"
+		} else {
+			app.deleteRuleMetadata(allowed[i])
+		}
+	}
+	for _, result := range output.Results {
+		if result.Status == http.StatusOK {
+			output.Applied++
+		} else {
+			output.Failed++
+		}
+	}
+	app.InfoLog.Printf("Batch of %d rules applied, %d failed", output.Applied, output.Failed)
+
+	if output.Applied > 0 {
+		app.persistState()
+	}
+	finalResponse, err := json.Marshal(output)
+	if err != nil {
+		app.ErrorLog.Println("Unable to parse json data", err)
+		helpers.Error(response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	response.Write(finalResponse)
 	return
 }
 
 func (app *Application) xdpStatus(response http.ResponseWriter, request *http.Request) {
-	var output statusMapOutput
+	app.writeJSON(response, http.StatusOK, app.statusOutput())
+}
 
-	//prepare status for the blocked IP addresses
-	statusMapOutput := []statusMapJson{}
-	iter := app.BpfObjects.Status.Iterate()
-	//the key to single status map is ip address
-	var key netip.Addr
-	//Since the status map is LRU per cpu hash map then the returned value for each key is array size equal to the cpu cores
-	val := make([]bpfStatusMapVal, runtime.NumCPU())
-	for iter.Next(&key, &val) {
-		var src_packets uint64 = 0
-		var src_size_packets uint64 = 0
-		var dst_packets uint64 = 0
-		var dst_size_packets uint64 = 0
-		for _, value := range val {
-			src_packets += value.SrcPackets
-			src_size_packets += value.SrcSizePackets
-			dst_packets += value.DstPackets
-			dst_size_packets += value.DstSizePackets
-		}
-		statusMapOutput = append(statusMapOutput, statusMapJson{
-			Target:          key,
-			Src_packets:      src_packets,
-			Src_size_packets: src_size_packets,
-			Dst_packets:      dst_packets,
-			Dst_size_packets: dst_size_packets,
-		})
+// xdpPublicStatus is /status of the public router, the comments, owners and origins of the rules are left out
+// because the public router has no authentication
+func (app *Application) xdpPublicStatus(response http.ResponseWriter, request *http.Request) {
+	output := app.statusOutput()
+	for i := range output.Rules {
+		output.Rules[i].ruleMetadata = nil
 	}
-	if err := iter.Err(); err != nil {
+	app.writeJSON(response, http.StatusOK, output)
+}
+
+// statusOutput collects the interfaces, rules, allow list, timeouts and counters shown by /status
+func (app *Application) statusOutput() statusMapOutput {
+	var output statusMapOutput
+
+	//prepare status for the blocked IPv4 and IPv6 addresses with the most dropped packets
+	statusMapOutput := app.addressStats()
+
+	//prepare the blocked IP addresses and their rules from the LPM maps
+	blockedRules, err := app.blockedRules()
+	if err != nil {
 		app.InfoLog.Print(err)
 	}
-
-	//prepare the blocked IP addresses from the LPM map
 	blockedMapOutput := []string{}
-	var blockedMapKey uint64
-	var blockedMapVal uint8
-	iter = app.BpfObjects.BlockedIpv4.Iterate()
-	for iter.Next(&blockedMapKey, &blockedMapVal) {
-		ip := (uint32)((blockedMapKey & 0xFFFFFFFF00000000) >> 32)
-		prefix := (uint32)(blockedMapKey & 0xFFFFFFFF)
-		blockedMapOutput = append(blockedMapOutput, fmt.Sprintf("%s/%d", helpers.IntToIPv4(ip), prefix))
-
+	for _, value := range blockedRules {
+		blockedMapOutput = append(blockedMapOutput, value.Prefix.String())
 	}
-	if err := iter.Err(); err != nil {
+	rulesOutput := app.blockedOutput(blockedRules)
+
+	//prepare the passed and limited packets of the rate limited sources
+	ratelimitOutput := app.readRatelimitMap()
+
+	//prepare the allowed IP addresses from the LPM maps
+	allowedMapOutput := []string{}
+	allowedPrefixes, err := app.allowedPrefixes()
+	if err != nil {
 		app.InfoLog.Print(err)
 	}
+	for _, prefix := range allowedPrefixes {
+		allowedMapOutput = append(allowedMapOutput, prefix.String())
+	}
 
 	//Prepare the name of the interfaces that the XDP program is loaded to
-	loadedInterfaces := []string{}
-	for key := range app.LoadedInterfaces {
-		loadedInterfaces = append(loadedInterfaces, key)
-	}
+	loadedInterfaces := app.loadedInterfaces()
 
 	//prepare the timeouts of the blocked subnets
 	timeoutOutput := []statusTimeoutOutput{}
-	for targetKey, timeValue := range app.TimeoutList {
+	for _, value := range blockedRules {
+		if value.Rule.ExpiresNs == 0 {
+			continue
+		}
+		timeValue, err := ruleDeadline(value.Rule.ExpiresNs)
+		if err != nil {
+			app.InfoLog.Print(err)
+			break
+		}
 		timeoutOutput = append(timeoutOutput, statusTimeoutOutput{
-			Target:    helpers.IntToIPv4(targetKey.Target) + "/" + strconv.FormatUint(uint64(targetKey.Prefixlen), 10),
+			Target:    value.Prefix.String(),
 			Timeout:   timeValue.Format("2006-01-02 15:04:05"),
 			Remaining: int(timeValue.Sub(time.Now()).Seconds()),
 		})
@@ -287,19 +460,15 @@ func (app *Application) xdpStatus(response http.ResponseWriter, request *http.Re
 
 	//prepare our output
 	output.Blocked = blockedMapOutput
+	output.Rules = rulesOutput
+	output.Allowed = allowedMapOutput
+	output.Ratelimited = ratelimitOutput
 	output.Status = statusMapOutput
 	output.Interfaces = loadedInterfaces
 	output.Timeout = timeoutOutput
-
-	finalResponse, err := json.Marshal(output)
-	if err != nil {
-		app.ErrorLog.Println("Unable to parse json data", err)
-		helpers.Error(response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
-	}
-	response.Header().Set("Content-Type", "application/json")
-	response.Write(finalResponse)
-	return
+	output.Verdicts = app.readVerdictsMap()
+	output.Monitor = app.settings().Monitor
+	return output
 }
 
 // status XDP programs
@@ -307,36 +476,25 @@ func (app *Application) xdpBlockedFlush(response http.ResponseWriter, request *h
 	response.Header().Set("Content-Type", "application/json")
 
 	//the array that will store the blocked IP addresses and subnets
-	var blockedMap []BpfIpv4LpmKey
-	//prepare the blocked IP addresses from the LPM map
-	var blockedMapKey uint64
-	var blockedMapVal uint8
-	iter := app.BpfObjects.BlockedIpv4.Iterate()
-	for iter.Next(&blockedMapKey, &blockedMapVal) {
-		ip := (uint32)((blockedMapKey & 0xFFFFFFFF00000000) >> 32)
-		prefix := (uint32)(blockedMapKey & 0xFFFFFFFF)
-		//append the returned subnet to the blockedMap
-		key := BpfIpv4LpmKey{
-			Prefixlen: prefix,
-			Target:    ip,
-		}
-		blockedMap = append(blockedMap, key)
-	}
-	if err := iter.Err(); err != nil {
+	blockedMap, err := app.blockedRules()
+	if err != nil {
 		app.InfoLog.Print(err)
 	}
 
 	//loop on the blocked map and unblock the subnets
 	for _, value := range blockedMap {
-		err := app.BpfObjects.BlockedIpv4.Delete(&value)
+		before := app.ruleSnapshot(value.Prefix)
+		err := app.unblockPrefix(value.Prefix)
+		app.audit(requestActor(request), auditFlushBlocked, value.Prefix.String(), before, app.ruleSnapshot(value.Prefix), err)
 		if err != nil {
 			app.InfoLog.Print(err.Error())
 			helpers.Error(response, "IP address or subnet already not blocked", http.StatusInternalServerError)
 			return
 		}
-		delete(app.TimeoutList, value)
+		app.deleteRuleMetadata(value.Prefix)
 	}
 
+	app.persistState()
 	response.WriteHeader(200)
 	return
 }
@@ -345,10 +503,109 @@ func (app *Application) xdpBlockedFlush(response http.ResponseWriter, request *h
 func (app *Application) xdpStatusFlush(response http.ResponseWriter, request *http.Request) {
 	response.Header().Set("Content-Type", "application/json")
 
-	//prepare status array for the IP addresses in the status map
-	statusMapOutput := []netip.Addr{}
-	iter := app.BpfObjects.Status.Iterate()
-	//the key of the status map is single IP address
+	err := app.flushStatus()
+	app.audit(requestActor(request), auditFlushStatus, "", nil, nil, err)
+	if err != nil {
+		app.InfoLog.Print(err.Error())
+		helpers.Error(response, "Unable to empty the status maps", http.StatusInternalServerError)
+		return
+	}
+
+	response.WriteHeader(200)
+	return
+}
+
+// flushStatus empties the IPv4 and IPv6 status maps and the ratelimit token buckets and zeroes the rule counters
+func (app *Application) flushStatus() error {
+	if err := app.flushRuleCounters(); err != nil {
+		return err
+	}
+	for _, statusMap := range []*ebpf.Map{app.BpfObjects.Status, app.BpfObjects.StatusIpv6, app.BpfObjects.Ratelimit} {
+		//prepare status array for the keys in the status map
+		statusMapOutput := [][]byte{}
+		//the keys are IP addresses, or rule IDs and addresses for the ratelimit map
+		key := make([]byte, statusMap.KeySize())
+		var previousKey any
+		for {
+			err := statusMap.NextKey(previousKey, key)
+			if errors.Is(err, ebpf.ErrKeyNotExist) {
+				break
+			}
+			if err != nil {
+				app.InfoLog.Print(err)
+				break
+			}
+			next := bytes.Clone(key)
+			statusMapOutput = append(statusMapOutput, next)
+			previousKey = next
+		}
+
+		//loop on the status map and remove them from the map the subnets
+		for _, value := range statusMapOutput {
+			err := statusMap.Delete(value)
+			if err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// blockedOutput converts the blocked rules and their metadata to the status output
+func (app *Application) blockedOutput(rules []blockedRule) []statusBlockedOutput {
+	output := []statusBlockedOutput{}
+	metadata := app.ruleMetadataOf(rules)
+	//a rule is shadowed by the rules outside of the list too
+	live := map[netip.Prefix]bpfRule{}
+	all, err := app.blockedRules()
+	if err != nil {
+		app.InfoLog.Print(err)
+	}
+	for _, value := range all {
+		live[value.Prefix] = value.Rule
+	}
+	for _, value := range rules {
+		blocked := statusBlockedOutput{
+			Target:   value.Prefix.String(),
+			Action:   ruleActionName(value.Rule.Action),
+			Mode:     ruleModeName(value.Rule.Mode),
+			Pps:      value.Rule.RatePps,
+			Bps:      uint64(value.Rule.RateBytes) * 8,
+			Protocol: helpers.ProtocolName(value.Rule.Protocol),
+			SrcPort:  helpers.PortRange(value.Rule.SrcPortMin, value.Rule.SrcPortMax),
+			DstPort:  helpers.PortRange(value.Rule.DstPortMin, value.Rule.DstPortMax),
+		}
+		saved := metadata[value.Prefix]
+		blocked.ruleMetadata = &saved
+		counters, err := app.ruleCounters(value.Rule.Id)
+		if err != nil {
+			app.InfoLog.Print(err)
+		}
+		blocked.Packets = counters.Packets
+		blocked.Bytes = counters.Bytes
+		blocked.MonitoredPackets = counters.MonitoredPackets
+		blocked.MonitoredBytes = counters.MonitoredBytes
+		if parent, ok := shadowingRule(live, value.Prefix, value.Rule); ok {
+			blocked.ShadowedBy = parent.String()
+		}
+		if value.Rule.ExpiresNs != 0 {
+			deadline, err := ruleDeadline(value.Rule.ExpiresNs)
+			if err != nil {
+				app.InfoLog.Print(err)
+			} else {
+				blocked.Expires = &deadline
+			}
+		}
+		output = append(output, blocked)
+	}
+	return output
+}
+
+// readStatusMap sums the per cpu counters of every IP address in the status map
+func (app *Application) readStatusMap(statusMap *ebpf.Map) []statusMapJson {
+	statusMapOutput := []statusMapJson{}
+	iter := statusMap.Iterate()
+	//the key to single status map is ip address
 	var key netip.Addr
 	//Since the status map is LRU per cpu hash map then the returned value for each key is array size equal to the cpu cores
 	val := make([]bpfStatusMapVal, runtime.NumCPU())
@@ -363,22 +620,215 @@ func (app *Application) xdpStatusFlush(response http.ResponseWriter, request *ht
 			dst_packets += value.DstPackets
 			dst_size_packets += value.DstSizePackets
 		}
-		statusMapOutput = append(statusMapOutput, key)
+		statusMapOutput = append(statusMapOutput, statusMapJson{
+			Target:           key,
+			Src_packets:      src_packets,
+			Src_size_packets: src_size_packets,
+			Dst_packets:      dst_packets,
+			Dst_size_packets: dst_size_packets,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		app.InfoLog.Print(err)
+	}
+	return statusMapOutput
+}
+
+// list the allowed IP addresses and subnets
+func (app *Application) xdpAllowList(response http.ResponseWriter, request *http.Request) {
+	allowedPrefixes, err := app.allowedPrefixes()
+	if err != nil {
+		app.ErrorLog.Print(err)
+		helpers.Error(response, "Unable to read the allowed LPM maps", http.StatusInternalServerError)
+		return
+	}
+	allowedOutput := []string{}
+	for _, prefix := range allowedPrefixes {
+		allowedOutput = append(allowedOutput, prefix.String())
+	}
+	finalResponse, err := json.Marshal(allowedOutput)
+	if err != nil {
+		app.ErrorLog.Println("Unable to parse json data", err)
+		helpers.Error(response, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(finalResponse)
+}
+
+// indexes of the counters of the verdicts map
+const (
+	verdictPassed = iota
+	verdictDroppedSrc
+	verdictDroppedDst
+	verdictDroppedGre
+	verdictAborted
+	verdictNonIPPassed
+	verdictMonitored
+)
+
+// readVerdictsMap sums the per CPU verdict counters of every interface that saw a packet
+func (app *Application) readVerdictsMap() []verdictOutput {
+	output := []verdictOutput{}
+	iter := app.BpfObjects.Verdicts.Iterate()
+	var ifindex uint32
+	val := make([]bpfVerdictCounters, runtime.NumCPU())
+	for iter.Next(&ifindex, &val) {
+		var total bpfVerdictCounters
+		for _, value := range val {
+			for i := range total.Packets {
+				total.Packets[i] += value.Packets[i]
+				total.Bytes[i] += value.Bytes[i]
+			}
+		}
+		if total == (bpfVerdictCounters{}) {
+			continue
+		}
+		count := func(verdict int) verdictCount {
+			return verdictCount{Packets: total.Packets[verdict], Bytes: total.Bytes[verdict]}
+		}
+		name := "other"
+		if ifindex != 0 {
+			name = strconv.Itoa(int(ifindex))
+			if iface, err := net.InterfaceByIndex(int(ifindex)); err == nil {
+				name = iface.Name
+			}
+		}
+		output = append(output, verdictOutput{
+			Interface:   name,
+			Ifindex:     ifindex,
+			Passed:      count(verdictPassed),
+			DroppedSrc:  count(verdictDroppedSrc),
+			DroppedDst:  count(verdictDroppedDst),
+			DroppedGre:  count(verdictDroppedGre),
+			Aborted:     count(verdictAborted),
+			NonIPPassed: count(verdictNonIPPassed),
+			Monitored:   count(verdictMonitored),
+		})
 	}
 	if err := iter.Err(); err != nil {
 		app.InfoLog.Print(err)
 	}
+	return output
+}
+
+// add an IP address or subnet to the allow list, allowed targets pass even when a blocked subnet covers them
+func (app *Application) xdpAllowListAdd(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "application/json")
+	prefix, ok := app.allowListTarget(response, request)
+	if !ok {
+		return
+	}
+	before := app.allowedSnapshot(prefix)
+	err := app.allowPrefix(prefix)
+	app.audit(requestActor(request), auditAllowList, prefix.String(), before, app.allowedSnapshot(prefix), err)
+	if err != nil {
+		app.InfoLog.Print(err)
+		helpers.Error(response, "Unable to update allowed LPM map", http.StatusInternalServerError)
+		return
+	}
+	app.persistState()
+	response.WriteHeader(200)
+}
+
+// remove an IP address or subnet from the allow list
+func (app *Application) xdpAllowListRemove(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "application/json")
+	prefix, ok := app.allowListTarget(response, request)
+	if !ok {
+		return
+	}
+	before := app.allowedSnapshot(prefix)
+	err := app.disallowPrefix(prefix)
+	app.audit(requestActor(request), auditUnallowList, prefix.String(), before, app.allowedSnapshot(prefix), err)
+	if errors.Is(err, ebpf.ErrKeyNotExist) {
+		helpers.Error(response, "IP address or subnet is not in the allow list", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.InfoLog.Print(err)
+		helpers.Error(response, "Unable to update allowed LPM map", http.StatusInternalServerError)
+		return
+	}
+	app.persistState()
+	response.WriteHeader(200)
+}
 
-	//loop on the status map and remove them from the map the subnets
-	for _, value := range statusMapOutput {
-		err := app.BpfObjects.Status.Delete(&value)
+// remove all the IP addresses and subnets from the allow list
+func (app *Application) xdpAllowedFlush(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "application/json")
+	allowedPrefixes, err := app.allowedPrefixes()
+	if err != nil {
+		app.InfoLog.Print(err)
+	}
+	for _, value := range allowedPrefixes {
+		before := &allowedEntry{Target: value.String()}
+		err := app.disallowPrefix(value)
+		if err != nil {
+			app.audit(requestActor(request), auditFlushAllowed, value.String(), before, before, err)
+		} else {
+			app.audit(requestActor(request), auditFlushAllowed, value.String(), before, nil, nil)
+		}
 		if err != nil {
 			app.InfoLog.Print(err.Error())
-			helpers.Error(response, "IP address or subnet already not blocked", http.StatusInternalServerError)
+			helpers.Error(response, "Unable to update allowed LPM map", http.StatusInternalServerError)
 			return
 		}
 	}
-
+	app.persistState()
 	response.WriteHeader(200)
-	return
+}
+
+// allowListTarget parses the target of the allow list requests, on failure the error is already written to the response
+func (app *Application) allowListTarget(response http.ResponseWriter, request *http.Request) (netip.Prefix, bool) {
+	var body blockLoad
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot parse json request -> %v\n", err)
+		helpers.Error(response, "Invalid Request Body", http.StatusBadRequest)
+		return netip.Prefix{}, false
+	}
+	if body.Target == nil {
+		app.ErrorLog.Printf("Request body does not include target")
+		helpers.Error(response, "Request body does not include target", http.StatusBadRequest)
+		return netip.Prefix{}, false
+	}
+	prefix, err := parsePrefix(*body.Target)
+	if err != nil {
+		app.ErrorLog.Printf("Invalid IP address or subnet -> %s", err)
+		helpers.Error(response, "Invalid Request Body", http.StatusBadRequest)
+		return netip.Prefix{}, false
+	}
+	return prefix, true
+}
+
+// readRatelimitMap sums the per cpu passed and limited packets of every rule and source in the ratelimit map
+func (app *Application) readRatelimitMap() []statusRatelimitOutput {
+	ratelimitOutput := []statusRatelimitOutput{}
+	prefixes := app.rulePrefixes()
+	iter := app.BpfObjects.Ratelimit.Iterate()
+	//the key of the ratelimit map is the rule ID and the IPv6 or IPv4-mapped source address
+	var key bpfRatelimitKey
+	val := make([]bpfTokenBucket, runtime.NumCPU())
+	for iter.Next(&key, &val) {
+		var passed uint64 = 0
+		var limited uint64 = 0
+		for _, value := range val {
+			passed += value.PassedPackets
+			limited += value.LimitedPackets
+		}
+		output := statusRatelimitOutput{
+			Target:  netip.AddrFrom16(key.Addr).Unmap(),
+			Passed:  passed,
+			Limited: limited,
+		}
+		if prefix, ok := prefixes[key.RuleId]; ok {
+			output.Rule = prefix.String()
+		}
+		ratelimitOutput = append(ratelimitOutput, output)
+	}
+	if err := iter.Err(); err != nil {
+		app.InfoLog.Print(err)
+	}
+	return ratelimitOutput
 }
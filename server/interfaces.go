@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/cilium/ebpf/link"
+)
+
+// XDP attach modes accepted by the load requests
+var xdpModes = map[string]link.XDPAttachFlags{
+	"hw":  link.XDPOffloadMode,
+	"skb": link.XDPGenericMode,
+	"nv":  link.XDPDriverMode,
+}
+
+var (
+	// errNotLoaded is returned when the interface has no XDP program attached by the service
+	errNotLoaded = errors.New("no XDP code loaded to the interface")
+	// errNoInterface is returned when the interface does not exist on the host
+	errNoInterface = errors.New("interface does not exists")
+)
+
+// attachXDP attaches the firewall program to the interface with the given mode,
+// interfaces that already have the program attached are left untouched
+func (app *Application) attachXDP(name string, mode string) error {
+	loadMode, ok := xdpModes[mode]
+	if !ok {
+		return errors.New("Invalid Mode")
+	}
+	app.interfacesLock.Lock()
+	defer app.interfacesLock.Unlock()
+
+	//check if XDP code is already loaded
+	if _, ok := app.LoadedInterfaces[name]; ok {
+		app.InfoLog.Print("XDP is already loaded to the interface: " + name)
+		return nil
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("%w %s -> %v", errNoInterface, name, err)
+	}
+	l, err := link.AttachXDP(link.XDPOptions{
+		Program:   app.BpfObjects.Firewall,
+		Interface: iface.Index,
+		Flags:     loadMode,
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot attach XDP to %s XDP might be already loaded to the interface  -> %w", name, err)
+	}
+	//keep the program attached when the service restarts
+	err = app.pinLink(name, mode, l)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot pin the XDP link of %s -> %v", name, err)
+	}
+	app.LoadedInterfaces[name] = l
+	app.InterfaceModes[name] = mode
+	return nil
+}
+
+// ensureXDP attaches the firewall program to the interface with the given mode, an interface
+// attached with another mode is detached first. It reports whether the interface was changed
+func (app *Application) ensureXDP(name string, mode string) (bool, error) {
+	if _, ok := xdpModes[mode]; !ok {
+		return false, errors.New("Invalid Mode")
+	}
+	app.interfacesLock.Lock()
+	current, loaded := app.InterfaceModes[name]
+	_, attached := app.LoadedInterfaces[name]
+	app.interfacesLock.Unlock()
+	if attached && (!loaded || current == mode) {
+		return false, nil
+	}
+	//reattach the interfaces whose mode changed
+	if attached {
+		if err := app.detachXDP(name); err != nil {
+			return false, err
+		}
+	}
+	return true, app.attachXDP(name, mode)
+}
+
+// interfaceMode returns the XDP mode of the interface and whether the program is attached to it,
+// the mode is empty for the links pinned by releases that did not record it
+func (app *Application) interfaceMode(name string) (string, bool) {
+	app.interfacesLock.Lock()
+	defer app.interfacesLock.Unlock()
+	_, attached := app.LoadedInterfaces[name]
+	return app.InterfaceModes[name], attached
+}
+
+// detachXDP removes the firewall program from the interface
+func (app *Application) detachXDP(name string) error {
+	app.interfacesLock.Lock()
+	defer app.interfacesLock.Unlock()
+
+	l, ok := app.LoadedInterfaces[name]
+	if !ok {
+		return errNotLoaded
+	}
+	err := app.detachLink(l)
+	if err != nil {
+		return err
+	}
+	delete(app.LoadedInterfaces, name)
+	delete(app.InterfaceModes, name)
+	return nil
+}
+
+// loadedInterfaces returns the sorted names of the interfaces that the XDP program is loaded to
+func (app *Application) loadedInterfaces() []string {
+	app.interfacesLock.Lock()
+	defer app.interfacesLock.Unlock()
+
+	names := []string{}
+	for name := range app.LoadedInterfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,23 +1,32 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"time"
 
 	"github.com/ahsifer/goxdp/client"
 	"github.com/ahsifer/goxdp/helpers"
 	"github.com/cilium/ebpf/link"
+	"gopkg.in/yaml.v3"
 
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"os/user"
+	"strings"
+	"syscall"
 )
 
 //go:generate go run github.com/cilium/ebpf/cmd/bpf2go bpf ../source/xdp.c -- -I../headers
 
 func main() {
-	defMessage := "Error: Bad input parameters:> \nUsage:\n \tgoxdp <command> <options> \navailable commands are:\n\tserver \tstart XDP HTTP server for handling users requests\n\tclient\tinteract with the XDP server\nFlags:\n\t-h,--h\tfor help"
+	defMessage := "Error: Bad input parameters:> \nUsage:\n \tgoxdp <command> <options> \navailable commands are:\n\tserver \tstart XDP HTTP server for handling users requests\n\tclient\tinteract with the XDP server\n\ttoken\tgenerate an API token and its entry of the tokens file\nFlags:\n\t-h,--h\tfor help"
 	if len(os.Args) <= 1 {
 		log.Fatal(defMessage)
 	}
@@ -28,51 +37,232 @@ func main() {
 	privatePort := serverFlags.String("privatePort", "8090", "The private Port number the service will listen to")
 	publicIP := serverFlags.String("publicIP", *privateIP, "The public IP address the service will listen to that will be used to respond to metrics and status requests")
 	publicPort := serverFlags.String("publicPort", "8091", "The public Port number the service will listen to")
-	timeoutWorkerInterval := serverFlags.Int("timeoutinterval", 5, "The timeout of the worker thread to check if subnet or IP address timeout is finished")
+	timeoutWorkerInterval := serverFlags.Int("timeoutinterval", 5, "How often the worker thread removes the expired subnets and IP addresses from the maps, expired rules are ignored by the XDP program right away")
+	stateDir := serverFlags.String("statedir", "/var/lib/goxdp", "The directory that stores the blocked and allowed IP addresses and subnets to restore them on start, empty value disables it")
+	pinPath := serverFlags.String("pinpath", "/sys/fs/bpf/goxdp", "The bpffs directory that the maps and XDP links are pinned to so they survive restarts of the service, empty value disables pinning")
+	configPath := serverFlags.String("config", "", "The YAML configuration file with the listen addresses, interfaces, and rules of the service, the interfaces and rules are reloaded on SIGHUP")
+	tokensPath := serverFlags.String("tokens", "", "The YAML file of the API tokens and their scopes, the private routes need a bearer token when it is set and the file is reloaded on SIGHUP")
+	tlsCert := serverFlags.String("tls-cert", "", "The PEM certificate that both listeners serve HTTPS with, it is reloaded on SIGHUP")
+	tlsKey := serverFlags.String("tls-key", "", "The PEM private key of the -tls-cert certificate")
+	clientCA := serverFlags.String("client-ca", "", "The PEM CA bundle that the client certificates of the private listener must be signed by, the common name of the certificate is the identity of the requester, it is reloaded on SIGHUP")
+	auditPath := serverFlags.String("audit-log", "/var/log/goxdp/audit.jsonl", "The append-only JSONL file that records every change of the interfaces and rules with its actor, empty value disables it")
+	auditMaxSize := serverFlags.Int64("audit-max-size", 100, "The size in megabytes after which the audit log is rotated")
+	auditMaxBackups := serverFlags.Int("audit-max-backups", 10, "How many rotated audit log files are kept")
+	maxRules := serverFlags.Uint("max-rules", 10000, "How many IP addresses and subnets each of the IPv4 and IPv6 blocked and allowed maps holds, the maps are sized when the XDP program is loaded")
+	maxStatsEntries := serverFlags.Uint("max-stats-entries", 10000, "How many addresses each of the status maps and the rate limit map holds, the least recently used ones are replaced when they are full")
+	addressStats := serverFlags.Int("address-stats", 100, "How many addresses with the most dropped packets are listed by the status routes, 0 stops counting the dropped packets of every address and only the rules are counted")
+	metricsMaxAddresses := serverFlags.Int("metrics-max-addresses", 100, "How many addresses with the most dropped packets get their own series in the metrics, 0 exports only the totals")
+	metricsMaxRules := serverFlags.Int("metrics-max-rules", 1000, "How many blocked rules with the most dropped or monitored packets get their own series in the metrics, 0 exports only the totals")
+	sampleRate := serverFlags.Uint("sample-rate", 100, "One in how many of the packets dropped by every rule are sampled while a capture is running, 0 disables the captures")
+	monitor := serverFlags.Bool("monitor", false, "Count the packets matched by every blocked rule as monitored without dropping them, to measure the impact of the rules before they are enforced")
+	eventsInterval := serverFlags.Int("events-interval", 5, "How often in seconds the packets dropped by every rule are sent to the subscribers of /events, 0 only sends the changes of the rules and interfaces")
+	detachOnExit := serverFlags.Bool("detach-on-exit", false, "Unload the XDP program from the interfaces when the service stops, by default the pinned XDP programs keep filtering")
 	// Handling Client Flags
 	clientFlags := flag.NewFlagSet("client", flag.ExitOnError)
-	actionClient := clientFlags.String("action", "", "Available values are load,unload,block, allow, status")
+	actionClient := clientFlags.String("action", "", "Available values are load,unload,block, allow, ratelimit, allowlist, unallowlist, status, optimize, watch, capture")
 	interfacesClient := clientFlags.String("interfaces", "", "Interfaces names that the XDP programme will be loaded to (Example 'eth0,eth1')")
-	modeClient := clientFlags.String("mode", "", "The mode that XDP programme will be loaded (available values are nv,skb, and hw)")
+	modeClient := clientFlags.String("mode", "", "The mode that XDP programme will be loaded (available values are nv,skb, and hw), or passed alongside with the actions block,ratelimit as the mode of the rule (available values are enforce and monitor)")
 	targetClient := clientFlags.String("target", "", "target IP address or subnet that will be blocked or allowed")
+	protocolClient := clientFlags.String("protocol", "", "Only block packets of this protocol (available values are tcp,udp,icmp, and gre)")
+	srcPortClient := clientFlags.String("sport", "", "Only block packets with this source port or port range (Example '11211' or '1024-2048')")
+	dstPortClient := clientFlags.String("dport", "", "Only block packets with this destination port or port range (Example '53' or '1024-2048')")
+	ppsClient := clientFlags.Uint64("pps", 0, "Packets per second allowed from each source by the ratelimit action")
+	bpsClient := clientFlags.Uint64("bps", 0, "Bits per second allowed from each source by the ratelimit action")
+	fileClient := clientFlags.String("file", "", "Passed alongside with the actions block,allow,ratelimit to apply the targets of a file in one batch request, one target per line with an optional timeout in seconds after it")
+	commentClient := clientFlags.String("comment", "", "Why the IP address or subnet is blocked, it is shown by the status action")
 	timeoutClient := clientFlags.Uint("timeout", 0, "How long the IP address or the subnet will be blocked in seconds")
 	serverIPClient := clientFlags.String("dstIP", "127.0.0.1", "The IP address that the goxdp service is listening to")
 	serverPortClient := clientFlags.String("dstPort", "8090", "The Port that the goxdp service is listening to")
-	flush := clientFlags.Bool("flush", false, "Passed alongside with the actions status,block,allow to flush the status or blocked IP addresses or subnets tables")
+	tokenClient := clientFlags.String("token", os.Getenv("GOXDP_TOKEN"), "The bearer token sent to the goxdp service, defaults to the GOXDP_TOKEN environment variable")
+	tlsClient := clientFlags.Bool("tls", false, "Connect to the goxdp service with https, it is implied by -ca and -cert")
+	caClient := clientFlags.String("ca", "", "The PEM CA bundle that the certificate of the goxdp service is verified with, the system roots are used when it is empty")
+	certClient := clientFlags.String("cert", "", "The PEM client certificate sent to the goxdp service when it requires one")
+	keyClient := clientFlags.String("key", "", "The PEM private key of the -cert client certificate")
+	typesClient := clientFlags.String("types", "", "Passed alongside with the watch action to only show these comma separated event types (Example 'rule_added,rule_removed,drops')")
+	outClient := clientFlags.String("out", "drops.pcapng", "Passed alongside with the capture action as the pcapng file that the samples of the dropped packets are saved to")
+	durationClient := clientFlags.String("duration", "10s", "Passed alongside with the capture action as how long the dropped packets are sampled (Example '30s')")
+	dryRunClient := clientFlags.Bool("dry-run", false, "Passed alongside with the optimize action to only show the rules that would be removed and merged")
+	flush := clientFlags.Bool("flush", false, "Passed alongside with the actions status,block,allow,allowlist to flush the status, blocked, or allowed IP addresses or subnets tables")
+	// Handling token flags
+	tokenFlags := flag.NewFlagSet("token", flag.ExitOnError)
+	tokenName := tokenFlags.String("name", "", "The name of the token, it is the owner of the rules created with it")
+	tokenScopes := tokenFlags.String("scopes", "", "The scopes granted to the token (Example 'rules:read,rules:write' or '*' for all of them)")
 
 	if os.Args[1] == "server" {
 		serverFlags.Parse(os.Args[2:])
 		//Instance of the application struct
 		app := Application{
-			InfoLog:          log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime),
-			ErrorLog:         log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile),
-			LoadedInterfaces: map[string]link.Link{},
-			TimeoutList:      map[BpfIpv4LpmKey]time.Time{},
+			InfoLog:             log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime),
+			ErrorLog:            log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile),
+			LoadedInterfaces:    map[string]link.Link{},
+			InterfaceModes:      map[string]string{},
+			ruleMetadata:        map[netip.Prefix]ruleMetadata{},
+			feedUpdates:         make(chan []configFeed, 1),
+			StateDir:            *stateDir,
+			PinPath:             *pinPath,
+			TokensPath:          *tokensPath,
+			AddressStats:        *addressStats,
+			MaxRules:            uint32(*maxRules),
+			MaxStatsEntries:     uint32(*maxStatsEntries),
+			SampleRate:          uint32(*sampleRate),
+			monitor:             *monitor,
+			MetricsMaxAddresses: *metricsMaxAddresses,
+			MetricsMaxRules:     *metricsMaxRules,
+			TLS:                 tlsFiles{CertFile: *tlsCert, KeyFile: *tlsKey, ClientCA: *clientCA},
 			// Is_loaded:        false,
 		}
+		//the listen addresses and workers of the configuration file override the flags
+		var config *Config
+		if *configPath != "" {
+			var err error
+			config, err = loadConfig(*configPath)
+			if err != nil {
+				app.ErrorLog.Fatalf("cannot load the configuration file: %s", err)
+			}
+			if config.Listen.Private != "" {
+				*privateIP, *privatePort, err = net.SplitHostPort(config.Listen.Private)
+				if err != nil {
+					app.ErrorLog.Fatalf("invalid private listen address: %s", err)
+				}
+			}
+			if config.Listen.Public != "" {
+				*publicIP, *publicPort, err = net.SplitHostPort(config.Listen.Public)
+				if err != nil {
+					app.ErrorLog.Fatalf("invalid public listen address: %s", err)
+				}
+			}
+			if config.Workers.TimeoutInterval != 0 {
+				*timeoutWorkerInterval = config.Workers.TimeoutInterval
+			}
+		}
+		if *addressStats < 0 {
+			app.ErrorLog.Fatal("address-stats should be 0 or greater")
+		}
+		//every blocked rule of both address families has its own counters
+		if *maxRules == 0 || *maxRules > (math.MaxUint32-1)/2 {
+			app.ErrorLog.Fatal("max-rules should be between 1 and ", (math.MaxUint32-1)/2)
+		}
+		if *maxStatsEntries == 0 || *maxStatsEntries > math.MaxUint32 {
+			app.ErrorLog.Fatal("max-stats-entries should be between 1 and ", uint32(math.MaxUint32))
+		}
+		if *sampleRate > math.MaxUint32 {
+			app.ErrorLog.Fatal("sample-rate is too large")
+		}
+		if *eventsInterval < 0 {
+			app.ErrorLog.Fatal("events-interval should be 0 or greater")
+		}
+		if *metricsMaxAddresses < 0 {
+			app.ErrorLog.Fatal("metrics-max-addresses should be 0 or greater")
+		}
+		if *metricsMaxRules < 0 {
+			app.ErrorLog.Fatal("metrics-max-rules should be 0 or greater")
+		}
 		//check if user entered correct timeout interval for the timeout worker
 		if *timeoutWorkerInterval < 5 {
 			app.ErrorLog.Fatal("TimeoutWorkerInterval should 5 or greater")
 		}
-		//start timeout worker
-		go app.timeoutWorker(*timeoutWorkerInterval)
-		//create object of the xdp firewall
-		objs := bpfObjects{}
-		if err := loadBpfObjects(&objs, nil); err != nil {
+		//load the API tokens before serving any request
+		if app.TokensPath != "" {
+			if err := app.reloadTokens(); err != nil {
+				app.ErrorLog.Fatalf("cannot load the tokens file: %s", err)
+			}
+		} else {
+			app.InfoLog.Print("No tokens file is set, the private routes accept requests without authentication")
+		}
+		//load the certificate of the listeners
+		if err := checkTLSFlags(app.TLS); err != nil {
+			app.ErrorLog.Fatal(err)
+		}
+		if app.TLS.enabled() {
+			if err := app.loadCertificate(); err != nil {
+				app.ErrorLog.Fatalf("cannot load the TLS certificate or client CA: %s", err)
+			}
+		}
+		//open the audit log before any change is made
+		if *auditPath != "" {
+			if *auditMaxSize < 1 || *auditMaxBackups < 0 {
+				app.ErrorLog.Fatal("audit-max-size should be 1 or greater and audit-max-backups 0 or greater")
+			}
+			auditLog, err := openAuditLog(*auditPath, *auditMaxSize<<20, *auditMaxBackups)
+			if err != nil {
+				app.ErrorLog.Fatalf("cannot open the audit log: %s", err)
+			}
+			app.auditLog = auditLog
+		}
+		//create object of the xdp firewall, the maps pinned by the previous server are reused
+		objs, err := app.loadObjects()
+		if err != nil {
 			app.ErrorLog.Fatalf("cannot load objects: %s", err)
 		}
-		app.BpfObjects = &objs
+		app.BpfObjects = objs
+		//give the pinned rules their counters and tell the XDP program what to count
+		if err := app.loadRuleIDs(); err != nil {
+			app.ErrorLog.Fatalf("cannot load the rule IDs: %s", err)
+		}
+		if err := app.applySettings(); err != nil {
+			app.ErrorLog.Fatalf("cannot write the settings map: %s", err)
+		}
+		//take over the XDP links that are still attached
+		if err := app.restoreLinks(); err != nil {
+			app.ErrorLog.Fatalf("cannot restore the pinned XDP links: %s", err)
+		}
+		//restore the rules saved before the last shutdown
+		if err := app.restoreState(); err != nil {
+			app.ErrorLog.Fatalf("cannot restore the state file: %s", err)
+		}
+		//apply the interfaces and rules of the configuration file
+		if config != nil {
+			if err := app.reconcile(config); err != nil {
+				app.ErrorLog.Printf("Cannot apply the configuration file -> %v", err)
+			}
+		}
+		//stop the service on SIGINT or SIGTERM
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		//start timeout worker
+		workerDone := make(chan struct{})
+		go func() {
+			app.timeoutWorker(ctx, *timeoutWorkerInterval)
+			close(workerDone)
+		}()
+		//send the drop rates of the rules to the event subscribers
+		if *eventsInterval > 0 {
+			go app.dropsWorker(ctx, *eventsInterval)
+		}
+		//reload the configuration, tokens, and certificate files on SIGHUP and refresh the threat feeds
+		if *configPath != "" || app.TokensPath != "" || app.TLS.enabled() {
+			go app.reloadWorker(ctx, *configPath)
+		}
+		feedDone := make(chan struct{})
+		if *configPath != "" {
+			go func() {
+				app.feedWorker(ctx)
+				close(feedDone)
+			}()
+		} else {
+			close(feedDone)
+		}
+
+		//describe the routes of both routers in the OpenAPI document
+		privateRouter := app.privateRouter()
+		publicRouter := app.publicRouter()
+		if err := app.buildOpenAPI(privateRouter, publicRouter); err != nil {
+			app.ErrorLog.Fatalf("cannot build the OpenAPI document: %s", err)
+		}
 
 		//Start public routes
 		pubsrv := &http.Server{
 			Addr:     fmt.Sprintf("%s:%s", *publicIP, *publicPort),
 			ErrorLog: app.ErrorLog,
-			Handler:  app.publicRouter(),
+			Handler:  publicRouter,
+		}
+		if app.TLS.enabled() {
+			pubsrv.TLSConfig = app.serverTLSConfig(false)
 		}
 		app.InfoLog.Printf("Starting public routes worker service on IP: %s, Port: %s ....", *publicIP, *publicPort)
 		go func() {
-			err := pubsrv.ListenAndServe()
-			if err != nil {
+			err := app.serve(pubsrv)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
 				app.ErrorLog.Fatal(err)
 			}
 		}()
@@ -82,14 +272,40 @@ func main() {
 		prvsrv := &http.Server{
 			Addr:     fmt.Sprintf("%s:%s", *privateIP, *privatePort),
 			ErrorLog: app.ErrorLog,
-			Handler:  app.privateRouter(),
+			Handler:  privateRouter,
+		}
+		if app.TLS.enabled() {
+			prvsrv.TLSConfig = app.serverTLSConfig(true)
+			if app.TLS.ClientCA != "" {
+				app.InfoLog.Printf("The private routes require client certificates signed by %s", app.TLS.ClientCA)
+			}
 		}
 		app.InfoLog.Printf("Starting server on IP: %s, Port: %s ....", *privateIP, *privatePort)
+		go func() {
+			err := app.serve(prvsrv)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				app.ErrorLog.Fatal(err)
+			}
+		}()
 		app.InfoLog.Printf("Started successfully on IP: %s, Port: %s waiting for load,unload,block,allow, and status requests", *privateIP, *privatePort)
-		err := prvsrv.ListenAndServe()
-		if err != nil {
-			app.ErrorLog.Fatal(err)
+
+		<-ctx.Done()
+		app.InfoLog.Print("Shutting down the service ....")
+		//end the event streams, the server waits for them otherwise
+		app.events.close()
+		//wait for the running requests before touching the maps and links
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := prvsrv.Shutdown(shutdownCtx); err != nil {
+			app.ErrorLog.Printf("Cannot shutdown the private routes server -> %v", err)
+		}
+		if err := pubsrv.Shutdown(shutdownCtx); err != nil {
+			app.ErrorLog.Printf("Cannot shutdown the public routes server -> %v", err)
 		}
+		<-workerDone
+		<-feedDone
+		app.shutdown(*detachOnExit)
+		app.InfoLog.Print("Service stopped")
 
 	} else if os.Args[1] == "client" {
 		//remove timestamps from the returned logs
@@ -100,6 +316,14 @@ func main() {
 		clientApp := client.ClientAPP{
 			ServerIP:   *serverIPClient,
 			ServerPort: *serverPortClient,
+			Token:      *tokenClient,
+		}
+		if *tlsClient || *caClient != "" || *certClient != "" {
+			tlsConfig, err := client.TLSConfig(*caClient, *certClient, *keyClient)
+			if err != nil {
+				log.Fatal(err)
+			}
+			clientApp.TLS = tlsConfig
 		}
 
 		if *actionClient == "" {
@@ -125,7 +349,7 @@ func main() {
 				log.Fatal(err)
 			}
 			log.Print(msg)
-		} else if *actionClient == "allow" || *actionClient == "block" {
+		} else if *actionClient == "allow" || *actionClient == "block" || *actionClient == "ratelimit" {
 			if *flush == true {
 				msg, err := clientApp.FlushBlockedXDP()
 				if err != nil {
@@ -134,14 +358,80 @@ func main() {
 				log.Print(msg)
 				return
 			}
+			//check if IP address or subnet is valid, the targets of the file are checked by the server
+			if *fileClient == "" {
+				if _, err := helpers.IpChecker(*targetClient); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if *timeoutClient < 0 {
+				log.Fatal("timeout cannot be less than zero")
+			}
+			//check if the protocol and ports are valid
+			if _, err := helpers.ProtocolChecker(*protocolClient, strings.Contains(*targetClient, ":")); err != nil {
+				log.Fatal(err)
+			}
+			if _, _, err := helpers.PortChecker(*srcPortClient); err != nil {
+				log.Fatal(err)
+			}
+			if _, _, err := helpers.PortChecker(*dstPortClient); err != nil {
+				log.Fatal(err)
+			}
+			if *actionClient == "ratelimit" && *ppsClient == 0 && *bpsClient == 0 {
+				log.Fatal("ratelimit action requires pps or bps")
+			}
+			options := client.RuleOptions{
+				Protocol: *protocolClient,
+				SrcPort:  *srcPortClient,
+				DstPort:  *dstPortClient,
+				Pps:      *ppsClient,
+				Bps:      *bpsClient,
+				Comment:  *commentClient,
+			}
+			if *actionClient != "allow" {
+				options.Mode = *modeClient
+			}
+			//the rules are owned by the local user running the client
+			if current, err := user.Current(); err == nil {
+				options.Owner = current.Username
+			}
+			if *fileClient != "" {
+				rules, err := client.ReadBatchFile(*fileClient, *actionClient, *timeoutClient, options)
+				if err != nil {
+					log.Fatal(err)
+				}
+				msg, err := clientApp.BlockBatchXDP(rules)
+				if err != nil {
+					log.Fatal(err)
+				}
+				log.Print(msg)
+				return
+			}
+			msg, err := clientApp.BlockXDP(*actionClient, *targetClient, *timeoutClient, options)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Print(msg)
+		} else if *actionClient == "allowlist" || *actionClient == "unallowlist" {
+			if *flush == true && *actionClient == "allowlist" {
+				msg, err := clientApp.FlushAllowedXDP()
+				if err != nil {
+					log.Fatal(err)
+				}
+				log.Print(msg)
+				return
+			}
 			//check if IP address or subnet is valid
 			if _, err := helpers.IpChecker(*targetClient); err != nil {
 				log.Fatal(err)
 			}
-			if *timeoutClient < 0 {
-				log.Fatal("timeout cannot be less than zero")
+			var msg string
+			var err error
+			if *actionClient == "allowlist" {
+				msg, err = clientApp.AllowListXDP(*targetClient)
+			} else {
+				msg, err = clientApp.UnallowListXDP(*targetClient)
 			}
-			msg, err := clientApp.BlockXDP(*actionClient, *targetClient, *timeoutClient)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -162,8 +452,47 @@ func main() {
 				log.Print(msg)
 			}
 
+		} else if *actionClient == "optimize" {
+			msg, err := clientApp.OptimizeXDP(*dryRunClient)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Print(msg)
+		} else if *actionClient == "capture" {
+			msg, err := clientApp.CaptureDrops(*durationClient, *outClient)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Print(msg)
+		} else if *actionClient == "watch" {
+			if err := clientApp.WatchEvents(*typesClient, os.Stdout); err != nil {
+				log.Fatal(err)
+			}
 		}
 
+	} else if os.Args[1] == "token" {
+		log.SetFlags(0)
+		tokenFlags.Parse(os.Args[2:])
+		if *tokenName == "" || *tokenScopes == "" {
+			log.Print("Name and scopes flags cannot be empty")
+			tokenFlags.PrintDefaults()
+			os.Exit(1)
+		}
+		scopes := strings.Split(*tokenScopes, ",")
+		for _, scope := range scopes {
+			if !knownScopes[scope] {
+				log.Fatalf("Unknown scope %s", scope)
+			}
+		}
+		token, err := newToken()
+		if err != nil {
+			log.Fatal(err)
+		}
+		entry, err := yaml.Marshal(tokensFile{Tokens: []apiToken{{Name: *tokenName, Hash: hashToken(token), Scopes: scopes}}})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Token: %s\n\nAdd the entry to the tokens file and send SIGHUP to the service, the token is not shown again:\n\n%s", token, entry)
 	} else {
 		log.Fatal(defMessage)
 	}
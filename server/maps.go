@@ -0,0 +1,625 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"time"
+
+	"github.com/ahsifer/goxdp/helpers"
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// parsePrefix validates the target IP address or subnet and returns it with the host bits cleared
+func parsePrefix(target string) (netip.Prefix, error) {
+	validIP, err := helpers.IpChecker(target)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	prefix, err := netip.ParsePrefix(*validIP)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// ipv4Key converts an IPv4 prefix to the key of the blocked_ipv4 LPM map
+func ipv4Key(prefix netip.Prefix) (BpfIpv4LpmKey, error) {
+	//Convert the IP address to decimal with big endian format
+	decimalIP, err := helpers.IP4toInt(prefix.Addr().String())
+	if err != nil {
+		return BpfIpv4LpmKey{}, err
+	}
+	return BpfIpv4LpmKey{
+		Prefixlen: uint32(prefix.Bits()),
+		Target:    *decimalIP,
+	}, nil
+}
+
+// ipv6Key converts an IPv6 prefix to the key of the blocked_ipv6 LPM map
+func ipv6Key(prefix netip.Prefix) BpfIpv6LpmKey {
+	return BpfIpv6LpmKey{
+		Prefixlen: uint32(prefix.Bits()),
+		Target:    prefix.Addr().As16(),
+	}
+}
+
+// Actions of the blocked LPM maps rules
+const (
+	ruleActionDrop      uint8 = 0
+	ruleActionRatelimit uint8 = 1
+)
+
+// ruleActionName returns the name of the rule action used by the block requests
+func ruleActionName(action uint8) string {
+	if action == ruleActionRatelimit {
+		return "ratelimit"
+	}
+	return "block"
+}
+
+// Modes of the blocked LPM maps rules, the packets of a monitor rule are counted but not dropped
+const (
+	ruleModeEnforce uint8 = 0
+	ruleModeMonitor uint8 = 1
+)
+
+// ruleModeName returns the name of the rule mode used by the block requests
+func ruleModeName(mode uint8) string {
+	if mode == ruleModeMonitor {
+		return "monitor"
+	}
+	return "enforce"
+}
+
+// parseRuleMode returns the rule mode of its name, an empty name is the same as enforce
+func parseRuleMode(name string) (uint8, error) {
+	switch name {
+	case "", "enforce":
+		return ruleModeEnforce, nil
+	case "monitor":
+		return ruleModeMonitor, nil
+	}
+	return 0, fmt.Errorf("invalid mode %q, should be enforce or monitor", name)
+}
+
+// setRateLimit turns the rule into a ratelimit rule, pps is in packets per second and bps in bits per second
+func setRateLimit(rule *bpfRule, pps uint64, bps uint64) error {
+	if pps == 0 && bps == 0 {
+		return errors.New("ratelimit action requires pps or bps")
+	}
+	if pps > math.MaxUint32 || bps/8 > math.MaxUint32 {
+		return errors.New("ratelimit pps or bps is too large")
+	}
+	if bps != 0 && bps < 8 {
+		return errors.New("ratelimit bps should be 8 or greater")
+	}
+	rule.Action = ruleActionRatelimit
+	rule.RatePps = uint32(pps)
+	rule.RateBytes = uint32(bps / 8)
+	return nil
+}
+
+// monotonicNow returns the CLOCK_MONOTONIC time in nanoseconds, the same clock as bpf_ktime_get_ns
+func monotonicNow() (uint64, error) {
+	var ts unix.Timespec
+	err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(ts.Nano()), nil
+}
+
+// maxRuleTimeout is the longest timeout in seconds, longer ones would overflow time.Duration
+const maxRuleTimeout = 100 * 365 * 24 * 3600
+
+// errTimeoutTooLong is returned by ruleExpiry for the timeouts above maxRuleTimeout
+var errTimeoutTooLong = fmt.Errorf("timeout should be %d seconds or less, use 0 for a rule that never expires", maxRuleTimeout)
+
+// ruleExpiry converts a timeout in seconds to the expires_ns of the rule, zero timeout never expires
+func ruleExpiry(timeout uint) (uint64, error) {
+	if timeout == 0 {
+		return 0, nil
+	}
+	if timeout > maxRuleTimeout {
+		return 0, errTimeoutTooLong
+	}
+	return ruleExpiryAt(time.Now().Add(time.Duration(timeout) * time.Second))
+}
+
+// ruleExpiryAt converts a wall clock deadline to the expires_ns of the rule, a deadline that already
+// passed expires the rule right away
+func ruleExpiryAt(deadline time.Time) (uint64, error) {
+	now, err := monotonicNow()
+	if err != nil {
+		return 0, err
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return now, nil
+	}
+	return now + uint64(remaining), nil
+}
+
+// ruleDeadline converts the expires_ns of the rule back to the wall clock time
+func ruleDeadline(expiresNs uint64) (time.Time, error) {
+	now, err := monotonicNow()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(time.Duration(int64(expiresNs) - int64(now))), nil
+}
+
+// parseRule builds the value of the blocked LPM maps from the protocol and port ranges of the request
+func parseRule(prefix netip.Prefix, protocol string, srcPort string, dstPort string) (bpfRule, error) {
+	var rule bpfRule
+	var err error
+	rule.Protocol, err = helpers.ProtocolChecker(protocol, prefix.Addr().Is6())
+	if err != nil {
+		return rule, err
+	}
+	rule.SrcPortMin, rule.SrcPortMax, err = helpers.PortChecker(srcPort)
+	if err != nil {
+		return rule, err
+	}
+	rule.DstPortMin, rule.DstPortMax, err = helpers.PortChecker(dstPort)
+	if err != nil {
+		return rule, err
+	}
+	//only TCP and UDP packets carry ports
+	hasPorts := rule.SrcPortMax != 0 || rule.DstPortMax != 0
+	if hasPorts && rule.Protocol != 0 && rule.Protocol != 6 && rule.Protocol != 17 {
+		return rule, errors.New("ports can only be used with the tcp and udp protocols")
+	}
+	return rule, nil
+}
+
+// rule converts the entry to the prefix and value of the blocked LPM maps without its expiry,
+// an empty action is the same as block and an empty mode the same as enforce
+func (entry ruleEntry) rule() (netip.Prefix, bpfRule, error) {
+	prefix, err := parsePrefix(entry.Target)
+	if err != nil {
+		return prefix, bpfRule{}, fmt.Errorf("invalid IP address or subnet %s -> %w", entry.Target, err)
+	}
+	rule, err := parseRule(prefix, entry.Protocol, entry.SrcPort, entry.DstPort)
+	if err != nil {
+		return prefix, rule, fmt.Errorf("invalid rule %s -> %w", entry.Target, err)
+	}
+	switch entry.Action {
+	case "", "block":
+	case "ratelimit":
+		err = setRateLimit(&rule, entry.Pps, entry.Bps)
+		if err != nil {
+			return prefix, rule, fmt.Errorf("invalid rule %s -> %w", entry.Target, err)
+		}
+	default:
+		return prefix, rule, fmt.Errorf("invalid action %q of the rule %s", entry.Action, entry.Target)
+	}
+	rule.Mode, err = parseRuleMode(entry.Mode)
+	if err != nil {
+		return prefix, rule, fmt.Errorf("invalid rule %s -> %w", entry.Target, err)
+	}
+	return prefix, rule, nil
+}
+
+// ruleEntryOf converts a rule of the blocked LPM maps back to the entry it was parsed from, without its timeout
+func ruleEntryOf(prefix netip.Prefix, rule bpfRule) ruleEntry {
+	return ruleEntry{
+		Target:   prefix.String(),
+		Action:   ruleActionName(rule.Action),
+		Mode:     ruleModeName(rule.Mode),
+		Protocol: helpers.ProtocolName(rule.Protocol),
+		SrcPort:  helpers.PortRange(rule.SrcPortMin, rule.SrcPortMax),
+		DstPort:  helpers.PortRange(rule.DstPortMin, rule.DstPortMax),
+		Pps:      uint64(rule.RatePps),
+		Bps:      uint64(rule.RateBytes) * 8,
+	}
+}
+
+// lpmKey returns the LPM map of the prefix address family together with the key of the prefix in that map
+func lpmKey(prefix netip.Prefix, ipv4Map *ebpf.Map, ipv6Map *ebpf.Map) (*ebpf.Map, any, error) {
+	if prefix.Addr().Is4() {
+		key, err := ipv4Key(prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ipv4Map, &key, nil
+	}
+	key := ipv6Key(prefix)
+	return ipv6Map, &key, nil
+}
+
+// ipv4Prefix converts the key of an IPv4 LPM map back to a prefix
+func ipv4Prefix(key BpfIpv4LpmKey) (netip.Prefix, error) {
+	addr, err := netip.ParseAddr(helpers.IntToIPv4(key.Target))
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, int(key.Prefixlen)), nil
+}
+
+// ipv6Prefix converts the key of an IPv6 LPM map back to a prefix
+func ipv6Prefix(key BpfIpv6LpmKey) netip.Prefix {
+	return netip.PrefixFrom(netip.AddrFrom16(key.Target), int(key.Prefixlen))
+}
+
+// blockPrefix adds the prefix and its rule to the blocked LPM map of its address family
+func (app *Application) blockPrefix(prefix netip.Prefix, rule bpfRule) error {
+	return app.updatePrefix(prefix, rule, ebpf.UpdateAny)
+}
+
+// errRuleConflict is returned when the prefix already holds a rule for another protocol or other ports,
+// the trie keeps a single rule per prefix so the new one would silently replace it
+var errRuleConflict = errors.New("rule conflict")
+
+// sameMatch reports whether the rules match the same protocol and ports
+func sameMatch(a bpfRule, b bpfRule) bool {
+	return a.Protocol == b.Protocol &&
+		a.SrcPortMin == b.SrcPortMin && a.SrcPortMax == b.SrcPortMax &&
+		a.DstPortMin == b.DstPortMin && a.DstPortMax == b.DstPortMax
+}
+
+// ruleConflict returns errRuleConflict when the prefix holds a rule that does not match the same protocol
+// and ports as the new rule, a rule with the same match is replaced. app.ruleIDs.lock must be held
+func (app *Application) ruleConflict(prefix netip.Prefix, rule bpfRule) error {
+	current, err := app.lookupPrefix(prefix)
+	if errors.Is(err, ebpf.ErrKeyNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if sameMatch(current, rule) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s already holds a rule for another protocol or other ports, PATCH /v1/rules/%s replaces it", errRuleConflict, prefix, prefix)
+}
+
+// addPrefix is blockPrefix for the requests that should not replace the rule of another protocol or other ports,
+// it fails with errRuleConflict in that case
+func (app *Application) addPrefix(prefix netip.Prefix, rule bpfRule) error {
+	app.ruleIDs.lock.Lock()
+	defer app.ruleIDs.lock.Unlock()
+	if err := app.ruleConflict(prefix, rule); err != nil {
+		return err
+	}
+	return app.updatePrefixLocked(prefix, rule, ebpf.UpdateAny)
+}
+
+// updatePrefix writes the rule of the prefix with the given flags, UpdateNoExist fails with
+// ebpf.ErrKeyExist and UpdateExist with ebpf.ErrKeyNotExist.
+// The rule gets the ID of the prefix so that its counters are kept across updates
+func (app *Application) updatePrefix(prefix netip.Prefix, rule bpfRule, flags ebpf.MapUpdateFlags) error {
+	app.ruleIDs.lock.Lock()
+	defer app.ruleIDs.lock.Unlock()
+	return app.updatePrefixLocked(prefix, rule, flags)
+}
+
+// updatePrefixLocked is updatePrefix for callers that already hold app.ruleIDs.lock
+func (app *Application) updatePrefixLocked(prefix netip.Prefix, rule bpfRule, flags ebpf.MapUpdateFlags) error {
+	blockedMap, key, err := lpmKey(prefix, app.BpfObjects.BlockedIpv4, app.BpfObjects.BlockedIpv6)
+	if err != nil {
+		return err
+	}
+	fresh, err := app.assignRuleID(prefix, &rule)
+	if err != nil {
+		return err
+	}
+	err = blockedMap.Update(key, &rule, flags)
+	if err != nil && fresh {
+		app.ruleIDs.release(prefix)
+	}
+	return app.ruleTableError(prefix.Addr().Is4(), err)
+}
+
+// errRuleTableFull is returned when a blocked LPM map holds as many rules as -max-rules
+var errRuleTableFull = errors.New("rule table full")
+
+// ruleTableError turns the error of a blocked LPM map that ran out of entries into errRuleTableFull
+// with the usage of the map, the other errors are returned as they are. app.ruleIDs.lock must be held
+func (app *Application) ruleTableError(is4 bool, err error) error {
+	if !errors.Is(err, unix.ENOSPC) {
+		return err
+	}
+	blockedMap, name := app.BpfObjects.BlockedIpv6, "blocked_ipv6"
+	if is4 {
+		blockedMap, name = app.BpfObjects.BlockedIpv4, "blocked_ipv4"
+	}
+	//every blocked prefix holds an ID, so the IDs of the family are the entries of its map
+	used := 0
+	for prefix := range app.ruleIDs.ids {
+		if prefix.Addr().Is4() == is4 {
+			used++
+		}
+	}
+	return fmt.Errorf("%w: %s holds %d of %d rules, raise -max-rules to add more", errRuleTableFull, name, used, blockedMap.MaxEntries())
+}
+
+// lookupPrefix returns the rule stored for exactly this prefix and ebpf.ErrKeyNotExist when there is none
+func (app *Application) lookupPrefix(prefix netip.Prefix) (bpfRule, error) {
+	var rule bpfRule
+	blockedMap, key, err := lpmKey(prefix, app.BpfObjects.BlockedIpv4, app.BpfObjects.BlockedIpv6)
+	if err != nil {
+		return rule, err
+	}
+	//a lookup in an LPM trie returns the longest prefix covering the key, every rule records the
+	//length of its own prefix so the covering rule is the one of the prefix when the lengths match
+	err = blockedMap.Lookup(key, &rule)
+	if err != nil {
+		return rule, err
+	}
+	if int(rule.Prefixlen) != prefix.Bits() {
+		return bpfRule{}, ebpf.ErrKeyNotExist
+	}
+	return rule, nil
+}
+
+// unblockPrefix removes the prefix from the blocked LPM map of its address family and releases its rule ID
+func (app *Application) unblockPrefix(prefix netip.Prefix) error {
+	blockedMap, key, err := lpmKey(prefix, app.BpfObjects.BlockedIpv4, app.BpfObjects.BlockedIpv6)
+	if err != nil {
+		return err
+	}
+	app.ruleIDs.lock.Lock()
+	defer app.ruleIDs.lock.Unlock()
+	err = blockedMap.Delete(key)
+	if err == nil || errors.Is(err, ebpf.ErrKeyNotExist) {
+		app.ruleIDs.release(prefix)
+	}
+	return err
+}
+
+// errRuleChanged is returned by expirePrefix when the prefix was blocked again since its rule was read
+var errRuleChanged = errors.New("rule changed")
+
+// expirePrefix removes the expired rule of the prefix like unblockPrefix, unless the prefix holds another rule by now.
+// The rule is compared by its ID and expiry while app.ruleIDs.lock is held, so a prefix blocked again with a new
+// timeout or without one between the read of the maps and the delete keeps its new rule
+func (app *Application) expirePrefix(prefix netip.Prefix, expired bpfRule) error {
+	blockedMap, key, err := lpmKey(prefix, app.BpfObjects.BlockedIpv4, app.BpfObjects.BlockedIpv6)
+	if err != nil {
+		return err
+	}
+	app.ruleIDs.lock.Lock()
+	defer app.ruleIDs.lock.Unlock()
+	current, err := app.lookupPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	if current.Id != expired.Id || current.ExpiresNs != expired.ExpiresNs {
+		return errRuleChanged
+	}
+	err = blockedMap.Delete(key)
+	if err == nil || errors.Is(err, ebpf.ErrKeyNotExist) {
+		app.ruleIDs.release(prefix)
+	}
+	return err
+}
+
+// blockedRules returns the prefixes and rules stored in the blocked_ipv4 and blocked_ipv6 LPM maps
+func (app *Application) blockedRules() ([]blockedRule, error) {
+	rules := []blockedRule{}
+
+	var key4 BpfIpv4LpmKey
+	var val bpfRule
+	iter := app.BpfObjects.BlockedIpv4.Iterate()
+	for iter.Next(&key4, &val) {
+		prefix, err := ipv4Prefix(key4)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, blockedRule{Prefix: prefix, Rule: val})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	var key6 BpfIpv6LpmKey
+	iter = app.BpfObjects.BlockedIpv6.Iterate()
+	for iter.Next(&key6, &val) {
+		rules = append(rules, blockedRule{Prefix: ipv6Prefix(key6), Rule: val})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// allowPrefix adds the prefix to the allowed LPM map of its address family
+func (app *Application) allowPrefix(prefix netip.Prefix) error {
+	allowedMap, key, err := lpmKey(prefix, app.BpfObjects.AllowedIpv4, app.BpfObjects.AllowedIpv6)
+	if err != nil {
+		return err
+	}
+	return allowedMap.Update(key, uint8(1), ebpf.UpdateAny)
+}
+
+// disallowPrefix removes the prefix from the allowed LPM map of its address family
+func (app *Application) disallowPrefix(prefix netip.Prefix) error {
+	allowedMap, key, err := lpmKey(prefix, app.BpfObjects.AllowedIpv4, app.BpfObjects.AllowedIpv6)
+	if err != nil {
+		return err
+	}
+	return allowedMap.Delete(key)
+}
+
+// allowedPrefixes returns the prefixes stored in the allowed_ipv4 and allowed_ipv6 LPM maps
+func (app *Application) allowedPrefixes() ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+
+	var key4 BpfIpv4LpmKey
+	var val uint8
+	iter := app.BpfObjects.AllowedIpv4.Iterate()
+	for iter.Next(&key4, &val) {
+		prefix, err := ipv4Prefix(key4)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	var key6 BpfIpv6LpmKey
+	iter = app.BpfObjects.AllowedIpv6.Iterate()
+	for iter.Next(&key6, &val) {
+		prefixes = append(prefixes, ipv6Prefix(key6))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// blockPrefixes adds the rules to the blocked LPM maps with one batch update per address family,
+// kernels without batch support for LPM tries fall back to one update per key.
+// Every rule gets the ID of its prefix like updatePrefix.
+// The returned slice holds the error of each rule in the same order
+func (app *Application) blockPrefixes(rules []blockedRule) []error {
+	app.ruleIDs.lock.Lock()
+	defer app.ruleIDs.lock.Unlock()
+	return app.blockPrefixesLocked(rules, make([]error, len(rules)))
+}
+
+// addPrefixes is blockPrefixes for the requests that should not replace the rules of another protocol or
+// other ports, those rules fail with errRuleConflict like addPrefix
+func (app *Application) addPrefixes(rules []blockedRule) []error {
+	app.ruleIDs.lock.Lock()
+	defer app.ruleIDs.lock.Unlock()
+	errs := make([]error, len(rules))
+	for i, value := range rules {
+		errs[i] = app.ruleConflict(value.Prefix, value.Rule)
+	}
+	return app.blockPrefixesLocked(rules, errs)
+}
+
+// blockPrefixesLocked writes the rules that have no error in errs yet and stores the error of the others
+// in errs, app.ruleIDs.lock must be held
+func (app *Application) blockPrefixesLocked(rules []blockedRule, errs []error) []error {
+	var index4, index6 []int
+	var keys4 []BpfIpv4LpmKey
+	var keys6 []BpfIpv6LpmKey
+	var values4, values6 []bpfRule
+	fresh := make([]bool, len(rules))
+	for i, value := range rules {
+		if errs[i] != nil {
+			continue
+		}
+		var err error
+		fresh[i], err = app.assignRuleID(value.Prefix, &value.Rule)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if value.Prefix.Addr().Is4() {
+			key, err := ipv4Key(value.Prefix)
+			if err != nil {
+				errs[i] = err
+				if fresh[i] {
+					app.ruleIDs.release(value.Prefix)
+				}
+				continue
+			}
+			index4 = append(index4, i)
+			keys4 = append(keys4, key)
+			values4 = append(values4, value.Rule)
+		} else {
+			index6 = append(index6, i)
+			keys6 = append(keys6, ipv6Key(value.Prefix))
+			values6 = append(values6, value.Rule)
+		}
+	}
+	batchUpdate(app.BpfObjects.BlockedIpv4, keys4, values4, index4, errs)
+	batchUpdate(app.BpfObjects.BlockedIpv6, keys6, values6, index6, errs)
+	for _, i := range append(index4, index6...) {
+		if errs[i] != nil && fresh[i] {
+			app.ruleIDs.release(rules[i].Prefix)
+		}
+	}
+	//the usage of a full map is counted once for all the rules that did not fit
+	full := map[bool]error{}
+	for i, err := range errs {
+		if !errors.Is(err, unix.ENOSPC) {
+			continue
+		}
+		is4 := rules[i].Prefix.Addr().Is4()
+		if full[is4] == nil {
+			full[is4] = app.ruleTableError(is4, err)
+		}
+		errs[i] = full[is4]
+	}
+	return errs
+}
+
+// unblockPrefixes removes the prefixes from the blocked LPM maps with one batch delete per address family,
+// kernels without batch support for LPM tries fall back to one delete per key.
+// The returned slice holds the error of each prefix in the same order
+func (app *Application) unblockPrefixes(prefixes []netip.Prefix) []error {
+	errs := make([]error, len(prefixes))
+	var index4, index6 []int
+	var keys4 []BpfIpv4LpmKey
+	var keys6 []BpfIpv6LpmKey
+	for i, prefix := range prefixes {
+		if prefix.Addr().Is4() {
+			key, err := ipv4Key(prefix)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			index4 = append(index4, i)
+			keys4 = append(keys4, key)
+		} else {
+			index6 = append(index6, i)
+			keys6 = append(keys6, ipv6Key(prefix))
+		}
+	}
+	app.ruleIDs.lock.Lock()
+	defer app.ruleIDs.lock.Unlock()
+	batchDelete(app.BpfObjects.BlockedIpv4, keys4, index4, errs)
+	batchDelete(app.BpfObjects.BlockedIpv6, keys6, index6, errs)
+	for _, i := range append(index4, index6...) {
+		if errs[i] == nil || errors.Is(errs[i], ebpf.ErrKeyNotExist) {
+			app.ruleIDs.release(prefixes[i])
+		}
+	}
+	return errs
+}
+
+// batchUpdate writes the keys and values to the map and stores the error of each key in errs at its index,
+// the keys after the first failed one of the batch are retried one by one to find their own errors
+func batchUpdate[K any, V any](m *ebpf.Map, keys []K, values []V, index []int, errs []error) {
+	if len(keys) == 0 {
+		return
+	}
+	done, err := m.BatchUpdate(keys, values, nil)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		done = 0
+	}
+	for i := done; i < len(keys); i++ {
+		errs[index[i]] = m.Update(&keys[i], &values[i], ebpf.UpdateAny)
+	}
+}
+
+// batchDelete removes the keys from the map and stores the error of each key in errs at its index,
+// the keys after the first failed one of the batch are retried one by one to find their own errors
+func batchDelete[K any](m *ebpf.Map, keys []K, index []int, errs []error) {
+	if len(keys) == 0 {
+		return
+	}
+	done, err := m.BatchDelete(keys, nil)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		done = 0
+	}
+	for i := done; i < len(keys); i++ {
+		errs[index[i]] = m.Delete(&keys[i])
+	}
+}
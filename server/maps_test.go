@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRuleExpiry(t *testing.T) {
+	tests := []struct {
+		timeout uint
+		never   bool
+		wantErr error
+	}{
+		{timeout: 0, never: true},
+		{timeout: 1},
+		{timeout: 3600},
+		{timeout: maxRuleTimeout},
+		{timeout: maxRuleTimeout + 1, wantErr: errTimeoutTooLong},
+		{timeout: 300 * 365 * 24 * 3600, wantErr: errTimeoutTooLong},
+	}
+	for _, test := range tests {
+		before, err := monotonicNow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ruleExpiry(test.timeout)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("ruleExpiry(%d) returned %v, want %v", test.timeout, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if test.never {
+			if got != 0 {
+				t.Errorf("ruleExpiry(%d) = %d, want 0", test.timeout, got)
+			}
+			continue
+		}
+		if got <= before {
+			t.Errorf("ruleExpiry(%d) = %d, want after %d", test.timeout, got, before)
+		}
+	}
+}
+
+func TestRuleExpiryAt(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration
+	}{
+		{name: "future", deadline: time.Hour},
+		{name: "now", deadline: 0},
+		{name: "past", deadline: -time.Hour},
+		{name: "long past", deadline: -100 * 365 * 24 * time.Hour},
+	}
+	for _, test := range tests {
+		before, err := monotonicNow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ruleExpiryAt(time.Now().Add(test.deadline))
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := monotonicNow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.deadline <= 0 {
+			//a deadline that passed expires the rule instead of wrapping around to a far future expiry
+			if got < before || got > after {
+				t.Errorf("%s: ruleExpiryAt = %d, want between %d and %d", test.name, got, before, after)
+			}
+			continue
+		}
+		if got < before+uint64(test.deadline)-uint64(time.Second) || got > after+uint64(test.deadline) {
+			t.Errorf("%s: ruleExpiryAt = %d, want about %d", test.name, got, before+uint64(test.deadline))
+		}
+	}
+}
+
+func TestSameMatch(t *testing.T) {
+	udp53 := bpfRule{Protocol: 17, DstPortMin: 53, DstPortMax: 53}
+	tests := []struct {
+		name string
+		a    bpfRule
+		b    bpfRule
+		want bool
+	}{
+		{name: "any packet", a: bpfRule{}, b: bpfRule{}, want: true},
+		{name: "other action, rate and timeout", a: udp53, b: bpfRule{Protocol: 17, DstPortMin: 53, DstPortMax: 53, Action: ruleActionRatelimit, RatePps: 10, ExpiresNs: 5, Mode: ruleModeMonitor}, want: true},
+		{name: "other protocol", a: udp53, b: bpfRule{Protocol: 6, DstPortMin: 53, DstPortMax: 53}},
+		{name: "other destination port", a: udp53, b: bpfRule{Protocol: 17, DstPortMin: 22, DstPortMax: 22}},
+		{name: "port range", a: udp53, b: bpfRule{Protocol: 17, DstPortMin: 53, DstPortMax: 54}},
+		{name: "source port", a: udp53, b: bpfRule{Protocol: 17, SrcPortMin: 53, SrcPortMax: 53}},
+		{name: "any packet and a protocol", a: bpfRule{}, b: udp53},
+	}
+	for _, test := range tests {
+		if got := sameMatch(test.a, test.b); got != test.want {
+			t.Errorf("%s: sameMatch = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+// origins of the blocked rules
+const (
+	originAPI  = "api"
+	originFeed = "feed"
+	// rules the service adds on its own, like the rules of the configuration file
+	originAuto = "auto"
+)
+
+// ruleMetadata describes why and by whom a blocked rule was added, it is kept in user space
+// next to the blocked LPM maps and saved with the rule in the state file
+type ruleMetadata struct {
+	Comment string    `json:"comment,omitempty"`
+	Owner   string    `json:"owner,omitempty"`
+	Origin  string    `json:"origin,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// setRuleMetadata records the origin, owner and comment of a blocked prefix,
+// the creation time is kept when the prefix already has metadata
+func (app *Application) setRuleMetadata(prefix netip.Prefix, origin string, owner string, comment string) {
+	app.metadataLock.Lock()
+	defer app.metadataLock.Unlock()
+	app.setRuleMetadataLocked(prefix, origin, owner, comment)
+}
+
+// setRuleMetadataLocked is setRuleMetadata for callers that already hold metadataLock
+func (app *Application) setRuleMetadataLocked(prefix netip.Prefix, origin string, owner string, comment string) {
+	now := time.Now().UTC()
+	metadata, ok := app.ruleMetadata[prefix]
+	if !ok {
+		metadata.Created = now
+	}
+	metadata.Origin = origin
+	metadata.Owner = owner
+	metadata.Comment = comment
+	metadata.Updated = now
+	app.ruleMetadata[prefix] = metadata
+}
+
+// deleteRuleMetadata forgets the metadata of the unblocked prefixes
+func (app *Application) deleteRuleMetadata(prefixes ...netip.Prefix) {
+	app.metadataLock.Lock()
+	defer app.metadataLock.Unlock()
+	for _, prefix := range prefixes {
+		delete(app.ruleMetadata, prefix)
+	}
+}
+
+// ruleMetadataOf returns the metadata of the blocked prefixes, prefixes without metadata are missing from the map
+func (app *Application) ruleMetadataOf(rules []blockedRule) map[netip.Prefix]ruleMetadata {
+	app.metadataLock.Lock()
+	defer app.metadataLock.Unlock()
+	metadata := map[netip.Prefix]ruleMetadata{}
+	for _, value := range rules {
+		if saved, ok := app.ruleMetadata[value.Prefix]; ok {
+			metadata[value.Prefix] = saved
+		}
+	}
+	return metadata
+}
+
+// requestOwner returns the identity of the requester, the name of its API token or client certificate, the owner passed
+// in the request body or the address the request came from
+func requestOwner(request *http.Request, owner *string) string {
+	if identity, ok := requestIdentity(request); ok {
+		return identity
+	}
+	if owner != nil && *owner != "" {
+		return *owner
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"sort"
+
+	"github.com/cilium/ebpf"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// firewallCollector exports the counters of the status maps and the inventory of the rules,
+// interfaces, and maps, the maps are read on every scrape
+type firewallCollector struct {
+	app *Application
+	// maxAddresses caps the addresses exported with their own series, the ones with the most dropped packets are kept
+	maxAddresses int
+	// maxRules caps the rules exported with their own series the same way
+	maxRules int
+
+	verdictPackets        *prometheus.Desc
+	verdictBytes          *prometheus.Desc
+	droppedPackets        *prometheus.Desc
+	droppedBytes          *prometheus.Desc
+	addressDroppedPackets *prometheus.Desc
+	addressDroppedBytes   *prometheus.Desc
+	addressesOmitted      *prometheus.Desc
+	rules                 *prometheus.Desc
+	ruleDroppedPackets    *prometheus.Desc
+	ruleDroppedBytes      *prometheus.Desc
+	ruleMonitoredPackets  *prometheus.Desc
+	ruleMonitoredBytes    *prometheus.Desc
+	rulesOmitted          *prometheus.Desc
+	rulesWithTimeout      *prometheus.Desc
+	allowedPrefixes       *prometheus.Desc
+	interfaceInfo         *prometheus.Desc
+	mapEntries            *prometheus.Desc
+	mapMaxEntries         *prometheus.Desc
+	mapFillRatio          *prometheus.Desc
+}
+
+func newFirewallCollector(app *Application, maxAddresses int, maxRules int) *firewallCollector {
+	return &firewallCollector{
+		app:          app,
+		maxAddresses: maxAddresses,
+		maxRules:     maxRules,
+		verdictPackets: prometheus.NewDesc("goxdp_verdict_packets_total",
+			"Packets seen by the XDP program, by receiving interface and verdict", []string{"interface", "verdict"}, nil),
+		verdictBytes: prometheus.NewDesc("goxdp_verdict_bytes_total",
+			"Bytes seen by the XDP program, by receiving interface and verdict", []string{"interface", "verdict"}, nil),
+		droppedPackets: prometheus.NewDesc("goxdp_dropped_packets_total",
+			"Packets dropped by the blocked rules, by the direction of the matched address", []string{"direction"}, nil),
+		droppedBytes: prometheus.NewDesc("goxdp_dropped_bytes_total",
+			"Bytes dropped by the blocked rules, by the direction of the matched address", []string{"direction"}, nil),
+		addressDroppedPackets: prometheus.NewDesc("goxdp_address_dropped_packets_total",
+			"Packets dropped for a single address, only the addresses with the most dropped packets are exported", []string{"address", "direction"}, nil),
+		addressDroppedBytes: prometheus.NewDesc("goxdp_address_dropped_bytes_total",
+			"Bytes dropped for a single address, only the addresses with the most dropped packets are exported", []string{"address", "direction"}, nil),
+		addressesOmitted: prometheus.NewDesc("goxdp_address_series_omitted",
+			"Addresses of the status maps left out of the per address series by the cardinality cap", nil, nil),
+		rules: prometheus.NewDesc("goxdp_rules",
+			"Active blocked rules, by action and address family", []string{"action", "family"}, nil),
+		ruleDroppedPackets: prometheus.NewDesc("goxdp_rule_dropped_packets_total",
+			"Packets dropped by a blocked rule", []string{"rule"}, nil),
+		ruleDroppedBytes: prometheus.NewDesc("goxdp_rule_dropped_bytes_total",
+			"Bytes dropped by a blocked rule", []string{"rule"}, nil),
+		ruleMonitoredPackets: prometheus.NewDesc("goxdp_rule_monitored_packets_total",
+			"Packets that a blocked rule would have dropped while it was in monitor mode", []string{"rule"}, nil),
+		ruleMonitoredBytes: prometheus.NewDesc("goxdp_rule_monitored_bytes_total",
+			"Bytes that a blocked rule would have dropped while it was in monitor mode", []string{"rule"}, nil),
+		rulesOmitted: prometheus.NewDesc("goxdp_rule_series_omitted",
+			"Blocked rules left out of the per rule series by the cardinality cap", nil, nil),
+		rulesWithTimeout: prometheus.NewDesc("goxdp_rules_with_timeout",
+			"Active blocked rules that expire", nil, nil),
+		allowedPrefixes: prometheus.NewDesc("goxdp_allowed_prefixes",
+			"Prefixes of the allow list, by address family", []string{"family"}, nil),
+		interfaceInfo: prometheus.NewDesc("goxdp_interface_info",
+			"Interfaces the XDP program is attached to, with their mode", []string{"interface", "mode"}, nil),
+		mapEntries: prometheus.NewDesc("goxdp_map_entries",
+			"Entries of the BPF map", []string{"map"}, nil),
+		mapMaxEntries: prometheus.NewDesc("goxdp_map_max_entries",
+			"Largest number of entries of the BPF map", []string{"map"}, nil),
+		mapFillRatio: prometheus.NewDesc("goxdp_map_fill_ratio",
+			"Entries of the BPF map divided by its largest number of entries", []string{"map"}, nil),
+	}
+}
+
+func (c *firewallCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.verdictPackets
+	ch <- c.verdictBytes
+	ch <- c.droppedPackets
+	ch <- c.droppedBytes
+	ch <- c.addressDroppedPackets
+	ch <- c.addressDroppedBytes
+	ch <- c.addressesOmitted
+	ch <- c.rules
+	ch <- c.ruleDroppedPackets
+	ch <- c.ruleDroppedBytes
+	ch <- c.ruleMonitoredPackets
+	ch <- c.ruleMonitoredBytes
+	ch <- c.rulesOmitted
+	ch <- c.rulesWithTimeout
+	ch <- c.allowedPrefixes
+	ch <- c.interfaceInfo
+	ch <- c.mapEntries
+	ch <- c.mapMaxEntries
+	ch <- c.mapFillRatio
+}
+
+func (c *firewallCollector) Collect(ch chan<- prometheus.Metric) {
+	objs := c.app.BpfObjects
+	entries := map[string]int{}
+
+	//verdicts of every interface, the dropped totals are summed from them so that they do not depend on the status maps
+	var dropped [2]verdictCount
+	for _, value := range c.app.readVerdictsMap() {
+		dropped[0].Packets += value.DroppedSrc.Packets
+		dropped[0].Bytes += value.DroppedSrc.Bytes
+		dropped[1].Packets += value.DroppedDst.Packets
+		dropped[1].Bytes += value.DroppedDst.Bytes
+		for verdict, count := range map[string]verdictCount{
+			"passed":        value.Passed,
+			"dropped_src":   value.DroppedSrc,
+			"dropped_dst":   value.DroppedDst,
+			"dropped_gre":   value.DroppedGre,
+			"aborted":       value.Aborted,
+			"non_ip_passed": value.NonIPPassed,
+			"monitored":     value.Monitored,
+		} {
+			ch <- prometheus.MustNewConstMetric(c.verdictPackets, prometheus.CounterValue, float64(count.Packets), value.Interface, verdict)
+			ch <- prometheus.MustNewConstMetric(c.verdictBytes, prometheus.CounterValue, float64(count.Bytes), value.Interface, verdict)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.droppedPackets, prometheus.CounterValue, float64(dropped[0].Packets), "src")
+	ch <- prometheus.MustNewConstMetric(c.droppedPackets, prometheus.CounterValue, float64(dropped[1].Packets), "dst")
+	ch <- prometheus.MustNewConstMetric(c.droppedBytes, prometheus.CounterValue, float64(dropped[0].Bytes), "src")
+	ch <- prometheus.MustNewConstMetric(c.droppedBytes, prometheus.CounterValue, float64(dropped[1].Bytes), "dst")
+
+	//per address series of the addresses with the most dropped packets, the status maps are empty when -address-stats is 0
+	status := c.app.readStatusMap(objs.Status)
+	entries["status"] = len(status)
+	statusIpv6 := c.app.readStatusMap(objs.StatusIpv6)
+	entries["status_ipv6"] = len(statusIpv6)
+	status = append(status, statusIpv6...)
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Src_packets+status[i].Dst_packets > status[j].Src_packets+status[j].Dst_packets
+	})
+	omitted := 0
+	if len(status) > c.maxAddresses {
+		omitted = len(status) - c.maxAddresses
+		status = status[:c.maxAddresses]
+	}
+	for _, value := range status {
+		address := value.Target.String()
+		ch <- prometheus.MustNewConstMetric(c.addressDroppedPackets, prometheus.CounterValue, float64(value.Src_packets), address, "src")
+		ch <- prometheus.MustNewConstMetric(c.addressDroppedPackets, prometheus.CounterValue, float64(value.Dst_packets), address, "dst")
+		ch <- prometheus.MustNewConstMetric(c.addressDroppedBytes, prometheus.CounterValue, float64(value.Src_size_packets), address, "src")
+		ch <- prometheus.MustNewConstMetric(c.addressDroppedBytes, prometheus.CounterValue, float64(value.Dst_size_packets), address, "dst")
+	}
+	ch <- prometheus.MustNewConstMetric(c.addressesOmitted, prometheus.GaugeValue, float64(omitted))
+
+	//blocked rules, the expired ones waiting for the timeout worker are not active
+	rules, err := c.app.blockedRules()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.rules, err)
+	} else {
+		now, err := monotonicNow()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(c.rules, err)
+		} else {
+			active := map[[2]string]int{}
+			for _, action := range []uint8{ruleActionDrop, ruleActionRatelimit} {
+				for _, family := range []string{"ipv4", "ipv6"} {
+					active[[2]string{ruleActionName(action), family}] = 0
+				}
+			}
+			withTimeout := 0
+			//the maps without rules still get their entries series
+			entries["blocked_ipv4"] = 0
+			entries["blocked_ipv6"] = 0
+			type ruleSeries struct {
+				rule     string
+				counters bpfRuleCounters
+			}
+			series := []ruleSeries{}
+			for _, value := range rules {
+				entries["blocked_"+prefixFamily(value.Prefix)]++
+				counters, err := c.app.ruleCounters(value.Rule.Id)
+				if err != nil {
+					ch <- prometheus.NewInvalidMetric(c.ruleDroppedPackets, err)
+				} else {
+					series = append(series, ruleSeries{rule: value.Prefix.String(), counters: counters})
+				}
+				if value.Rule.ExpiresNs != 0 && now >= value.Rule.ExpiresNs {
+					continue
+				}
+				active[[2]string{ruleActionName(value.Rule.Action), prefixFamily(value.Prefix)}]++
+				if value.Rule.ExpiresNs != 0 {
+					withTimeout++
+				}
+			}
+			//per rule series of the rules with the most dropped or monitored packets
+			sort.Slice(series, func(i, j int) bool {
+				return series[i].counters.Packets+series[i].counters.MonitoredPackets > series[j].counters.Packets+series[j].counters.MonitoredPackets
+			})
+			omitted := 0
+			if len(series) > c.maxRules {
+				omitted = len(series) - c.maxRules
+				series = series[:c.maxRules]
+			}
+			for _, value := range series {
+				ch <- prometheus.MustNewConstMetric(c.ruleDroppedPackets, prometheus.CounterValue, float64(value.counters.Packets), value.rule)
+				ch <- prometheus.MustNewConstMetric(c.ruleDroppedBytes, prometheus.CounterValue, float64(value.counters.Bytes), value.rule)
+				ch <- prometheus.MustNewConstMetric(c.ruleMonitoredPackets, prometheus.CounterValue, float64(value.counters.MonitoredPackets), value.rule)
+				ch <- prometheus.MustNewConstMetric(c.ruleMonitoredBytes, prometheus.CounterValue, float64(value.counters.MonitoredBytes), value.rule)
+			}
+			ch <- prometheus.MustNewConstMetric(c.rulesOmitted, prometheus.GaugeValue, float64(omitted))
+			for labels, count := range active {
+				ch <- prometheus.MustNewConstMetric(c.rules, prometheus.GaugeValue, float64(count), labels[0], labels[1])
+			}
+			ch <- prometheus.MustNewConstMetric(c.rulesWithTimeout, prometheus.GaugeValue, float64(withTimeout))
+		}
+	}
+
+	//allow list
+	allowed, err := c.app.allowedPrefixes()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.allowedPrefixes, err)
+	} else {
+		families := map[string]int{"ipv4": 0, "ipv6": 0}
+		for _, prefix := range allowed {
+			families[prefixFamily(prefix)]++
+		}
+		entries["allowed_ipv4"] = families["ipv4"]
+		entries["allowed_ipv6"] = families["ipv6"]
+		for family, count := range families {
+			ch <- prometheus.MustNewConstMetric(c.allowedPrefixes, prometheus.GaugeValue, float64(count), family)
+		}
+	}
+
+	//interfaces
+	for _, name := range c.app.loadedInterfaces() {
+		mode, _ := c.app.interfaceMode(name)
+		ch <- prometheus.MustNewConstMetric(c.interfaceInfo, prometheus.GaugeValue, 1, name, mode)
+	}
+
+	//fill ratio of the maps
+	ratelimit, err := countEntries(objs.Ratelimit)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.mapEntries, err)
+	} else {
+		entries["ratelimit"] = ratelimit
+	}
+	for _, bpfMap := range []struct {
+		name string
+		m    *ebpf.Map
+	}{
+		{"blocked_ipv4", objs.BlockedIpv4},
+		{"blocked_ipv6", objs.BlockedIpv6},
+		{"allowed_ipv4", objs.AllowedIpv4},
+		{"allowed_ipv6", objs.AllowedIpv6},
+		{"status", objs.Status},
+		{"status_ipv6", objs.StatusIpv6},
+		{"ratelimit", objs.Ratelimit},
+	} {
+		count, ok := entries[bpfMap.name]
+		if !ok {
+			continue
+		}
+		maxEntries := bpfMap.m.MaxEntries()
+		ch <- prometheus.MustNewConstMetric(c.mapEntries, prometheus.GaugeValue, float64(count), bpfMap.name)
+		ch <- prometheus.MustNewConstMetric(c.mapMaxEntries, prometheus.GaugeValue, float64(maxEntries), bpfMap.name)
+		if maxEntries > 0 {
+			ch <- prometheus.MustNewConstMetric(c.mapFillRatio, prometheus.GaugeValue, float64(count)/float64(maxEntries), bpfMap.name)
+		}
+	}
+}
+
+// prefixFamily returns the address family label of the prefix
+func prefixFamily(prefix netip.Prefix) string {
+	if prefix.Addr().Is4() {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// countEntries returns the number of keys of the map
+func countEntries(m *ebpf.Map) (int, error) {
+	count := 0
+	key := make([]byte, m.KeySize())
+	var previous any
+	for {
+		err := m.NextKey(previous, key)
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+		previous = append([]byte(nil), key...)
+	}
+}
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahsifer/goxdp/helpers"
+	"github.com/go-chi/chi/v5"
+)
+
+// largest request body read by the validation middleware, a batch of 30k rules is a few megabytes
+const maxRequestBody = 64 << 20
+
+// routeDoc documents a single route of the private or public router, the request and response
+// schemas are generated from the structs that the handlers decode and encode
+type routeDoc struct {
+	Summary string
+	// Description explains the behaviour that the summary and the schemas do not show
+	Description string
+	Deprecated  bool
+	// Scope is the token scope needed by the route when authentication is enabled, empty for public routes
+	Scope string
+	// Body is a value of the request struct, nil for the routes without a body
+	Body any
+	// Fields limits the properties of Body accepted by the route and Required lists the mandatory ones,
+	// the structs used by a single route mark them with the openapi:"required" tag instead
+	Fields   []string
+	Required []string
+	// Param is the name of the path parameter matched by the chi wildcard
+	Param string
+	// Query documents the query parameters of the route
+	Query []queryParam
+	// Response is a value of the response struct, nil for the routes without a body
+	Response any
+	Text     bool
+	// ContentType replaces application/json for the routes that answer with another format, Response is
+	// the schema of a single event of a text/event-stream and the body is binary when Response is nil
+	ContentType string
+	Status      int
+	Errors      []int
+}
+
+// queryParam is an optional query parameter of a route
+type queryParam struct {
+	Name        string
+	Description string
+	Format      string
+}
+
+// ratelimitDescription documents the limits of the ratelimit action for the routes that create rules
+const ratelimitDescription = "The pps and bps limits of the ratelimit action apply to every source address of the rule. " +
+	"The token buckets are kept per CPU, so a source spread over N receive queues can pass up to N times the limits."
+
+// routeDocs holds the documentation of every route keyed by method and chi pattern
+var routeDocs = map[string]routeDoc{
+	"POST /load": {
+		Summary: "Load the XDP program to the comma separated interfaces", Deprecated: true,
+		Scope: scopeInterfacesWrite,
+		Body:  load{}, Fields: []string{"interfaces", "mode"}, Required: []string{"interfaces", "mode"},
+		Status: http.StatusOK, Errors: []int{http.StatusBadRequest},
+	},
+	"POST /unload": {
+		Summary: "Unload the XDP program from the comma separated interfaces or all of them", Deprecated: true,
+		Scope: scopeInterfacesWrite,
+		Body:  load{}, Fields: []string{"interfaces"}, Required: []string{"interfaces"},
+		Status: http.StatusOK, Errors: []int{http.StatusBadRequest},
+	},
+	"POST /block": {
+		Summary: "Block, rate limit, or unblock an IP address or subnet", Deprecated: true, Description: ratelimitDescription,
+		Scope:    scopeRulesWrite,
+		Body:     blockLoad{},
+		Fields:   []string{"target", "action", "mode", "timeout", "protocol", "src_port", "dst_port", "pps", "bps", "comment", "owner"},
+		Required: []string{"target", "action", "timeout"},
+		Response: blockOutput{}, Status: http.StatusOK,
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInsufficientStorage, http.StatusInternalServerError},
+	},
+	"POST /block/batch": {
+		Summary: "Block, rate limit, or unblock many IP addresses or subnets in one request", Deprecated: true,
+		Description: "Replaced by POST /v1/rules and DELETE /v1/rules/{cidr}. " + ratelimitDescription,
+		Scope:       scopeRulesWrite,
+		Body:        batchLoad{}, Response: batchOutput{},
+		Status: http.StatusOK, Errors: []int{http.StatusBadRequest},
+	},
+	"GET /status": {
+		Summary: "Show the interfaces, rules, allow list, timeouts and dropped packets", Deprecated: true,
+		Description: "Replaced by GET /v1/rules, GET /v1/interfaces, GET /allow-list and GET /v1/stats on the private router. " +
+			"The public router leaves out the comment, owner, origin, created and updated fields of the rules.",
+		Scope:    scopeStatsRead,
+		Response: statusMapOutput{}, Status: http.StatusOK,
+	},
+	"POST /flushblocked": {
+		Summary: "Unblock all the IP addresses and subnets", Deprecated: true,
+		Description: "Replaced by DELETE /v1/rules/{cidr} for every rule of GET /v1/rules.",
+		Scope:       scopeRulesWrite,
+		Status:      http.StatusOK, Errors: []int{http.StatusInternalServerError},
+	},
+	"POST /rules/optimize": {
+		Summary: "Remove the shadowed rules and merge the adjacent rules of the same action, timeout and owner",
+		Scope:   scopeRulesWrite,
+		Query: []queryParam{
+			{Name: "dry_run", Description: "Only report the rules that would be removed and merged when true"},
+		},
+		Response: optimizeOutput{}, Status: http.StatusOK,
+		Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+	},
+	"POST /flushstatus": {
+		Summary: "Empty the status table and the rate limit buckets", Deprecated: true,
+		Scope:  scopeStatsWrite,
+		Status: http.StatusOK, Errors: []int{http.StatusInternalServerError},
+	},
+	"GET /allow-list": {
+		Summary:  "List the allowed IP addresses and subnets",
+		Scope:    scopeRulesRead,
+		Response: []string{}, Status: http.StatusOK, Errors: []int{http.StatusInternalServerError},
+	},
+	"POST /allow-list": {
+		Summary: "Allow an IP address or subnet inside the blocked subnets",
+		Scope:   scopeRulesWrite,
+		Body:    blockLoad{}, Fields: []string{"target"}, Required: []string{"target"},
+		Status: http.StatusOK, Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+	},
+	"DELETE /allow-list": {
+		Summary: "Remove an IP address or subnet from the allow list",
+		Scope:   scopeRulesWrite,
+		Body:    blockLoad{}, Fields: []string{"target"}, Required: []string{"target"},
+		Status: http.StatusOK, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	"POST /flushallowed": {
+		Summary: "Empty the allow list",
+		Scope:   scopeRulesWrite,
+		Status:  http.StatusOK, Errors: []int{http.StatusInternalServerError},
+	},
+	"GET /v1/rules": {
+		Summary:  "List the blocked rules",
+		Scope:    scopeRulesRead,
+		Response: []statusBlockedOutput{}, Status: http.StatusOK, Errors: []int{http.StatusInternalServerError},
+	},
+	"POST /v1/rules": {
+		Summary:     "Create a blocked rule",
+		Description: ratelimitDescription,
+		Scope:       scopeRulesWrite,
+		Body:        ruleEntry{}, Response: statusBlockedOutput{},
+		Status: http.StatusCreated, Errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusInsufficientStorage},
+	},
+	"GET /v1/rules/*": {
+		Summary: "Show the rule of a CIDR", Param: "cidr",
+		Scope:    scopeRulesRead,
+		Response: statusBlockedOutput{}, Status: http.StatusOK, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	"PATCH /v1/rules/*": {
+		Summary: "Change the fields of the rule of a CIDR that are present in the body", Param: "cidr", Description: ratelimitDescription,
+		Scope:    scopeRulesWrite,
+		Body:     blockLoad{},
+		Fields:   []string{"action", "mode", "timeout", "protocol", "src_port", "dst_port", "pps", "bps", "comment", "owner"},
+		Response: statusBlockedOutput{}, Status: http.StatusOK, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	"DELETE /v1/rules/*": {
+		Summary: "Remove the rule of a CIDR", Param: "cidr",
+		Scope:  scopeRulesWrite,
+		Status: http.StatusNoContent, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	"GET /v1/interfaces": {
+		Summary:  "List the interfaces the XDP program is attached to",
+		Scope:    scopeInterfacesRead,
+		Response: []interfaceOutput{}, Status: http.StatusOK,
+	},
+	"GET /v1/interfaces/{name}": {
+		Summary:  "Show whether the XDP program is attached to the interface",
+		Scope:    scopeInterfacesRead,
+		Response: interfaceOutput{}, Status: http.StatusOK, Errors: []int{http.StatusNotFound},
+	},
+	"PUT /v1/interfaces/{name}": {
+		Summary: "Attach the XDP program to the interface with the given mode",
+		Scope:   scopeInterfacesWrite,
+		Body:    interfaceLoad{}, Response: interfaceOutput{},
+		Status: http.StatusOK, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"DELETE /v1/interfaces/{name}": {
+		Summary: "Detach the XDP program from the interface",
+		Scope:   scopeInterfacesWrite,
+		Status:  http.StatusNoContent, Errors: []int{http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"GET /v1/settings": {
+		Summary:  "Show the settings that can be changed while the service runs",
+		Scope:    scopeStatsRead,
+		Response: settingsOutput{}, Status: http.StatusOK,
+	},
+	"PATCH /v1/settings": {
+		Summary:     "Change the settings while the service runs",
+		Description: "Setting monitor puts every rule in monitor mode whatever its own mode, like the -monitor flag. The change applies to the next packets and lasts until the service restarts.",
+		Scope:       scopeRulesWrite,
+		Body:        settingsPatch{}, Response: settingsOutput{},
+		Status: http.StatusOK, Errors: []int{http.StatusBadRequest, http.StatusInternalServerError},
+	},
+	"GET /v1/stats": {
+		Summary:  "Show the dropped packets of every address and the rate limited sources",
+		Scope:    scopeStatsRead,
+		Response: statsOutput{}, Status: http.StatusOK,
+	},
+	"DELETE /v1/stats": {
+		Summary: "Empty the status table and the rate limit buckets",
+		Scope:   scopeStatsWrite,
+		Status:  http.StatusNoContent, Errors: []int{http.StatusInternalServerError},
+	},
+	"GET /audit": {
+		Summary: "Show the records of the audit log, the newest ones when more records match than the limit",
+		Scope:   scopeAuditRead,
+		Query: []queryParam{
+			{Name: "since", Description: "Only the records at or after this time", Format: "date-time"},
+			{Name: "until", Description: "Only the records at or before this time", Format: "date-time"},
+			{Name: "target", Description: "Only the records of the subnets that overlap this IP address or subnet, or of this interface"},
+			{Name: "actor", Description: "Only the records of this actor"},
+			{Name: "action", Description: "Only the records of this action"},
+			{Name: "limit", Description: "Largest number of records returned, 1000 by default"},
+		},
+		Response: []auditRecord{}, Status: http.StatusOK, Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+	},
+	"GET /events": {
+		Summary: "Stream the changes of the rules and interfaces and the packets dropped by every rule as Server-Sent Events",
+		Scope:   scopeEventsRead,
+		Query: []queryParam{
+			{Name: "types", Description: "Only the events of these comma separated types"},
+		},
+		Response: firewallEvent{}, ContentType: "text/event-stream", Status: http.StatusOK,
+		Errors: []int{http.StatusBadRequest, http.StatusServiceUnavailable},
+	},
+	"GET /capture": {
+		Summary: "Capture samples of the dropped packets for a while and return them as a pcapng file",
+		Scope:   scopeCaptureRead,
+		Query: []queryParam{
+			{Name: "duration", Description: "How long the dropped packets are sampled, 10s by default and 5m at most"},
+		},
+		ContentType: "application/x-pcapng", Status: http.StatusOK,
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError},
+	},
+	"GET /metrics": {
+		Summary: "Prometheus metrics", Text: true, Status: http.StatusOK,
+	},
+	"GET /openapi.json": {
+		Summary:  "This OpenAPI document",
+		Response: map[string]any{}, Status: http.StatusOK,
+	},
+}
+
+// fields renamed since the first versions of the API, used to hint the new name of an unknown field
+var renamedFields = map[string]string{
+	"src": "target",
+}
+
+// schema is the subset of the OpenAPI 3 schema object generated from the structs
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+// schemaGenerator converts the Go types to schemas, named structs become components
+type schemaGenerator struct {
+	components map[string]*schema
+}
+
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf returns the schema of the type, the named structs are added to the components and referenced
+func (g *schemaGenerator) schemaOf(t reflect.Type) *schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &schema{Type: "string", Format: "date-time"}
+	}
+	//raw JSON can hold any value
+	if t == rawMessageType {
+		return &schema{}
+	}
+	//netip.Addr and netip.Prefix are encoded as text
+	if t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType) {
+		return &schema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		minimum := 0.0
+		if t.Bits() == 64 {
+			return &schema{Type: "integer", Minimum: &minimum}
+		}
+		maximum := math.Pow(2, float64(t.Bits())) - 1
+		return &schema{Type: "integer", Minimum: &minimum, Maximum: &maximum}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object"}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.components[t.Name()]; !ok {
+			//reserve the name first so recursive types stop here
+			g.components[t.Name()] = &schema{}
+			*g.components[t.Name()] = *g.structSchema(t)
+		}
+		return &schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &schema{}
+}
+
+// structSchema returns the object schema of the struct with the properties named by their json tags,
+// the fields of the embedded structs are promoted like encoding/json does
+func (g *schemaGenerator) structSchema(t reflect.Type) *schema {
+	object := &schema{Type: "object", Properties: map[string]*schema{}, AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		embeddedType := field.Type
+		if embeddedType.Kind() == reflect.Pointer {
+			embeddedType = embeddedType.Elem()
+		}
+		if field.Anonymous && embeddedType.Kind() == reflect.Struct {
+			embedded := g.structSchema(embeddedType)
+			for name, property := range embedded.Properties {
+				object.Properties[name] = property
+			}
+			object.Required = append(object.Required, embedded.Required...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := g.schemaOf(field.Type)
+		if enum := field.Tag.Get("enum"); enum != "" {
+			property.Enum = strings.Split(enum, ",")
+		}
+		if field.Tag.Get("openapi") == "required" {
+			object.Required = append(object.Required, name)
+		}
+		object.Properties[name] = property
+	}
+	sort.Strings(object.Required)
+	return object
+}
+
+// requestSchema returns the schema of the request body of the route limited to its fields
+func (g *schemaGenerator) requestSchema(doc routeDoc) *schema {
+	if doc.Body == nil {
+		return nil
+	}
+	body := g.schemaOf(reflect.TypeOf(doc.Body))
+	if len(doc.Fields) == 0 && len(doc.Required) == 0 {
+		return body
+	}
+	//the structs shared between routes are narrowed to the fields of the route
+	full := g.resolve(body)
+	narrowed := &schema{Type: "object", Properties: map[string]*schema{}, AdditionalProperties: false, Required: doc.Required}
+	for _, name := range doc.Fields {
+		narrowed.Properties[name] = full.Properties[name]
+	}
+	return narrowed
+}
+
+// resolve follows the reference of the schema to its component
+func (g *schemaGenerator) resolve(s *schema) *schema {
+	if s.Ref == "" {
+		return s
+	}
+	return g.components[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+}
+
+// validate checks the decoded JSON value against the schema and appends the error of every invalid field,
+// null values are treated as missing fields
+func (g *schemaGenerator) validate(s *schema, value any, field string, errs []helpers.FieldError) []helpers.FieldError {
+	s = g.resolve(s)
+	fail := func(format string, args ...any) []helpers.FieldError {
+		return append(errs, helpers.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if value == nil {
+		return errs
+	}
+	switch s.Type {
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return fail("should be a string")
+		}
+		if len(s.Enum) > 0 && !containsString(s.Enum, text) {
+			return fail("should be one of %s", strings.Join(s.Enum, ", "))
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, text); err != nil {
+				return fail("should be an RFC 3339 date and time")
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fail("should be a boolean")
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fail("should be a number")
+		}
+		if s.Type == "integer" {
+			if _, err := strconv.ParseInt(number.String(), 10, 64); err != nil {
+				if _, err := strconv.ParseUint(number.String(), 10, 64); err != nil {
+					return fail("should be an integer")
+				}
+			}
+		}
+		parsed, _ := number.Float64()
+		if s.Minimum != nil && parsed < *s.Minimum {
+			return fail("should be %v or greater", *s.Minimum)
+		}
+		if s.Maximum != nil && parsed > *s.Maximum {
+			return fail("should be %.0f or less", *s.Maximum)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fail("should be an array")
+		}
+		for i, item := range items {
+			errs = g.validate(s.Items, item, fmt.Sprintf("%s[%d]", field, i), errs)
+		}
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fail("should be an object")
+		}
+		prefix := ""
+		if field != "" {
+			prefix = field + "."
+		}
+		for _, name := range s.Required {
+			if object[name] == nil {
+				errs = append(errs, helpers.FieldError{Field: prefix + name, Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != false {
+					continue
+				}
+				message := "is not a known field"
+				if renamed, ok := renamedFields[name]; ok && s.Properties[renamed] != nil {
+					message += ", did you mean " + renamed
+				}
+				errs = append(errs, helpers.FieldError{Field: prefix + name, Message: message})
+				continue
+			}
+			errs = g.validate(property, object[name], prefix+name, errs)
+		}
+	}
+	return errs
+}
+
+func containsString(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// openAPIPath converts the chi pattern to an OpenAPI path
+func openAPIPath(pattern string, doc routeDoc) string {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.TrimSuffix(pattern, "*") + "{" + doc.Param + "}"
+	}
+	return pattern
+}
+
+// pathParameters returns the parameters of the OpenAPI path
+func pathParameters(openPath string) []map[string]any {
+	parameters := []map[string]any{}
+	for _, part := range strings.Split(openPath, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			parameters = append(parameters, map[string]any{
+				"name":     strings.Trim(part, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]string{"type": "string"},
+			})
+		}
+	}
+	return parameters
+}
+
+// buildOpenAPI generates the OpenAPI document of the routes registered on the private and public routers,
+// a route without an entry in routeDocs is an error so the document never silently misses one
+func (app *Application) buildOpenAPI(private *chi.Mux, public *chi.Mux) error {
+	g := &schemaGenerator{components: map[string]*schema{}}
+	errorSchema := g.schemaOf(reflect.TypeOf(helpers.ErrorMessage{}))
+	paths := map[string]map[string]any{}
+	var missing []string
+	for _, router := range []struct {
+		tag string
+		mux *chi.Mux
+	}{{"private", private}, {"public", public}} {
+		err := chi.Walk(router.mux, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			route = path.Clean(route)
+			doc, ok := routeDocs[method+" "+route]
+			if !ok {
+				missing = append(missing, method+" "+route)
+				return nil
+			}
+			openPath := openAPIPath(route, doc)
+			if paths[openPath] == nil {
+				paths[openPath] = map[string]any{}
+			}
+			key := strings.ToLower(method)
+			//the routes served by both routers are documented once with both tags
+			if existing, ok := paths[openPath][key].(map[string]any); ok {
+				existing["tags"] = append(existing["tags"].([]string), router.tag)
+				return nil
+			}
+			paths[openPath][key] = app.operation(g, method, openPath, doc, router.tag, errorSchema)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("routes missing from routeDocs: %s", strings.Join(missing, ", "))
+	}
+
+	document := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":       "GoXDP",
+			"description": "XDP firewall service. The private routes are served on the private listener and the public routes on the public listener.",
+			"version":     "1.0.0",
+		},
+		"tags": []map[string]string{
+			{"name": "private", "description": "routes of the private listener"},
+			{"name": "public", "description": "routes of the public listener"},
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]string{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Token of the tokens file, the scope of every route is listed in its security requirement. Only enforced when the server runs with -tokens",
+				},
+			},
+		},
+	}
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return err
+	}
+	//the request schemas are generated once so the middleware only reads them
+	requests := map[string]*schema{}
+	for key, doc := range routeDocs {
+		if body := g.requestSchema(doc); body != nil {
+			requests[key] = body
+		}
+	}
+	app.openAPIDocument = data
+	app.openAPISchemas = g
+	app.openAPIRequests = requests
+	return nil
+}
+
+// operation returns the OpenAPI operation object of the route
+func (app *Application) operation(g *schemaGenerator, method string, openPath string, doc routeDoc, tag string, errorSchema *schema) map[string]any {
+	operation := map[string]any{
+		"summary":     doc.Summary,
+		"operationId": operationID(method, openPath),
+		"tags":        []string{tag},
+	}
+	if doc.Description != "" {
+		operation["description"] = doc.Description
+	}
+	if doc.Deprecated {
+		operation["deprecated"] = true
+	}
+	//the routes of the public router never need a token
+	if doc.Scope != "" && tag == "private" {
+		operation["security"] = []map[string][]string{{"bearer": {doc.Scope}}}
+	}
+	parameters := pathParameters(openPath)
+	for _, param := range doc.Query {
+		parameters = append(parameters, map[string]any{
+			"name":        param.Name,
+			"in":          "query",
+			"description": param.Description,
+			"schema":      &schema{Type: "string", Format: param.Format},
+		})
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if body := g.requestSchema(doc); body != nil {
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": body}},
+		}
+	}
+	responses := map[string]any{}
+	success := map[string]any{"description": http.StatusText(doc.Status)}
+	if doc.Text {
+		success["content"] = map[string]any{"text/plain": map[string]any{"schema": &schema{Type: "string"}}}
+	} else if doc.ContentType != "" && doc.Response != nil {
+		success["content"] = map[string]any{doc.ContentType: map[string]any{"schema": g.schemaOf(reflect.TypeOf(doc.Response))}}
+	} else if doc.ContentType != "" {
+		success["content"] = map[string]any{doc.ContentType: map[string]any{"schema": &schema{Type: "string", Format: "binary"}}}
+	} else if doc.Response != nil {
+		success["content"] = map[string]any{"application/json": map[string]any{"schema": g.schemaOf(reflect.TypeOf(doc.Response))}}
+	}
+	responses[strconv.Itoa(doc.Status)] = success
+	errors := doc.Errors
+	if operation["requestBody"] != nil && !containsInt(errors, http.StatusBadRequest) {
+		errors = append(errors, http.StatusBadRequest)
+	}
+	if operation["security"] != nil {
+		errors = append(errors, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	for _, status := range errors {
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+		}
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+func containsInt(values []int, value int) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// operationID names the operation after its method and path, for example postV1Rules or getV1RulesCidr
+func operationID(method string, openPath string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(openPath, func(r rune) bool { return r == '/' || r == '-' || r == '.' || r == '{' || r == '}' }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// serve the OpenAPI document
+func (app *Application) openAPI(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(app.openAPIDocument)
+}
+
+// validateRequest rejects the request bodies that do not match the schema of their route with the error
+// of every field, the body is handed to the handler unchanged when it is valid
+func (app *Application) validateRequest(mux *chi.Mux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			if app.openAPIRequests == nil || request.Body == nil || request.Method == http.MethodGet {
+				next.ServeHTTP(response, request)
+				return
+			}
+			routePath := request.URL.RawPath
+			if routePath == "" {
+				routePath = request.URL.Path
+			}
+			rctx := chi.NewRouteContext()
+			if !mux.Match(rctx, request.Method, path.Clean(routePath)) {
+				next.ServeHTTP(response, request)
+				return
+			}
+			body, ok := app.openAPIRequests[request.Method+" "+rctx.RoutePattern()]
+			if !ok {
+				next.ServeHTTP(response, request)
+				return
+			}
+
+			data, err := io.ReadAll(io.LimitReader(request.Body, maxRequestBody))
+			if err != nil {
+				helpers.Error(response, "Cannot read the request body", http.StatusBadRequest)
+				return
+			}
+			var value any
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			if err := decoder.Decode(&value); err != nil {
+				helpers.ValidationError(response, []helpers.FieldError{{Field: "", Message: "invalid JSON: " + err.Error()}})
+				return
+			}
+			if errs := app.openAPISchemas.validate(body, value, "", nil); len(errs) > 0 {
+				helpers.ValidationError(response, errs)
+				return
+			}
+			request.Body = io.NopCloser(bytes.NewReader(data))
+			next.ServeHTTP(response, request)
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ahsifer/goxdp/helpers"
+	"github.com/go-chi/chi/v5"
+)
+
+// testApplication returns an application with the OpenAPI document of its routers built
+func testApplication(t *testing.T) *Application {
+	app := &Application{
+		InfoLog:  log.New(io.Discard, "", 0),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	if err := app.buildOpenAPI(app.privateRouter(), app.publicRouter()); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func TestRouteDocs(t *testing.T) {
+	app := &Application{
+		InfoLog:  log.New(io.Discard, "", 0),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	routes := map[string]bool{}
+	for _, mux := range []*chi.Mux{app.privateRouter(), app.publicRouter()} {
+		err := chi.Walk(mux, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			key := method + " " + path.Clean(route)
+			routes[key] = true
+			if _, ok := routeDocs[key]; !ok {
+				t.Errorf("route %s has no entry in routeDocs", key)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, doc := range routeDocs {
+		if !routes[key] {
+			t.Errorf("routeDocs entry %s has no route", key)
+		}
+		if doc.Summary == "" || doc.Status == 0 {
+			t.Errorf("routeDocs entry %s needs a summary and a status", key)
+		}
+		if doc.Body != nil {
+			full := reflect.TypeOf(doc.Body)
+			for _, name := range append(append([]string{}, doc.Fields...), doc.Required...) {
+				if !hasJSONField(full, name) {
+					t.Errorf("routeDocs entry %s lists the field %s that %s does not have", key, name, full.Name())
+				}
+			}
+		}
+	}
+}
+
+// hasJSONField reports whether the struct has a field with the json name, the embedded structs included
+func hasJSONField(t reflect.Type, name string) bool {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && hasJSONField(field.Type, name) {
+			return true
+		}
+		if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBuildOpenAPIMissingRoute(t *testing.T) {
+	app := &Application{
+		InfoLog:  log.New(io.Discard, "", 0),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	public := app.publicRouter()
+	public.Get("/undocumented", app.openAPI)
+	err := app.buildOpenAPI(app.privateRouter(), public)
+	if err == nil || !strings.Contains(err.Error(), "GET /undocumented") {
+		t.Errorf("buildOpenAPI returned %v, want the undocumented route", err)
+	}
+}
+
+func TestSchemaOf(t *testing.T) {
+	type nested struct {
+		Name string `json:"name" openapi:"required"`
+	}
+	type example struct {
+		Mode     string   `json:"mode" enum:"enforce,monitor"`
+		Count    uint8    `json:"count"`
+		Total    uint64   `json:"total"`
+		Signed   int      `json:"signed"`
+		Enabled  *bool    `json:"enabled"`
+		Targets  []string `json:"targets"`
+		Skipped  string   `json:"-"`
+		internal string
+		nested
+	}
+	g := &schemaGenerator{components: map[string]*schema{}}
+	ref := g.schemaOf(reflect.TypeOf(example{}))
+	if ref.Ref != "#/components/schemas/example" {
+		t.Fatalf("schemaOf returned the reference %q", ref.Ref)
+	}
+	object := g.resolve(ref)
+	if object.AdditionalProperties != false {
+		t.Errorf("additionalProperties = %v, want false", object.AdditionalProperties)
+	}
+	if !reflect.DeepEqual(object.Required, []string{"name"}) {
+		t.Errorf("required = %v, want [name]", object.Required)
+	}
+	names := []string{}
+	for name := range object.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"count", "enabled", "mode", "name", "signed", "targets", "total"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("properties = %v, want %v", names, want)
+	}
+	tests := []struct {
+		name    string
+		typ     string
+		enum    []string
+		maximum float64
+		items   string
+	}{
+		{name: "mode", typ: "string", enum: []string{"enforce", "monitor"}},
+		{name: "count", typ: "integer", maximum: 255},
+		{name: "total", typ: "integer"},
+		{name: "signed", typ: "integer"},
+		{name: "enabled", typ: "boolean"},
+		{name: "targets", typ: "array", items: "string"},
+		{name: "name", typ: "string"},
+	}
+	for _, test := range tests {
+		property := object.Properties[test.name]
+		if property == nil {
+			continue
+		}
+		if property.Type != test.typ || !reflect.DeepEqual(property.Enum, test.enum) {
+			t.Errorf("%s has the type %s and enum %v, want %s and %v", test.name, property.Type, property.Enum, test.typ, test.enum)
+		}
+		if test.maximum != 0 && (property.Maximum == nil || *property.Maximum != test.maximum) {
+			t.Errorf("%s has the maximum %v, want %v", test.name, property.Maximum, test.maximum)
+		}
+		if test.items != "" && (property.Items == nil || property.Items.Type != test.items) {
+			t.Errorf("%s has the items %v, want %s", test.name, property.Items, test.items)
+		}
+	}
+}
+
+func TestRequestSchemaEnums(t *testing.T) {
+	g := &schemaGenerator{components: map[string]*schema{}}
+	tests := []struct {
+		route string
+		field string
+		want  []string
+	}{
+		{route: "POST /load", field: "mode", want: []string{"nv", "skb", "hw"}},
+		{route: "POST /block", field: "mode", want: []string{"enforce", "monitor"}},
+		{route: "POST /block", field: "action", want: []string{"block", "ratelimit", "allow"}},
+		{route: "PATCH /v1/rules/*", field: "mode", want: []string{"enforce", "monitor"}},
+		{route: "PUT /v1/interfaces/{name}", field: "mode", want: []string{"nv", "skb", "hw"}},
+	}
+	for _, test := range tests {
+		body := g.resolve(g.requestSchema(routeDocs[test.route]))
+		property := body.Properties[test.field]
+		if property == nil {
+			t.Errorf("%s has no %s field", test.route, test.field)
+			continue
+		}
+		if !reflect.DeepEqual(property.Enum, test.want) {
+			t.Errorf("%s %s enum = %v, want %v", test.route, test.field, property.Enum, test.want)
+		}
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	app := testApplication(t)
+	mux := chi.NewRouter()
+	mux.Use(app.validateRequest(mux))
+	reached := func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusOK)
+	}
+	mux.Post("/load", reached)
+	mux.Post("/block", reached)
+	mux.Post("/allow-list", reached)
+	mux.Post("/v1/rules", reached)
+	mux.Patch("/v1/rules/*", reached)
+	mux.Patch("/v1/settings", reached)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		errors []string
+	}{
+		{name: "valid block", method: http.MethodPost, target: "/block", body: `{"target":"10.0.0.0/8","action":"block","timeout":0,"mode":"monitor"}`},
+		{name: "null fields are missing", method: http.MethodPost, target: "/block", body: `{"target":"10.0.0.0/8","action":"block","timeout":0,"mode":null}`},
+		{name: "required fields", method: http.MethodPost, target: "/block", body: `{}`, errors: []string{"target: is required", "action: is required", "timeout: is required"}},
+		{name: "action enum", method: http.MethodPost, target: "/block", body: `{"target":"10.0.0.0/8","action":"drop","timeout":0}`, errors: []string{"action: should be one of block, ratelimit, allow"}},
+		{name: "xdp mode on block", method: http.MethodPost, target: "/block", body: `{"target":"10.0.0.0/8","action":"block","timeout":0,"mode":"skb"}`, errors: []string{"mode: should be one of enforce, monitor"}},
+		{name: "rule mode on load", method: http.MethodPost, target: "/load", body: `{"interfaces":"eth0","mode":"monitor"}`, errors: []string{"mode: should be one of nv, skb, hw"}},
+		{name: "valid load", method: http.MethodPost, target: "/load", body: `{"interfaces":"eth0","mode":"skb"}`},
+		{name: "renamed field", method: http.MethodPost, target: "/block", body: `{"src":"10.0.0.0/8","action":"block","timeout":0}`, errors: []string{"target: is required", "src: is not a known field, did you mean target"}},
+		{name: "field of another route", method: http.MethodPost, target: "/allow-list", body: `{"target":"10.0.0.1","action":"block"}`, errors: []string{"action: is not a known field"}},
+		{name: "negative timeout", method: http.MethodPost, target: "/block", body: `{"target":"10.0.0.0/8","action":"block","timeout":-1}`, errors: []string{"timeout: should be 0 or greater"}},
+		{name: "fraction", method: http.MethodPost, target: "/block", body: `{"target":"10.0.0.0/8","action":"block","timeout":1.5}`, errors: []string{"timeout: should be an integer"}},
+		{name: "string for a number", method: http.MethodPost, target: "/v1/rules", body: `{"target":"10.0.0.0/8","timeout":"60"}`, errors: []string{"timeout: should be a number"}},
+		{name: "required tag", method: http.MethodPost, target: "/v1/rules", body: `{"action":"block"}`, errors: []string{"target: is required"}},
+		{name: "patch without fields", method: http.MethodPatch, target: "/v1/rules/10.0.0.0%2F8", body: `{}`},
+		{name: "patch enum", method: http.MethodPatch, target: "/v1/rules/10.0.0.0/8", body: `{"mode":"hw"}`, errors: []string{"mode: should be one of enforce, monitor"}},
+		{name: "boolean", method: http.MethodPatch, target: "/v1/settings", body: `{"monitor":"yes"}`, errors: []string{"monitor: should be a boolean"}},
+		{name: "invalid json", method: http.MethodPost, target: "/block", body: `{"target"`, errors: []string{": invalid JSON"}},
+		{name: "not an object", method: http.MethodPost, target: "/block", body: `[]`, errors: []string{": should be an object"}},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		if len(test.errors) == 0 {
+			if recorder.Code != http.StatusOK {
+				t.Errorf("%s: status %d, want 200 -> %s", test.name, recorder.Code, recorder.Body.String())
+			}
+			continue
+		}
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", test.name, recorder.Code)
+			continue
+		}
+		var message helpers.ErrorMessage
+		if err := json.NewDecoder(recorder.Body).Decode(&message); err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, fieldError := range message.Errors {
+			got = append(got, fieldError.Field+": "+fieldError.Message)
+		}
+		for _, want := range test.errors {
+			found := false
+			for _, text := range got {
+				found = found || strings.HasPrefix(text, want)
+			}
+			if !found {
+				t.Errorf("%s: errors %v, want %q", test.name, got, want)
+			}
+		}
+		if len(got) != len(test.errors) {
+			t.Errorf("%s: %d errors %v, want %d", test.name, len(got), got, len(test.errors))
+		}
+	}
+}
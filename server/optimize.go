@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+
+	"github.com/ahsifer/goxdp/helpers"
+	"github.com/cilium/ebpf"
+)
+
+// Structs used by the rulesOptimize handler
+type shadowedRule struct {
+	Target     string `json:"target"`
+	ShadowedBy string `json:"shadowed_by"`
+}
+type mergedRule struct {
+	Target string   `json:"target"`
+	From   []string `json:"from"`
+}
+type optimizeOutput struct {
+	DryRun bool `json:"dry_run"`
+	// rules of the blocked maps before and after the optimization
+	Before  int            `json:"before"`
+	After   int            `json:"after"`
+	Removed []shadowedRule `json:"removed"`
+	Merged  []mergedRule   `json:"merged"`
+	Failed  []batchResult  `json:"failed"`
+}
+
+// sameRule reports whether the rules match and handle the packets the same way, their IDs, prefix lengths
+// and expiry are not compared
+func sameRule(a bpfRule, b bpfRule) bool {
+	a.Id, b.Id = 0, 0
+	a.Prefixlen, b.Prefixlen = 0, 0
+	a.ExpiresNs, b.ExpiresNs = 0, 0
+	return a == b
+}
+
+// outlives reports whether the rule a expires at the same time or after the rule b
+func outlives(a bpfRule, b bpfRule) bool {
+	return a.ExpiresNs == 0 || (b.ExpiresNs != 0 && a.ExpiresNs >= b.ExpiresNs)
+}
+
+// closestRule returns the longest prefix of the rules that holds the prefix and is broader than it,
+// the XDP program falls back to its rule for the packets of the prefix once the prefix is removed
+func closestRule(rules map[netip.Prefix]bpfRule, prefix netip.Prefix) (netip.Prefix, bpfRule, bool) {
+	for bits := prefix.Bits() - 1; bits >= 0; bits-- {
+		parent := netip.PrefixFrom(prefix.Addr(), bits).Masked()
+		if rule, ok := rules[parent]; ok {
+			return parent, rule, true
+		}
+	}
+	return netip.Prefix{}, bpfRule{}, false
+}
+
+// shadowingRule returns the rule that the prefix is shadowed by, a prefix is shadowed when its closest broader
+// rule is the same rule and lasts at least as long, so that removing the prefix changes nothing
+func shadowingRule(rules map[netip.Prefix]bpfRule, prefix netip.Prefix, rule bpfRule) (netip.Prefix, bool) {
+	parent, parentRule, ok := closestRule(rules, prefix)
+	if !ok || !sameRule(parentRule, rule) || !outlives(parentRule, rule) {
+		return netip.Prefix{}, false
+	}
+	return parent, true
+}
+
+// siblingPrefix returns the other half of the parent of the prefix, the prefix should not be /0
+func siblingPrefix(prefix netip.Prefix) netip.Prefix {
+	addr := prefix.Addr().AsSlice()
+	bit := prefix.Bits() - 1
+	addr[bit/8] ^= 0x80 >> (bit % 8)
+	sibling, _ := netip.AddrFromSlice(addr)
+	return netip.PrefixFrom(sibling, prefix.Bits())
+}
+
+// sameMetadata reports whether the rules have the same origin, owner and comment so that they can be merged
+func sameMetadata(a ruleMetadata, b ruleMetadata) bool {
+	return a.Origin == b.Origin && a.Owner == b.Owner && a.Comment == b.Comment
+}
+
+// compactRules removes the shadowed rules and merges the sibling prefixes of the same rule, expiry and metadata
+// into their parent until nothing is left to merge, the rules of the configuration file are left to it. A rule
+// is only removed for a parent of the same origin and owner, so it is not lost when the parent is removed.
+// The rules and metadata are updated in place, the shadowed prefixes are returned with their shadowing rule
+// and the merged prefixes with the prefixes they replace
+func compactRules(rules map[netip.Prefix]bpfRule, metadata map[netip.Prefix]ruleMetadata) (map[netip.Prefix]netip.Prefix, map[netip.Prefix][]netip.Prefix) {
+	prefixes := make([]netip.Prefix, 0, len(rules))
+	for prefix := range rules {
+		prefixes = append(prefixes, prefix)
+	}
+	//the broader prefixes are handled first, so a prefix is compared with what is left of its parents
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Bits() != prefixes[j].Bits() {
+			return prefixes[i].Bits() < prefixes[j].Bits()
+		}
+		return prefixes[i].Addr().Less(prefixes[j].Addr())
+	})
+	shadowed := map[netip.Prefix]netip.Prefix{}
+	for _, prefix := range prefixes {
+		if metadata[prefix].Origin == originAuto {
+			continue
+		}
+		parent, ok := shadowingRule(rules, prefix, rules[prefix])
+		if ok && metadata[parent].Origin == metadata[prefix].Origin && metadata[parent].Owner == metadata[prefix].Owner {
+			shadowed[prefix] = parent
+			delete(rules, prefix)
+		}
+	}
+
+	merged := map[netip.Prefix][]netip.Prefix{}
+	sources := func(prefix netip.Prefix) []netip.Prefix {
+		if from, ok := merged[prefix]; ok {
+			delete(merged, prefix)
+			return from
+		}
+		return []netip.Prefix{prefix}
+	}
+	//the longer prefixes are merged first, so a merged parent can be merged again with its own sibling
+	for i := len(prefixes) - 1; i >= 0; i-- {
+		prefix := prefixes[i]
+		for prefix.Bits() > 0 {
+			rule, ok := rules[prefix]
+			if !ok || metadata[prefix].Origin == originAuto {
+				break
+			}
+			sibling := siblingPrefix(prefix)
+			siblingRule, ok := rules[sibling]
+			if !ok || !sameRule(rule, siblingRule) || rule.ExpiresNs != siblingRule.ExpiresNs || !sameMetadata(metadata[prefix], metadata[sibling]) {
+				break
+			}
+			parent := netip.PrefixFrom(prefix.Addr(), prefix.Bits()-1).Masked()
+			if _, ok := rules[parent]; ok {
+				break
+			}
+			rule.Id = 0
+			rules[parent] = rule
+			metadata[parent] = metadata[prefix]
+			merged[parent] = append(sources(prefix), sources(sibling)...)
+			delete(rules, prefix)
+			delete(rules, sibling)
+			delete(metadata, prefix)
+			delete(metadata, sibling)
+			prefix = parent
+		}
+	}
+	return shadowed, merged
+}
+
+// aggregatePrefixes returns the smallest set of prefixes that covers the same addresses as the prefixes
+func aggregatePrefixes(prefixes map[netip.Prefix]bool) map[netip.Prefix]bool {
+	rules := make(map[netip.Prefix]bpfRule, len(prefixes))
+	for prefix := range prefixes {
+		rules[prefix] = bpfRule{}
+	}
+	compactRules(rules, map[netip.Prefix]ruleMetadata{})
+	aggregated := make(map[netip.Prefix]bool, len(rules))
+	for prefix := range rules {
+		aggregated[prefix] = true
+	}
+	return aggregated
+}
+
+// ruleNotes tells for every rule written to the blocked maps whether it is shadowed by a broader rule or can be
+// merged with its sibling, the rules are kept as they are and the notes point to POST /rules/optimize
+func (app *Application) ruleNotes(written []blockedRule) []string {
+	notes := make([]string, len(written))
+	rules, err := app.blockedRules()
+	if err != nil {
+		app.InfoLog.Print(err)
+		return notes
+	}
+	live := make(map[netip.Prefix]bpfRule, len(rules))
+	for _, value := range rules {
+		live[value.Prefix] = value.Rule
+	}
+	metadata := app.ruleMetadataOf(rules)
+	for i, value := range written {
+		if parent, ok := shadowingRule(live, value.Prefix, value.Rule); ok {
+			notes[i] = fmt.Sprintf("shadowed by the rule of %s", parent)
+			continue
+		}
+		if value.Prefix.Bits() == 0 {
+			continue
+		}
+		sibling := siblingPrefix(value.Prefix)
+		rule, ok := live[sibling]
+		if ok && sameRule(rule, value.Rule) && rule.ExpiresNs == value.Rule.ExpiresNs && sameMetadata(metadata[sibling], metadata[value.Prefix]) {
+			notes[i] = fmt.Sprintf("mergeable with %s into %s", sibling, netip.PrefixFrom(sibling.Addr(), sibling.Bits()-1).Masked())
+		}
+	}
+	return notes
+}
+
+// coveringPrefix returns the closest broader rule of the blocked maps that holds the prefix
+func (app *Application) coveringPrefix(prefix netip.Prefix) (netip.Prefix, bool) {
+	blockedMap, key, err := lpmKey(prefix, app.BpfObjects.BlockedIpv4, app.BpfObjects.BlockedIpv6)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	//the lookup only tells that a rule covers the prefix, the closest one is searched in the blocked rules
+	var rule bpfRule
+	if err := blockedMap.Lookup(key, &rule); err != nil {
+		return netip.Prefix{}, false
+	}
+	rules, err := app.blockedRules()
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	live := make(map[netip.Prefix]bpfRule, len(rules))
+	for _, value := range rules {
+		live[value.Prefix] = value.Rule
+	}
+	parent, _, ok := closestRule(live, prefix)
+	return parent, ok
+}
+
+// notBlockedError describes why a prefix without a rule of its own cannot be unblocked, a prefix inside
+// a blocked subnet is answered with 409 and the way to let it through instead of 404
+func (app *Application) notBlockedError(prefix netip.Prefix) (string, int) {
+	if parent, ok := app.coveringPrefix(prefix); ok {
+		return fmt.Sprintf("%s has no rule of its own, it is blocked by the rule of %s, add it to the allow list with POST /allow-list to let it through", prefix, parent), http.StatusConflict
+	}
+	return "IP address or subnet already not blocked", http.StatusNotFound
+}
+
+// optimizeRules removes the shadowed rules and merges the adjacent rules of the blocked maps, the merged rules
+// are added before the rules they replace are removed so that no packet passes in between
+func (app *Application) optimizeRules(actor string, dryRun bool) (optimizeOutput, error) {
+	output := optimizeOutput{DryRun: dryRun, Removed: []shadowedRule{}, Merged: []mergedRule{}, Failed: []batchResult{}}
+	rules, err := app.blockedRules()
+	if err != nil {
+		return output, err
+	}
+	app.metadataLock.Lock()
+	defer app.metadataLock.Unlock()
+	live := make(map[netip.Prefix]bpfRule, len(rules))
+	metadata := make(map[netip.Prefix]ruleMetadata, len(rules))
+	for _, value := range rules {
+		live[value.Prefix] = value.Rule
+		metadata[value.Prefix] = app.ruleMetadata[value.Prefix]
+	}
+	previous := make(map[netip.Prefix]bpfRule, len(live))
+	for prefix, rule := range live {
+		previous[prefix] = rule
+	}
+	shadowed, merged := compactRules(live, metadata)
+	output.Before = len(rules)
+	output.After = len(live)
+
+	var added []blockedRule
+	var shadowedPrefixes []netip.Prefix
+	for prefix, parent := range shadowed {
+		output.Removed = append(output.Removed, shadowedRule{Target: prefix.String(), ShadowedBy: parent.String()})
+		shadowedPrefixes = append(shadowedPrefixes, prefix)
+	}
+	for prefix, from := range merged {
+		value := mergedRule{Target: prefix.String()}
+		for _, source := range from {
+			value.From = append(value.From, source.String())
+		}
+		sort.Strings(value.From)
+		output.Merged = append(output.Merged, value)
+		added = append(added, blockedRule{Prefix: prefix, Rule: live[prefix]})
+	}
+	sort.Slice(output.Removed, func(i, j int) bool { return output.Removed[i].Target < output.Removed[j].Target })
+	sort.Slice(output.Merged, func(i, j int) bool { return output.Merged[i].Target < output.Merged[j].Target })
+	if dryRun || (len(added) == 0 && len(shadowedPrefixes) == 0) {
+		return output, nil
+	}
+
+	//the metadata lock is held, so the audit records are built from the rules read above
+	output.After = output.Before
+	unblock := func(removed []netip.Prefix) {
+		for i, err := range app.unblockPrefixes(removed) {
+			prefix := removed[i]
+			before := ruleEntryOf(prefix, previous[prefix])
+			before.Owner, before.Comment = app.ruleMetadata[prefix].Owner, app.ruleMetadata[prefix].Comment
+			if err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+				app.audit(actor, auditUnblock, prefix.String(), before, before, err)
+				app.ErrorLog.Printf("Cannot remove the optimized rule %s -> %v", prefix, err)
+				output.Failed = append(output.Failed, batchResult{Target: prefix.String(), Status: http.StatusInternalServerError, Message: "Unable to update blocked LPM map"})
+				continue
+			}
+			app.audit(actor, auditUnblock, prefix.String(), before, nil, nil)
+			delete(app.ruleMetadata, prefix)
+			output.After--
+		}
+	}
+	//the shadowed rules go first, so a full table has room for the merged rules
+	unblock(shadowedPrefixes)
+	var replaced []netip.Prefix
+	for i, err := range app.blockPrefixes(added) {
+		prefix := added[i].Prefix
+		if err != nil {
+			//the rules it replaces are kept
+			app.audit(actor, auditBlock, prefix.String(), nil, nil, err)
+			app.ErrorLog.Printf("Cannot add the merged rule %s -> %v", prefix, err)
+			result := batchResult{Target: prefix.String(), Status: http.StatusInternalServerError, Message: "Unable to update blocked LPM map"}
+			if errors.Is(err, errRuleTableFull) {
+				result.Status, result.Message = http.StatusInsufficientStorage, err.Error()
+			}
+			output.Failed = append(output.Failed, result)
+			continue
+		}
+		app.setRuleMetadataLocked(prefix, metadata[prefix].Origin, metadata[prefix].Owner, metadata[prefix].Comment)
+		after := ruleEntryOf(prefix, added[i].Rule)
+		after.Owner, after.Comment = metadata[prefix].Owner, metadata[prefix].Comment
+		app.audit(actor, auditBlock, prefix.String(), nil, after, nil)
+		output.After++
+		replaced = append(replaced, merged[prefix]...)
+	}
+	unblock(replaced)
+	return output, nil
+}
+
+// remove the shadowed rules and merge the adjacent rules of the blocked maps, dry_run only returns the changes
+func (app *Application) rulesOptimize(response http.ResponseWriter, request *http.Request) {
+	var dryRun bool
+	if value := request.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			helpers.Error(response, "dry_run should be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	output, err := app.optimizeRules(requestActor(request), dryRun)
+	if err != nil {
+		app.InfoLog.Print(err)
+		helpers.Error(response, "Unable to read the blocked LPM maps", http.StatusInternalServerError)
+		return
+	}
+	if !dryRun && (len(output.Removed) > 0 || len(output.Merged) > 0) {
+		app.InfoLog.Printf("Optimized the blocked rules from %d to %d for %s: %d shadowed removed, %d merged, %d failed",
+			output.Before, output.After, requestActor(request), len(output.Removed), len(output.Merged), len(output.Failed))
+		app.persistState()
+	}
+	app.writeJSON(response, http.StatusOK, output)
+}
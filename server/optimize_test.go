@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// prefixRules builds the rules keyed by their prefix
+func prefixRules(rules map[string]bpfRule) map[netip.Prefix]bpfRule {
+	parsed := make(map[netip.Prefix]bpfRule, len(rules))
+	for target, rule := range rules {
+		parsed[netip.MustParsePrefix(target)] = rule
+	}
+	return parsed
+}
+
+// sortedPrefixes returns the prefixes of the map as sorted strings
+func sortedPrefixes[V any](values map[netip.Prefix]V) []string {
+	prefixes := []string{}
+	for prefix := range values {
+		prefixes = append(prefixes, prefix.String())
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+func TestSiblingPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "192.0.2.0/25", want: "192.0.2.128/25"},
+		{prefix: "192.0.2.128/25", want: "192.0.2.0/25"},
+		{prefix: "10.0.0.0/8", want: "11.0.0.0/8"},
+		{prefix: "0.0.0.0/1", want: "128.0.0.0/1"},
+		{prefix: "192.0.2.7/32", want: "192.0.2.6/32"},
+		{prefix: "2001:db8::/33", want: "2001:db8:8000::/33"},
+		{prefix: "2001:db8::1/128", want: "2001:db8::/128"},
+	}
+	for _, test := range tests {
+		got := siblingPrefix(netip.MustParsePrefix(test.prefix))
+		if got.String() != test.want {
+			t.Errorf("siblingPrefix(%s) = %s, want %s", test.prefix, got, test.want)
+		}
+	}
+}
+
+func TestShadowingRule(t *testing.T) {
+	drop := bpfRule{Action: ruleActionDrop}
+	tcp := bpfRule{Action: ruleActionDrop, Protocol: 6}
+	monitor := bpfRule{Action: ruleActionDrop, Mode: ruleModeMonitor}
+	expiring := bpfRule{Action: ruleActionDrop, ExpiresNs: 100}
+	later := bpfRule{Action: ruleActionDrop, ExpiresNs: 200}
+	tests := []struct {
+		name   string
+		rules  map[string]bpfRule
+		prefix string
+		rule   bpfRule
+		want   string
+	}{
+		{name: "same rule", rules: map[string]bpfRule{"10.0.0.0/8": drop}, prefix: "10.1.0.0/16", rule: drop, want: "10.0.0.0/8"},
+		{name: "ids and prefix lengths are ignored", rules: map[string]bpfRule{"10.0.0.0/8": {Action: ruleActionDrop, Id: 4, Prefixlen: 8}}, prefix: "10.1.0.0/16", rule: bpfRule{Action: ruleActionDrop, Id: 9, Prefixlen: 16}, want: "10.0.0.0/8"},
+		{name: "other protocol", rules: map[string]bpfRule{"10.0.0.0/8": drop}, prefix: "10.1.0.0/16", rule: tcp},
+		{name: "other mode", rules: map[string]bpfRule{"10.0.0.0/8": monitor}, prefix: "10.1.0.0/16", rule: drop},
+		{name: "parent expires first", rules: map[string]bpfRule{"10.0.0.0/8": expiring}, prefix: "10.1.0.0/16", rule: later},
+		{name: "parent expires later", rules: map[string]bpfRule{"10.0.0.0/8": later}, prefix: "10.1.0.0/16", rule: expiring, want: "10.0.0.0/8"},
+		{name: "parent never expires", rules: map[string]bpfRule{"10.0.0.0/8": drop}, prefix: "10.1.0.0/16", rule: expiring, want: "10.0.0.0/8"},
+		{name: "closest parent differs", rules: map[string]bpfRule{"10.0.0.0/8": drop, "10.1.0.0/16": tcp}, prefix: "10.1.2.0/24", rule: drop},
+		{name: "closest parent matches", rules: map[string]bpfRule{"10.0.0.0/8": tcp, "10.1.0.0/16": drop}, prefix: "10.1.2.0/24", rule: drop, want: "10.1.0.0/16"},
+		{name: "no parent", rules: map[string]bpfRule{"192.0.2.0/24": drop}, prefix: "10.1.0.0/16", rule: drop},
+		{name: "ipv6", rules: map[string]bpfRule{"2001:db8::/32": drop}, prefix: "2001:db8:1::/48", rule: drop, want: "2001:db8::/32"},
+	}
+	for _, test := range tests {
+		parent, ok := shadowingRule(prefixRules(test.rules), netip.MustParsePrefix(test.prefix), test.rule)
+		if ok != (test.want != "") || ok && parent.String() != test.want {
+			t.Errorf("%s: shadowingRule = %s %v, want %q", test.name, parent, ok, test.want)
+		}
+	}
+}
+
+func TestCompactRules(t *testing.T) {
+	drop := bpfRule{Action: ruleActionDrop}
+	tcp := bpfRule{Action: ruleActionDrop, Protocol: 6}
+	ci := ruleMetadata{Origin: originAPI, Owner: "ci"}
+	ops := ruleMetadata{Origin: originAPI, Owner: "ops"}
+	feed := ruleMetadata{Origin: originFeed, Owner: "spamhaus"}
+	config := ruleMetadata{Origin: originAuto, Owner: ownerConfig}
+	tests := []struct {
+		name     string
+		rules    map[string]bpfRule
+		metadata map[string]ruleMetadata
+		want     []string
+		shadowed map[string]string
+		merged   map[string][]string
+	}{
+		{
+			name:     "shadowed by a rule of the same owner",
+			rules:    map[string]bpfRule{"10.0.0.0/8": drop, "10.1.0.0/16": drop},
+			metadata: map[string]ruleMetadata{"10.0.0.0/8": ci, "10.1.0.0/16": ci},
+			want:     []string{"10.0.0.0/8"},
+			shadowed: map[string]string{"10.1.0.0/16": "10.0.0.0/8"},
+		},
+		{
+			name:     "parent of another owner",
+			rules:    map[string]bpfRule{"10.0.0.0/8": drop, "10.1.0.0/16": drop},
+			metadata: map[string]ruleMetadata{"10.0.0.0/8": ops, "10.1.0.0/16": ci},
+			want:     []string{"10.0.0.0/8", "10.1.0.0/16"},
+		},
+		{
+			name:     "parent of a feed",
+			rules:    map[string]bpfRule{"10.0.0.0/8": drop, "10.1.0.0/16": drop},
+			metadata: map[string]ruleMetadata{"10.0.0.0/8": feed, "10.1.0.0/16": ci},
+			want:     []string{"10.0.0.0/8", "10.1.0.0/16"},
+		},
+		{
+			name:     "parent expires first",
+			rules:    map[string]bpfRule{"10.0.0.0/8": {Action: ruleActionDrop, ExpiresNs: 100}, "10.1.0.0/16": drop},
+			metadata: map[string]ruleMetadata{"10.0.0.0/8": ci, "10.1.0.0/16": ci},
+			want:     []string{"10.0.0.0/8", "10.1.0.0/16"},
+		},
+		{
+			name:     "rules of the configuration file are left alone",
+			rules:    map[string]bpfRule{"10.0.0.0/8": drop, "10.1.0.0/16": drop, "192.0.2.0/25": drop, "192.0.2.128/25": drop},
+			metadata: map[string]ruleMetadata{"10.0.0.0/8": config, "10.1.0.0/16": config, "192.0.2.0/25": config, "192.0.2.128/25": config},
+			want:     []string{"10.0.0.0/8", "10.1.0.0/16", "192.0.2.0/25", "192.0.2.128/25"},
+		},
+		{
+			name:     "siblings merged again and again",
+			rules:    map[string]bpfRule{"192.0.2.0/26": drop, "192.0.2.64/26": drop, "192.0.2.128/26": drop, "192.0.2.192/26": drop},
+			metadata: map[string]ruleMetadata{"192.0.2.0/26": ci, "192.0.2.64/26": ci, "192.0.2.128/26": ci, "192.0.2.192/26": ci},
+			want:     []string{"192.0.2.0/24"},
+			merged:   map[string][]string{"192.0.2.0/24": {"192.0.2.0/26", "192.0.2.128/26", "192.0.2.192/26", "192.0.2.64/26"}},
+		},
+		{
+			name:     "siblings of other rules",
+			rules:    map[string]bpfRule{"192.0.2.0/25": drop, "192.0.2.128/25": tcp},
+			metadata: map[string]ruleMetadata{"192.0.2.0/25": ci, "192.0.2.128/25": ci},
+			want:     []string{"192.0.2.0/25", "192.0.2.128/25"},
+		},
+		{
+			name:     "siblings of other owners",
+			rules:    map[string]bpfRule{"192.0.2.0/25": drop, "192.0.2.128/25": drop},
+			metadata: map[string]ruleMetadata{"192.0.2.0/25": ci, "192.0.2.128/25": ops},
+			want:     []string{"192.0.2.0/25", "192.0.2.128/25"},
+		},
+		{
+			name:     "siblings with other timeouts",
+			rules:    map[string]bpfRule{"192.0.2.0/25": {Action: ruleActionDrop, ExpiresNs: 100}, "192.0.2.128/25": drop},
+			metadata: map[string]ruleMetadata{"192.0.2.0/25": ci, "192.0.2.128/25": ci},
+			want:     []string{"192.0.2.0/25", "192.0.2.128/25"},
+		},
+		{
+			name:     "parent already holds a rule",
+			rules:    map[string]bpfRule{"192.0.2.0/24": tcp, "192.0.2.0/25": drop, "192.0.2.128/25": drop},
+			metadata: map[string]ruleMetadata{"192.0.2.0/24": ci, "192.0.2.0/25": ci, "192.0.2.128/25": ci},
+			want:     []string{"192.0.2.0/24", "192.0.2.0/25", "192.0.2.128/25"},
+		},
+	}
+	for _, test := range tests {
+		rules := prefixRules(test.rules)
+		metadata := map[netip.Prefix]ruleMetadata{}
+		for target, saved := range test.metadata {
+			metadata[netip.MustParsePrefix(target)] = saved
+		}
+		shadowed, merged := compactRules(rules, metadata)
+		if got := sortedPrefixes(rules); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: rules %v, want %v", test.name, got, test.want)
+		}
+		gotShadowed := map[string]string{}
+		for prefix, parent := range shadowed {
+			gotShadowed[prefix.String()] = parent.String()
+		}
+		if len(gotShadowed) != 0 || len(test.shadowed) != 0 {
+			if !reflect.DeepEqual(gotShadowed, test.shadowed) {
+				t.Errorf("%s: shadowed %v, want %v", test.name, gotShadowed, test.shadowed)
+			}
+		}
+		gotMerged := map[string][]string{}
+		for prefix, from := range merged {
+			sources := []string{}
+			for _, source := range from {
+				sources = append(sources, source.String())
+			}
+			sort.Strings(sources)
+			gotMerged[prefix.String()] = sources
+		}
+		if len(gotMerged) != 0 || len(test.merged) != 0 {
+			if !reflect.DeepEqual(gotMerged, test.merged) {
+				t.Errorf("%s: merged %v, want %v", test.name, gotMerged, test.merged)
+			}
+		}
+	}
+}
+
+func TestAggregatePrefixes(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+		want     []string
+	}{
+		{name: "empty", want: []string{}},
+		{name: "disjoint", prefixes: []string{"10.0.0.0/8", "192.0.2.0/24"}, want: []string{"10.0.0.0/8", "192.0.2.0/24"}},
+		{name: "nested", prefixes: []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32"}, want: []string{"10.0.0.0/8"}},
+		{name: "halves", prefixes: []string{"192.0.2.0/25", "192.0.2.128/25"}, want: []string{"192.0.2.0/24"}},
+		{name: "quarters", prefixes: []string{"192.0.2.0/26", "192.0.2.64/26", "192.0.2.128/26", "192.0.2.192/26"}, want: []string{"192.0.2.0/24"}},
+		{name: "halves that are not siblings", prefixes: []string{"192.0.2.128/25", "192.0.3.0/25"}, want: []string{"192.0.2.128/25", "192.0.3.0/25"}},
+		{name: "ipv6", prefixes: []string{"2001:db8::/33", "2001:db8:8000::/33", "2001:db8:1::/48"}, want: []string{"2001:db8::/32"}},
+	}
+	for _, test := range tests {
+		prefixes := map[netip.Prefix]bool{}
+		for _, target := range test.prefixes {
+			prefixes[netip.MustParsePrefix(target)] = true
+		}
+		if got := sortedPrefixes(aggregatePrefixes(prefixes)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: aggregatePrefixes = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
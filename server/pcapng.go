@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// pcapng block types and options, see https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+const (
+	pcapngSectionHeader    uint32 = 0x0A0D0D0A
+	pcapngInterface        uint32 = 0x00000001
+	pcapngEnhancedPacket   uint32 = 0x00000006
+	pcapngByteOrderMagic   uint32 = 0x1A2B3C4D
+	pcapngLinkTypeEthernet uint16 = 1
+
+	pcapngOptionEnd       uint16 = 0
+	pcapngOptionComment   uint16 = 1
+	pcapngOptionIfName    uint16 = 2
+	pcapngOptionUserAppl  uint16 = 4
+	pcapngOptionIfTsresol uint16 = 9
+)
+
+// pcapngWriter writes a pcapng section of Ethernet packets with nanosecond timestamps,
+// an interface description block is written before the first packet of every interface
+type pcapngWriter struct {
+	w          io.Writer
+	snaplen    uint32
+	interfaces map[uint32]uint32
+}
+
+// newPcapngWriter writes the section header block
+func newPcapngWriter(w io.Writer, snaplen uint32) (*pcapngWriter, error) {
+	writer := &pcapngWriter{w: w, snaplen: snaplen, interfaces: map[uint32]uint32{}}
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, pcapngByteOrderMagic)
+	binary.Write(&body, binary.LittleEndian, uint16(1))
+	binary.Write(&body, binary.LittleEndian, uint16(0))
+	//the length of the section is not known while it is streamed
+	binary.Write(&body, binary.LittleEndian, int64(-1))
+	pcapngOption(&body, pcapngOptionUserAppl, []byte("goxdp"))
+	pcapngOption(&body, pcapngOptionEnd, nil)
+	return writer, writer.block(pcapngSectionHeader, body.Bytes())
+}
+
+// interfaceID returns the pcapng ID of the interface, its description block is written the first time
+func (writer *pcapngWriter) interfaceID(ifindex uint32, name string) (uint32, error) {
+	if id, ok := writer.interfaces[ifindex]; ok {
+		return id, nil
+	}
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, pcapngLinkTypeEthernet)
+	binary.Write(&body, binary.LittleEndian, uint16(0))
+	binary.Write(&body, binary.LittleEndian, writer.snaplen)
+	pcapngOption(&body, pcapngOptionIfName, []byte(name))
+	//the timestamps are in nanoseconds
+	pcapngOption(&body, pcapngOptionIfTsresol, []byte{9})
+	pcapngOption(&body, pcapngOptionEnd, nil)
+	if err := writer.block(pcapngInterface, body.Bytes()); err != nil {
+		return 0, err
+	}
+	id := uint32(len(writer.interfaces))
+	writer.interfaces[ifindex] = id
+	return id, nil
+}
+
+// writePacket writes the captured bytes of a packet of the original length received at timestamp
+// nanoseconds since the Unix epoch, the comment is left out when it is empty
+func (writer *pcapngWriter) writePacket(ifindex uint32, name string, timestamp uint64, originalLength uint32, data []byte, comment string) error {
+	id, err := writer.interfaceID(ifindex, name)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, id)
+	binary.Write(&body, binary.LittleEndian, uint32(timestamp>>32))
+	binary.Write(&body, binary.LittleEndian, uint32(timestamp))
+	binary.Write(&body, binary.LittleEndian, uint32(len(data)))
+	binary.Write(&body, binary.LittleEndian, originalLength)
+	body.Write(data)
+	body.Write(make([]byte, pcapngPadding(len(data))))
+	if comment != "" {
+		pcapngOption(&body, pcapngOptionComment, []byte(comment))
+	}
+	pcapngOption(&body, pcapngOptionEnd, nil)
+	return writer.block(pcapngEnhancedPacket, body.Bytes())
+}
+
+// block writes a block with its type and total length around the body
+func (writer *pcapngWriter) block(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+	var block bytes.Buffer
+	binary.Write(&block, binary.LittleEndian, blockType)
+	binary.Write(&block, binary.LittleEndian, length)
+	block.Write(body)
+	binary.Write(&block, binary.LittleEndian, length)
+	_, err := writer.w.Write(block.Bytes())
+	return err
+}
+
+// pcapngOption appends an option padded to 32 bits
+func pcapngOption(body *bytes.Buffer, code uint16, value []byte) {
+	binary.Write(body, binary.LittleEndian, code)
+	binary.Write(body, binary.LittleEndian, uint16(len(value)))
+	body.Write(value)
+	body.Write(make([]byte, pcapngPadding(len(value))))
+}
+
+// pcapngPadding returns the bytes needed to align the length to 32 bits
+func pcapngPadding(length int) int {
+	return (4 - length%4) % 4
+}
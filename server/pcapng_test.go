@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// pcapngBlock is a block read back from the output of pcapngWriter
+type pcapngBlock struct {
+	blockType uint32
+	body      []byte
+}
+
+// readPcapngBlocks splits the pcapng output into its blocks and checks their lengths
+func readPcapngBlocks(t *testing.T, data []byte) []pcapngBlock {
+	t.Helper()
+	blocks := []pcapngBlock{}
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block of %d bytes", len(data))
+		}
+		blockType := binary.LittleEndian.Uint32(data)
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatalf("block 0x%x has the invalid length %d", blockType, length)
+		}
+		if trailer := binary.LittleEndian.Uint32(data[length-4:]); trailer != length {
+			t.Fatalf("block 0x%x has the length %d and the trailing length %d", blockType, length, trailer)
+		}
+		blocks = append(blocks, pcapngBlock{blockType: blockType, body: data[8 : length-4]})
+		data = data[length:]
+	}
+	return blocks
+}
+
+// pcapngOptions returns the options of a block body keyed by their code
+func pcapngOptions(t *testing.T, options []byte) map[uint16][]byte {
+	t.Helper()
+	parsed := map[uint16][]byte{}
+	for len(options) >= 4 {
+		code := binary.LittleEndian.Uint16(options)
+		length := int(binary.LittleEndian.Uint16(options[2:]))
+		if code == pcapngOptionEnd {
+			return parsed
+		}
+		if 4+length > len(options) {
+			t.Fatalf("option %d of %d bytes is truncated", code, length)
+		}
+		parsed[code] = options[4 : 4+length]
+		options = options[4+length+pcapngPadding(length):]
+	}
+	t.Fatal("options without opt_endofopt")
+	return nil
+}
+
+func TestPcapngWriter(t *testing.T) {
+	type packet struct {
+		ifindex        uint32
+		name           string
+		timestamp      uint64
+		originalLength uint32
+		data           []byte
+		comment        string
+	}
+	tests := []struct {
+		name       string
+		packets    []packet
+		interfaces []string
+	}{
+		{name: "empty capture"},
+		{
+			name: "single packet",
+			packets: []packet{
+				{ifindex: 2, name: "eth0", timestamp: 1700000000123456789, originalLength: 60, data: bytes.Repeat([]byte{0xab}, 60), comment: "dropped_src by the rule 203.0.113.0/24"},
+			},
+			interfaces: []string{"eth0"},
+		},
+		{
+			name: "truncated packets and unpadded lengths",
+			packets: []packet{
+				{ifindex: 2, name: "eth0", timestamp: 1, originalLength: 1500, data: bytes.Repeat([]byte{1}, 128), comment: "monitored by the rule 10.0.0.0/8"},
+				{ifindex: 2, name: "eth0", timestamp: 2, originalLength: 61, data: bytes.Repeat([]byte{2}, 61)},
+				{ifindex: 2, name: "eth0", timestamp: 3, originalLength: 63, data: bytes.Repeat([]byte{3}, 63), comment: "odd"},
+			},
+			interfaces: []string{"eth0"},
+		},
+		{
+			name: "one description block per interface",
+			packets: []packet{
+				{ifindex: 2, name: "eth0", timestamp: 1, originalLength: 64, data: bytes.Repeat([]byte{1}, 64)},
+				{ifindex: 5, name: "eth1", timestamp: 2, originalLength: 64, data: bytes.Repeat([]byte{2}, 64)},
+				{ifindex: 2, name: "eth0", timestamp: 3, originalLength: 64, data: bytes.Repeat([]byte{3}, 64)},
+			},
+			interfaces: []string{"eth0", "eth1"},
+		},
+	}
+	for _, test := range tests {
+		var output bytes.Buffer
+		writer, err := newPcapngWriter(&output, 128)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range test.packets {
+			err := writer.writePacket(value.ifindex, value.name, value.timestamp, value.originalLength, value.data, value.comment)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		blocks := readPcapngBlocks(t, output.Bytes())
+		if len(blocks) == 0 || blocks[0].blockType != pcapngSectionHeader {
+			t.Fatalf("%s: the output does not start with a section header block", test.name)
+		}
+		if magic := binary.LittleEndian.Uint32(blocks[0].body); magic != pcapngByteOrderMagic {
+			t.Errorf("%s: byte order magic = 0x%x", test.name, magic)
+		}
+		interfaces := []string{}
+		ids := map[uint32]string{}
+		packets := 0
+		for _, block := range blocks[1:] {
+			switch block.blockType {
+			case pcapngInterface:
+				if linkType := binary.LittleEndian.Uint16(block.body); linkType != pcapngLinkTypeEthernet {
+					t.Errorf("%s: link type = %d", test.name, linkType)
+				}
+				if snaplen := binary.LittleEndian.Uint32(block.body[4:]); snaplen != 128 {
+					t.Errorf("%s: snaplen = %d", test.name, snaplen)
+				}
+				options := pcapngOptions(t, block.body[8:])
+				if resolution := options[pcapngOptionIfTsresol]; !bytes.Equal(resolution, []byte{9}) {
+					t.Errorf("%s: timestamp resolution = %v", test.name, resolution)
+				}
+				ids[uint32(len(interfaces))] = string(options[pcapngOptionIfName])
+				interfaces = append(interfaces, string(options[pcapngOptionIfName]))
+			case pcapngEnhancedPacket:
+				if packets >= len(test.packets) {
+					t.Fatalf("%s: more packets than written", test.name)
+				}
+				want := test.packets[packets]
+				packets++
+				id := binary.LittleEndian.Uint32(block.body)
+				if ids[id] != want.name {
+					t.Errorf("%s: packet %d is on the interface %q, want %q", test.name, packets, ids[id], want.name)
+				}
+				timestamp := uint64(binary.LittleEndian.Uint32(block.body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(block.body[8:]))
+				if timestamp != want.timestamp {
+					t.Errorf("%s: packet %d has the timestamp %d, want %d", test.name, packets, timestamp, want.timestamp)
+				}
+				captured := binary.LittleEndian.Uint32(block.body[12:])
+				original := binary.LittleEndian.Uint32(block.body[16:])
+				if int(captured) != len(want.data) || original != want.originalLength {
+					t.Errorf("%s: packet %d has %d of %d bytes, want %d of %d", test.name, packets, captured, original, len(want.data), want.originalLength)
+				}
+				data := block.body[20 : 20+captured]
+				if !bytes.Equal(data, want.data) {
+					t.Errorf("%s: packet %d has other data", test.name, packets)
+				}
+				options := pcapngOptions(t, block.body[20+int(captured)+pcapngPadding(int(captured)):])
+				if comment := string(options[pcapngOptionComment]); comment != want.comment {
+					t.Errorf("%s: packet %d has the comment %q, want %q", test.name, packets, comment, want.comment)
+				}
+			default:
+				t.Errorf("%s: unexpected block type 0x%x", test.name, block.blockType)
+			}
+		}
+		if packets != len(test.packets) {
+			t.Errorf("%s: %d packets, want %d", test.name, packets, len(test.packets))
+		}
+		if len(interfaces) != len(test.interfaces) {
+			t.Errorf("%s: interfaces %v, want %v", test.name, interfaces, test.interfaces)
+			continue
+		}
+		for i := range interfaces {
+			if interfaces[i] != test.interfaces[i] {
+				t.Errorf("%s: interfaces %v, want %v", test.name, interfaces, test.interfaces)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// loadObjects loads the XDP program and its maps sized by MaxRules and MaxStatsEntries, when PinPath is set the maps
+// are pinned under it and the maps pinned by a previous server are reused instead of creating empty ones
+func (app *Application) loadObjects() (*bpfObjects, error) {
+	objs := bpfObjects{}
+	spec, err := loadBpf()
+	if err != nil {
+		return nil, err
+	}
+	err = app.resizeMaps(spec)
+	if err != nil {
+		return nil, err
+	}
+	if app.PinPath == "" {
+		return &objs, spec.LoadAndAssign(&objs, nil)
+	}
+	err = os.MkdirAll(filepath.Join(app.PinPath, "links"), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the pin directory, is bpffs mounted? -> %w", err)
+	}
+	for _, mapSpec := range spec.Maps {
+		mapSpec.Pinning = ebpf.PinByName
+	}
+	err = spec.LoadAndAssign(&objs, &ebpf.CollectionOptions{
+		Maps: ebpf.MapOptions{PinPath: app.PinPath},
+	})
+	if errors.Is(err, ebpf.ErrMapIncompatible) {
+		return nil, fmt.Errorf("the maps pinned in %s were created by an incompatible version or with other -max-rules or -max-stats-entries, "+
+			"remove them to start with empty maps that are filled again from the state file -> %w", app.PinPath, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &objs, nil
+}
+
+// resizeMaps rewrites the capacities compiled in the XDP program with the ones of the server options,
+// the allowed and blocked tries hold MaxRules prefixes and every blocked rule has its own counters
+func (app *Application) resizeMaps(spec *ebpf.CollectionSpec) error {
+	var specs bpfSpecs
+	err := spec.Assign(&specs)
+	if err != nil {
+		return err
+	}
+	for _, mapSpec := range []*ebpf.MapSpec{specs.AllowedIpv4, specs.AllowedIpv6, specs.BlockedIpv4, specs.BlockedIpv6} {
+		mapSpec.MaxEntries = app.MaxRules
+	}
+	//the ID 0 is never handed out
+	specs.RuleStats.MaxEntries = 2*app.MaxRules + 1
+	for _, mapSpec := range []*ebpf.MapSpec{specs.Status, specs.StatusIpv6, specs.Ratelimit} {
+		mapSpec.MaxEntries = app.MaxStatsEntries
+	}
+	return nil
+}
+
+// linkPinPath returns the bpffs path of the XDP link attached to the interface, the links are pinned
+// in a directory named after their XDP mode because bpffs cannot hold other files
+func (app *Application) linkPinPath(iface string, mode string) string {
+	return filepath.Join(app.PinPath, "links", mode, iface)
+}
+
+// pinLink pins the XDP link so that it stays attached after the server exits
+func (app *Application) pinLink(iface string, mode string, l link.Link) error {
+	if app.PinPath == "" {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Join(app.PinPath, "links", mode), 0700)
+	if err != nil {
+		return err
+	}
+	return l.Pin(app.linkPinPath(iface, mode))
+}
+
+// detachLink removes the XDP program from the interface, the pin is removed first
+// because a pinned link stays attached after it is closed
+func (app *Application) detachLink(l link.Link) error {
+	if app.PinPath != "" {
+		err := l.Unpin()
+		if err != nil {
+			return err
+		}
+	}
+	return l.Close()
+}
+
+// restoreLinks fills LoadedInterfaces and InterfaceModes from the XDP links pinned by a previous server
+// and switches them to the XDP program loaded by this server
+func (app *Application) restoreLinks() error {
+	if app.PinPath == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(app.PinPath, "links"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		//the links pinned before the mode was recorded are directly under links
+		if !entry.IsDir() {
+			app.restoreLink(filepath.Join(app.PinPath, "links", entry.Name()), entry.Name(), "")
+			continue
+		}
+		mode := entry.Name()
+		if _, ok := xdpModes[mode]; !ok {
+			continue
+		}
+		links, err := os.ReadDir(filepath.Join(app.PinPath, "links", mode))
+		if err != nil {
+			return err
+		}
+		for _, pinned := range links {
+			app.restoreLink(app.linkPinPath(pinned.Name(), mode), pinned.Name(), mode)
+		}
+	}
+	return nil
+}
+
+// restoreLink takes over the XDP link pinned at path, an empty mode is left out of InterfaceModes
+func (app *Application) restoreLink(path string, iface string, mode string) {
+	l, err := link.LoadPinnedLink(path, nil)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot load the pinned XDP link of %s -> %v", iface, err)
+		return
+	}
+	//the interface was removed while the server was down
+	if _, err := net.InterfaceByName(iface); err != nil {
+		app.InfoLog.Printf("Interface %s does not exist anymore, removing its pinned XDP link", iface)
+		if err := app.detachLink(l); err != nil {
+			app.ErrorLog.Printf("Cannot remove the pinned XDP link of %s -> %v", iface, err)
+		}
+		return
+	}
+	err = l.Update(app.BpfObjects.Firewall)
+	if err != nil {
+		app.ErrorLog.Printf("Cannot update the XDP program attached to %s, the previous program keeps running -> %v", iface, err)
+	}
+	app.LoadedInterfaces[iface] = l
+	if mode != "" {
+		app.InterfaceModes[iface] = mode
+	}
+	app.InfoLog.Printf("XDP is still attached to the interface %s", iface)
+}
@@ -7,7 +7,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	// "net/http"
+	"net/http"
 )
 
 func (app *Application) privateRouter() *chi.Mux {
@@ -26,15 +26,56 @@ func (app *Application) privateRouter() *chi.Mux {
 	chiRouter.Use(middleware.CleanPath)
 	chiRouter.Use(middleware.RealIP)
 	chiRouter.Use(middleware.RedirectSlashes)
-	chiRouter.Post("/load", app.xdpLoad)
-	chiRouter.Post("/unload", app.xdpUnload)
-	chiRouter.Post("/block", app.xdpBlock)
-	chiRouter.Get("/status", app.xdpStatus)
-	chiRouter.Post("/flushblocked", app.xdpBlockedFlush)
-	chiRouter.Post("/flushstatus", app.xdpStatusFlush)
+	chiRouter.Use(clientCertificate)
+	chiRouter.Use(app.authenticate(chiRouter))
+	chiRouter.Use(app.validateRequest(chiRouter))
+	//RPC style routes kept as deprecated aliases of the /v1 API
+	chiRouter.With(deprecated("/v1/interfaces")).Post("/load", app.xdpLoad)
+	chiRouter.With(deprecated("/v1/interfaces")).Post("/unload", app.xdpUnload)
+	chiRouter.With(deprecated("/v1/rules")).Post("/block", app.xdpBlock)
+	chiRouter.With(deprecated("/v1/rules")).Post("/block/batch", app.xdpBlockBatch)
+	chiRouter.With(deprecated("/v1/stats")).Get("/status", app.xdpStatus)
+	chiRouter.With(deprecated("/v1/rules")).Post("/flushblocked", app.xdpBlockedFlush)
+	chiRouter.Post("/rules/optimize", app.rulesOptimize)
+	chiRouter.With(deprecated("/v1/stats")).Post("/flushstatus", app.xdpStatusFlush)
+	chiRouter.Get("/allow-list", app.xdpAllowList)
+	chiRouter.Post("/allow-list", app.xdpAllowListAdd)
+	chiRouter.Delete("/allow-list", app.xdpAllowListRemove)
+	chiRouter.Post("/flushallowed", app.xdpAllowedFlush)
+	chiRouter.Get("/audit", app.auditQuery)
+	chiRouter.Get("/events", app.eventsStream)
+	chiRouter.Get("/capture", app.captureDrops)
+	chiRouter.Get("/openapi.json", app.openAPI)
+	chiRouter.Route("/v1", func(r chi.Router) {
+		r.Get("/rules", app.apiRulesList)
+		r.Post("/rules", app.apiRulesCreate)
+		//the CIDR of a rule holds a slash, so it is matched as the rest of the path
+		r.Get("/rules/*", app.apiRuleGet)
+		r.Patch("/rules/*", app.apiRuleUpdate)
+		r.Delete("/rules/*", app.apiRuleDelete)
+		r.Get("/interfaces", app.apiInterfacesList)
+		r.Get("/interfaces/{name}", app.apiInterfaceGet)
+		r.Put("/interfaces/{name}", app.apiInterfacePut)
+		r.Delete("/interfaces/{name}", app.apiInterfaceDelete)
+		r.Get("/stats", app.apiStats)
+		r.Delete("/stats", app.apiStatsFlush)
+		r.Get("/settings", app.apiSettingsGet)
+		r.Patch("/settings", app.apiSettingsUpdate)
+	})
 	return chiRouter
 }
 
+// deprecated marks the responses of a route that is replaced by the successor route of the /v1 API
+func deprecated(successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			response.Header().Set("Deprecation", "true")
+			response.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+			next.ServeHTTP(response, request)
+		})
+	}
+}
+
 func (app *Application) publicRouter() *chi.Mux {
 	// Create non-global registry.
 	reg := prometheus.NewRegistry()
@@ -43,10 +84,12 @@ func (app *Application) publicRouter() *chi.Mux {
 	reg.MustRegister(
 		collectors.NewGoCollector(),
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newFirewallCollector(app, app.MetricsMaxAddresses, app.MetricsMaxRules),
 	)
 
 	chiRouter := chi.NewRouter()
-	chiRouter.Get("/status", app.xdpStatus)
+	chiRouter.Get("/status", app.xdpPublicStatus)
 	chiRouter.Get("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}).ServeHTTP)
+	chiRouter.Get("/openapi.json", app.openAPI)
 	return chiRouter
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/cilium/ebpf"
+)
+
+// Flags of the settings map, they match the SETTING_ flags of the XDP program
+const (
+	settingAddressStats uint32 = 1 << 0
+	settingSampleDrops  uint32 = 1 << 1
+	settingMonitor      uint32 = 1 << 2
+)
+
+// errRuleIDsFull is returned when every index of the rule_stats map is used by a rule
+var errRuleIDsFull = errors.New("no free rule ID left in the rule_stats map")
+
+// ruleIDs hands out the indexes of the rule_stats map to the blocked prefixes, a prefix keeps its ID
+// while it is blocked so that updating its rule keeps its counters, the ID 0 is never handed out
+type ruleIDs struct {
+	// lock is held around the writes to the blocked maps so that the ID of a prefix matches the one in its rule
+	lock sync.Mutex
+	ids  map[netip.Prefix]uint32
+	free []uint32
+	next uint32
+}
+
+// assign returns the ID of the prefix, fresh is true when the ID was not used by the prefix before
+func (table *ruleIDs) assign(prefix netip.Prefix, maxIDs uint32) (id uint32, fresh bool, err error) {
+	if table.ids == nil {
+		table.ids = map[netip.Prefix]uint32{}
+	}
+	if id, ok := table.ids[prefix]; ok {
+		return id, false, nil
+	}
+	if len(table.free) > 0 {
+		id = table.free[len(table.free)-1]
+		table.free = table.free[:len(table.free)-1]
+	} else {
+		if table.next == 0 {
+			table.next = 1
+		}
+		if table.next >= maxIDs {
+			return 0, false, errRuleIDsFull
+		}
+		id = table.next
+		table.next++
+	}
+	table.ids[prefix] = id
+	return id, true, nil
+}
+
+// release gives the ID of the prefix back once its rule is removed
+func (table *ruleIDs) release(prefix netip.Prefix) {
+	id, ok := table.ids[prefix]
+	if !ok {
+		return
+	}
+	delete(table.ids, prefix)
+	table.free = append(table.free, id)
+}
+
+// assignRuleID sets the ID and the prefix length of the prefix in the rule, the counters of a fresh ID are zeroed
+// because they may hold the packets of the rule that used it before, app.ruleIDs.lock must be held
+func (app *Application) assignRuleID(prefix netip.Prefix, rule *bpfRule) (bool, error) {
+	id, fresh, err := app.ruleIDs.assign(prefix, app.BpfObjects.RuleStats.MaxEntries())
+	if err != nil {
+		return false, err
+	}
+	if fresh {
+		//a shorter slice than the possible CPUs is padded with zero values
+		err = app.BpfObjects.RuleStats.Update(id, []bpfRuleCounters{}, ebpf.UpdateAny)
+		if err != nil {
+			app.ruleIDs.release(prefix)
+			return false, err
+		}
+	}
+	rule.Id = id
+	//the XDP program falls back to the enclosing prefix from it when the rule does not match the packet
+	rule.Prefixlen = uint8(prefix.Bits())
+	return fresh, nil
+}
+
+// loadRuleIDs fills the ID table from the rules already in the blocked maps, the ones pinned by a
+// previous server keep their IDs and counters, rules without an ID or with a duplicated one get a new one
+// and the rules pinned without their prefix length are written again with it
+func (app *Application) loadRuleIDs() error {
+	rules, err := app.blockedRules()
+	if err != nil {
+		return err
+	}
+	app.ruleIDs.lock.Lock()
+	defer app.ruleIDs.lock.Unlock()
+	maxIDs := app.BpfObjects.RuleStats.MaxEntries()
+	app.ruleIDs.ids = map[netip.Prefix]uint32{}
+	used := map[uint32]bool{}
+	var missing []blockedRule
+	for _, value := range rules {
+		id := value.Rule.Id
+		if id == 0 || id >= maxIDs || used[id] {
+			missing = append(missing, value)
+			continue
+		}
+		used[id] = true
+		app.ruleIDs.ids[value.Prefix] = id
+		if id >= app.ruleIDs.next {
+			app.ruleIDs.next = id + 1
+		}
+		if int(value.Rule.Prefixlen) != value.Prefix.Bits() {
+			missing = append(missing, value)
+		}
+	}
+	app.ruleIDs.free = nil
+	for id := uint32(1); id < app.ruleIDs.next; id++ {
+		if !used[id] {
+			app.ruleIDs.free = append(app.ruleIDs.free, id)
+		}
+	}
+	for _, value := range missing {
+		if _, err := app.assignRuleID(value.Prefix, &value.Rule); err != nil {
+			return err
+		}
+		blockedMap, key, err := lpmKey(value.Prefix, app.BpfObjects.BlockedIpv4, app.BpfObjects.BlockedIpv6)
+		if err != nil {
+			return err
+		}
+		if err := blockedMap.Update(key, &value.Rule, ebpf.UpdateExist); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rulePrefixes returns the prefix of every rule ID
+func (app *Application) rulePrefixes() map[uint32]netip.Prefix {
+	app.ruleIDs.lock.Lock()
+	defer app.ruleIDs.lock.Unlock()
+	prefixes := make(map[uint32]netip.Prefix, len(app.ruleIDs.ids))
+	for prefix, id := range app.ruleIDs.ids {
+		prefixes[id] = prefix
+	}
+	return prefixes
+}
+
+// ruleCounters sums the per CPU counters of the rule ID
+func (app *Application) ruleCounters(id uint32) (bpfRuleCounters, error) {
+	var total bpfRuleCounters
+	if id == 0 {
+		return total, nil
+	}
+	val := make([]bpfRuleCounters, runtime.NumCPU())
+	if err := app.BpfObjects.RuleStats.Lookup(id, &val); err != nil {
+		return total, err
+	}
+	for _, value := range val {
+		total.Packets += value.Packets
+		total.Bytes += value.Bytes
+		total.MonitoredPackets += value.MonitoredPackets
+		total.MonitoredBytes += value.MonitoredBytes
+	}
+	return total, nil
+}
+
+// flushRuleCounters zeroes the counters of every blocked rule
+func (app *Application) flushRuleCounters() error {
+	app.ruleIDs.lock.Lock()
+	defer app.ruleIDs.lock.Unlock()
+	for _, id := range app.ruleIDs.ids {
+		err := app.BpfObjects.RuleStats.Update(id, []bpfRuleCounters{}, ebpf.UpdateAny)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySettings writes the server options to the settings map of the XDP program,
+// the dropped packets are only sampled while a capture is running
+func (app *Application) applySettings() error {
+	app.settingsLock.Lock()
+	defer app.settingsLock.Unlock()
+	return app.applySettingsLocked()
+}
+
+// applySettingsLocked is applySettings for callers that already hold settingsLock
+func (app *Application) applySettingsLocked() error {
+	settings := bpfSettings{SampleRate: app.SampleRate}
+	if app.AddressStats > 0 {
+		settings.Flags |= settingAddressStats
+	}
+	if app.capturing.Load() {
+		settings.Flags |= settingSampleDrops
+	}
+	if app.monitor {
+		settings.Flags |= settingMonitor
+	}
+	return app.BpfObjects.Settings.Update(uint32(0), &settings, ebpf.UpdateAny)
+}
+
+// settings returns the settings that can be changed while the service runs
+func (app *Application) settings() settingsOutput {
+	app.settingsLock.Lock()
+	defer app.settingsLock.Unlock()
+	return settingsOutput{Monitor: app.monitor}
+}
+
+// setMonitor puts every rule in monitor mode or back in its own mode, the running mode is kept on error
+func (app *Application) setMonitor(monitor bool) error {
+	app.settingsLock.Lock()
+	defer app.settingsLock.Unlock()
+	previous := app.monitor
+	app.monitor = monitor
+	err := app.applySettingsLocked()
+	if err != nil {
+		app.monitor = previous
+	}
+	return err
+}
+
+// addressStats returns the addresses of the status maps with the most dropped packets, at most AddressStats of them
+func (app *Application) addressStats() []statusMapJson {
+	if app.AddressStats == 0 {
+		return []statusMapJson{}
+	}
+	status := append(app.readStatusMap(app.BpfObjects.Status), app.readStatusMap(app.BpfObjects.StatusIpv6)...)
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Src_packets+status[i].Dst_packets > status[j].Src_packets+status[j].Dst_packets
+	})
+	if len(status) > app.AddressStats {
+		status = status[:app.AddressStats]
+	}
+	return status
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+)
+
+// how long the HTTP servers wait for the running requests when the service stops
+const shutdownTimeout = 10 * time.Second
+
+// shutdown saves the state file and releases the XDP links and maps, when detach is false
+// the pinned XDP links stay attached and keep filtering with the pinned maps
+func (app *Application) shutdown(detach bool) {
+	app.persistState()
+
+	app.interfacesLock.Lock()
+	defer app.interfacesLock.Unlock()
+	for iface, l := range app.LoadedInterfaces {
+		if detach {
+			err := app.detachLink(l)
+			if err != nil {
+				app.ErrorLog.Printf("Cannot remove XDP from the interface %s -> %v", iface, err)
+				continue
+			}
+			app.InfoLog.Printf("XDP is unloaded from the interface %s", iface)
+		} else {
+			if app.PinPath == "" {
+				app.InfoLog.Printf("XDP links are not pinned, XDP is unloaded from the interface %s", iface)
+			}
+			err := l.Close()
+			if err != nil {
+				app.ErrorLog.Printf("Cannot close the XDP link of %s -> %v", iface, err)
+				continue
+			}
+		}
+		delete(app.LoadedInterfaces, iface)
+		delete(app.InterfaceModes, iface)
+	}
+
+	err := app.BpfObjects.Close()
+	if err != nil {
+		app.ErrorLog.Printf("Cannot close the XDP objects -> %v", err)
+	}
+	if app.auditLog != nil {
+		if err := app.auditLog.close(); err != nil {
+			app.ErrorLog.Printf("Cannot close the audit log -> %v", err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// name of the state file inside the state directory
+const stateFileName = "rules.json"
+
+// stateFile is the content of the state file, it holds the rules of the blocked and allowed LPM maps
+type stateFile struct {
+	Blocked []stateRule `json:"blocked"`
+	Allowed []string    `json:"allowed"`
+}
+
+// stateRule is a blocked rule as stored in the state file, the expiry is kept in wall clock time
+// because the monotonic time of the kernel does not survive a reboot
+type stateRule struct {
+	Target     string    `json:"target"`
+	Action     uint8     `json:"action"`
+	Mode       uint8     `json:"mode,omitempty"`
+	Protocol   uint8     `json:"protocol"`
+	SrcPortMin uint16    `json:"src_port_min"`
+	SrcPortMax uint16    `json:"src_port_max"`
+	DstPortMin uint16    `json:"dst_port_min"`
+	DstPortMax uint16    `json:"dst_port_max"`
+	RatePps    uint32    `json:"rate_pps"`
+	RateBytes  uint32    `json:"rate_bytes"`
+	Expires    time.Time `json:"expires"`
+	ruleMetadata
+}
+
+// saveState writes the rules of the blocked and allowed maps to the state file
+func (app *Application) saveState() error {
+	if app.StateDir == "" {
+		return nil
+	}
+	app.stateLock.Lock()
+	defer app.stateLock.Unlock()
+
+	var state stateFile
+	rules, err := app.blockedRules()
+	if err != nil {
+		return err
+	}
+	metadata := app.ruleMetadataOf(rules)
+	for _, value := range rules {
+		saved := stateRule{
+			Target:       value.Prefix.String(),
+			Action:       value.Rule.Action,
+			Mode:         value.Rule.Mode,
+			Protocol:     value.Rule.Protocol,
+			SrcPortMin:   value.Rule.SrcPortMin,
+			SrcPortMax:   value.Rule.SrcPortMax,
+			DstPortMin:   value.Rule.DstPortMin,
+			DstPortMax:   value.Rule.DstPortMax,
+			RatePps:      value.Rule.RatePps,
+			RateBytes:    value.Rule.RateBytes,
+			ruleMetadata: metadata[value.Prefix],
+		}
+		if value.Rule.ExpiresNs != 0 {
+			saved.Expires, err = ruleDeadline(value.Rule.ExpiresNs)
+			if err != nil {
+				return err
+			}
+		}
+		state.Blocked = append(state.Blocked, saved)
+	}
+	allowed, err := app.allowedPrefixes()
+	if err != nil {
+		return err
+	}
+	for _, prefix := range allowed {
+		state.Allowed = append(state.Allowed, prefix.String())
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	//write to a temporary file first so a crash never leaves a half written state file
+	path := filepath.Join(app.StateDir, stateFileName)
+	err = os.WriteFile(path+".tmp", data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// persistState saves the state file and only logs the failure since the maps are already updated
+func (app *Application) persistState() {
+	if err := app.saveState(); err != nil {
+		app.ErrorLog.Printf("Cannot save the rules to the state file -> %v", err)
+	}
+}
+
+// restoreState loads the rules of the state file into the blocked and allowed maps,
+// rules whose timeout finished while the server was down are dropped
+func (app *Application) restoreState() error {
+	if app.StateDir == "" {
+		return nil
+	}
+	err := os.MkdirAll(app.StateDir, 0700)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(app.StateDir, stateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state stateFile
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+
+	restored := 0
+	for _, saved := range state.Blocked {
+		prefix, err := netip.ParsePrefix(saved.Target)
+		if err != nil {
+			app.ErrorLog.Printf("Skipping invalid target %s in the state file -> %v", saved.Target, err)
+			continue
+		}
+		rule := bpfRule{
+			Action:     saved.Action,
+			Mode:       saved.Mode,
+			Protocol:   saved.Protocol,
+			SrcPortMin: saved.SrcPortMin,
+			SrcPortMax: saved.SrcPortMax,
+			DstPortMin: saved.DstPortMin,
+			DstPortMax: saved.DstPortMax,
+			RatePps:    saved.RatePps,
+			RateBytes:  saved.RateBytes,
+		}
+		if !saved.Expires.IsZero() {
+			if time.Now().After(saved.Expires) {
+				continue
+			}
+			rule.ExpiresNs, err = ruleExpiryAt(saved.Expires)
+			if err != nil {
+				return err
+			}
+		}
+		err = app.blockPrefix(prefix, rule)
+		if err != nil {
+			return err
+		}
+		if saved.Origin != "" {
+			app.ruleMetadata[prefix] = saved.ruleMetadata
+		}
+		restored++
+	}
+	for _, target := range state.Allowed {
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			app.ErrorLog.Printf("Skipping invalid target %s in the state file -> %v", target, err)
+			continue
+		}
+		err = app.allowPrefix(prefix)
+		if err != nil {
+			return err
+		}
+	}
+	app.InfoLog.Printf("Restored %d blocked and %d allowed IP addresses or subnets from the state file", restored, len(state.Allowed))
+	return nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"github.com/cilium/ebpf/link"
 	"log"
 	"net/netip"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,6 +16,11 @@ type BpfIpv4LpmKey struct {
 	Target    uint32
 }
 
+type BpfIpv6LpmKey struct {
+	Prefixlen uint32
+	Target    [16]byte
+}
+
 // the Application struct holds the shared data or the data that needs to be used frequently.
 type Application struct {
 	InfoLog          *log.Logger
@@ -19,22 +28,131 @@ type Application struct {
 	BpfObjects       *bpfObjects
 	Interfaces       *[]string
 	LoadedInterfaces map[string]link.Link
-	TimeoutList      map[BpfIpv4LpmKey]time.Time
+	// InterfaceModes holds the XDP mode of the interfaces attached by this service
+	InterfaceModes map[string]string
+	// interfacesLock guards LoadedInterfaces and InterfaceModes
+	interfacesLock sync.Mutex
+	StateDir       string
+	PinPath        string
+	// TokensPath is the file of the API tokens, empty disables the authentication of the private router
+	TokensPath string
+	tokens     map[string]apiToken
+	tokensLock sync.RWMutex
+	// MaxRules is the capacity of each allowed and blocked LPM map and MaxStatsEntries the one of the
+	// status and ratelimit maps, they replace the capacities compiled in the XDP program
+	MaxRules        uint32
+	MaxStatsEntries uint32
+	// AddressStats is how many addresses with the most dropped packets are listed by the status routes,
+	// 0 stops the XDP program from counting the addresses
+	AddressStats int
+	// ruleIDs holds the index of the rule_stats counters of every blocked prefix
+	ruleIDs ruleIDs
+	// SampleRate is the one in N of the packets dropped by every rule sent to the captures
+	SampleRate uint32
+	// monitor makes every rule count the packets it matches without dropping them, it is set by -monitor
+	// and PATCH /v1/settings and guarded by settingsLock, which also serializes the writes of the settings map
+	monitor      bool
+	settingsLock sync.Mutex
+	// captureLock allows a single capture at a time, capturing is set while it runs
+	captureLock sync.Mutex
+	capturing   atomic.Bool
+	// MetricsMaxAddresses caps the addresses exported with their own series by /metrics
+	MetricsMaxAddresses int
+	// MetricsMaxRules caps the blocked rules exported with their own series by /metrics
+	MetricsMaxRules int
+	// events hands the changes and drop rates to the subscribers of GET /events
+	events eventBroker
+	// auditLog records every change of the interfaces and maps, nil when it is disabled
+	auditLog *auditLog
+	// TLS holds the certificate files of the listeners, they serve plain HTTP when it is empty
+	TLS         tlsFiles
+	certificate *tls.Certificate
+	// clientCAs verifies the client certificates of the private listener, guarded by certificateLock with certificate
+	clientCAs       *x509.CertPool
+	certificateLock sync.RWMutex
+	// stateLock serializes the writes to the state file
+	stateLock sync.Mutex
+	// config is what the last reconciled configuration file added, guarded by configLock
+	config     configState
+	configLock sync.Mutex
+	// ruleMetadata holds the comment, owner and origin of the blocked prefixes, guarded by metadataLock
+	ruleMetadata map[netip.Prefix]ruleMetadata
+	metadataLock sync.Mutex
+	feedUpdates  chan []configFeed
+	// the OpenAPI document of the routers and the request schemas checked by the validation middleware
+	openAPIDocument []byte
+	openAPISchemas  *schemaGenerator
+	openAPIRequests map[string]*schema
 	// Is_loaded        bool
 }
 
 // Structs used by xdpLoad and xdpUnload handlers
 type load struct {
-	Mode       *string `json:"mode"`
+	Mode       *string `json:"mode" enum:"nv,skb,hw"`
 	Interfaces *string `json:"interfaces"`
-	Target     *string `json:"target"`
-	Action     *string `json:"action"`
-	Timeout    *uint   `json:"timeout"`
+}
+
+// blockLoad is the body of /block, of the allow list changes and of PATCH /v1/rules, missing fields are nil
+type blockLoad struct {
+	Target   *string `json:"target"`
+	Action   *string `json:"action" enum:"block,ratelimit,allow"`
+	Mode     *string `json:"mode" enum:"enforce,monitor"`
+	Timeout  *uint   `json:"timeout"`
+	Protocol *string `json:"protocol"`
+	SrcPort  *string `json:"src_port"`
+	DstPort  *string `json:"dst_port"`
+	Pps      *uint64 `json:"pps"`
+	Bps      *uint64 `json:"bps"`
+	Comment  *string `json:"comment"`
+	Owner    *string `json:"owner"`
+}
+
+// blockOutput is the body of /block, Note tells when the rule is shadowed by a broader rule or can be
+// merged with its sibling, the rule is written as requested either way
+type blockOutput struct {
+	Target string `json:"target"`
+	Note   string `json:"note,omitempty"`
+}
+
+// ruleEntry is a single rule of the batch block requests and the configuration file
+type ruleEntry struct {
+	Target   string `json:"target" yaml:"target" openapi:"required"`
+	Action   string `json:"action" yaml:"action" enum:"block,ratelimit,allow"`
+	Mode     string `json:"mode" yaml:"mode" enum:"enforce,monitor"`
+	Timeout  uint   `json:"timeout" yaml:"timeout"`
+	Protocol string `json:"protocol" yaml:"protocol"`
+	SrcPort  string `json:"src_port" yaml:"src_port"`
+	DstPort  string `json:"dst_port" yaml:"dst_port"`
+	Pps      uint64 `json:"pps" yaml:"pps"`
+	Bps      uint64 `json:"bps" yaml:"bps"`
+	Comment  string `json:"comment" yaml:"comment"`
+	Owner    string `json:"owner" yaml:"-"`
+}
+
+// Structs used by the xdpBlockBatch handler
+type batchLoad struct {
+	Rules []ruleEntry `json:"rules" openapi:"required"`
+}
+type batchResult struct {
+	Target  string `json:"target"`
+	Status  int    `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+type batchOutput struct {
+	Applied int           `json:"applied"`
+	Failed  int           `json:"failed"`
+	Results []batchResult `json:"results"`
+}
+
+// blockedRule is a single entry of the blocked_ipv4 or blocked_ipv6 LPM maps
+type blockedRule struct {
+	Prefix netip.Prefix
+	Rule   bpfRule
 }
 
 // Structs for XDP status
 type statusMapJson struct {
-	Target          netip.Addr `json:"target"`
+	Target           netip.Addr `json:"target"`
 	Src_packets      uint64     `json:"src_count"`
 	Src_size_packets uint64     `json:"src_bytes_dropped"`
 	Dst_packets      uint64     `json:"dst_count"`
@@ -45,9 +163,74 @@ type statusTimeoutOutput struct {
 	Timeout   string `json:"timeout"`
 	Remaining int    `json:"remaining_time"`
 }
+type statusBlockedOutput struct {
+	Target   string     `json:"target"`
+	Action   string     `json:"action"`
+	Mode     string     `json:"mode" enum:"enforce,monitor"`
+	Pps      uint32     `json:"pps,omitempty"`
+	Bps      uint64     `json:"bps,omitempty"`
+	Protocol string     `json:"protocol"`
+	SrcPort  string     `json:"src_port"`
+	DstPort  string     `json:"dst_port"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	// packets and bytes dropped by the rule, and the ones it would have dropped while it was monitored
+	Packets          uint64 `json:"packets"`
+	Bytes            uint64 `json:"bytes"`
+	MonitoredPackets uint64 `json:"monitored_packets"`
+	MonitoredBytes   uint64 `json:"monitored_bytes"`
+	// ShadowedBy is the broader rule that already handles the packets of the rule for at least as long
+	ShadowedBy string `json:"shadowed_by,omitempty"`
+	// the metadata is nil on the public router
+	*ruleMetadata
+}
+type statusRatelimitOutput struct {
+	Target  netip.Addr `json:"target"`
+	Rule    string     `json:"rule"`
+	Passed  uint64     `json:"passed_packets"`
+	Limited uint64     `json:"limited_packets"`
+}
+
+// statusMapOutput is the body of /status, blocked lists the blocked CIDRs like the first releases did
+// and rules holds their rules and counters
 type statusMapOutput struct {
-	Interfaces []string              `json:"interfaces"`
-	Blocked    []string              `json:"blocked"`
-	Timeout    []statusTimeoutOutput `json:"timeout"`
-	Status     []statusMapJson       `json:"stats"`
+	Interfaces  []string                `json:"interfaces"`
+	Blocked     []string                `json:"blocked"`
+	Rules       []statusBlockedOutput   `json:"rules"`
+	Allowed     []string                `json:"allowed"`
+	Ratelimited []statusRatelimitOutput `json:"ratelimited"`
+	Timeout     []statusTimeoutOutput   `json:"timeout"`
+	Status      []statusMapJson         `json:"stats"`
+	Verdicts    []verdictOutput         `json:"verdicts"`
+	// Monitor is set while every rule is in monitor mode
+	Monitor bool `json:"monitor"`
+}
+
+// settingsOutput holds the settings of the service that can be changed while it runs
+type settingsOutput struct {
+	// Monitor puts every rule in monitor mode whatever its own mode
+	Monitor bool `json:"monitor"`
+}
+
+// settingsPatch is the body of PATCH /v1/settings
+type settingsPatch struct {
+	Monitor *bool `json:"monitor" openapi:"required"`
+}
+
+// verdictOutput holds the verdict counters of an interface, the interfaces whose index is too large
+// for the verdicts map are counted together under the index 0
+type verdictOutput struct {
+	Interface   string       `json:"interface"`
+	Ifindex     uint32       `json:"ifindex"`
+	Passed      verdictCount `json:"passed"`
+	DroppedSrc  verdictCount `json:"dropped_src"`
+	DroppedDst  verdictCount `json:"dropped_dst"`
+	DroppedGre  verdictCount `json:"dropped_gre"`
+	Aborted     verdictCount `json:"aborted"`
+	NonIPPassed verdictCount `json:"non_ip_passed"`
+	// Monitored holds the packets that the rules in monitor mode would have dropped
+	Monitored verdictCount `json:"monitored"`
+}
+type verdictCount struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// tlsFiles are the certificate files of the listeners, they are read again on SIGHUP
+type tlsFiles struct {
+	CertFile string
+	KeyFile  string
+	// ClientCA makes the private listener require client certificates signed by it
+	ClientCA string
+}
+
+// enabled reports whether the listeners serve HTTPS
+func (files tlsFiles) enabled() bool {
+	return files.CertFile != ""
+}
+
+// loadCertificate reads the certificate and key of the listeners and the client CA bundle,
+// the running ones are kept when one of the files cannot be read
+func (app *Application) loadCertificate() error {
+	certificate, err := tls.LoadX509KeyPair(app.TLS.CertFile, app.TLS.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if app.TLS.ClientCA != "" {
+		pem, err := os.ReadFile(app.TLS.ClientCA)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no PEM certificate found in %s", app.TLS.ClientCA)
+		}
+	}
+	app.certificateLock.Lock()
+	app.certificate = &certificate
+	app.clientCAs = clientCAs
+	app.certificateLock.Unlock()
+	app.InfoLog.Printf("Loaded the TLS certificate %s", app.TLS.CertFile)
+	if clientCAs != nil {
+		app.InfoLog.Printf("Loaded the client CA bundle %s", app.TLS.ClientCA)
+	}
+	return nil
+}
+
+// serverTLSConfig returns the TLS configuration of a listener, only the private listener asks for client certificates
+func (app *Application) serverTLSConfig(private bool) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			app.certificateLock.RLock()
+			defer app.certificateLock.RUnlock()
+			return app.certificate, nil
+		},
+	}
+	if private && app.TLS.ClientCA != "" {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		//the client CA bundle is reloaded on SIGHUP, so every handshake takes the current one
+		base := config.Clone()
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			handshake := base.Clone()
+			app.certificateLock.RLock()
+			handshake.ClientCAs = app.clientCAs
+			app.certificateLock.RUnlock()
+			return handshake, nil
+		}
+	}
+	return config
+}
+
+// checkTLSFlags validates the combination of the TLS flags
+func checkTLSFlags(files tlsFiles) error {
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return errors.New("-tls-cert and -tls-key should be passed together")
+	}
+	if files.ClientCA != "" && !files.enabled() {
+		return errors.New("-client-ca needs -tls-cert and -tls-key")
+	}
+	return nil
+}
+
+// certificateIdentity returns the identity of the verified client certificate of the request,
+// its common name or else its first DNS, email, or URI subject alternative name
+func certificateIdentity(request *http.Request) (string, bool) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	certificate := request.TLS.VerifiedChains[0][0]
+	switch {
+	case certificate.Subject.CommonName != "":
+		return certificate.Subject.CommonName, true
+	case len(certificate.DNSNames) > 0:
+		return certificate.DNSNames[0], true
+	case len(certificate.EmailAddresses) > 0:
+		return certificate.EmailAddresses[0], true
+	case len(certificate.URIs) > 0:
+		return certificate.URIs[0].String(), true
+	}
+	return "", false
+}
+
+// clientCertificate puts the identity of the client certificate in the request context,
+// the name of the bearer token replaces it when the request is authenticated with a token
+func clientCertificate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if identity, ok := certificateIdentity(request); ok {
+			request = request.WithContext(context.WithValue(request.Context(), identityKey, identity))
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+// serve starts the server with TLS when the certificate files are set
+func (app *Application) serve(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
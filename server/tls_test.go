@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self signed certificate and its key to the directory and returns the certificate
+func writeCertificate(t *testing.T, dir string, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func TestCheckTLSFlags(t *testing.T) {
+	tests := []struct {
+		files   tlsFiles
+		wantErr bool
+	}{
+		{files: tlsFiles{}},
+		{files: tlsFiles{CertFile: "server.crt", KeyFile: "server.key"}},
+		{files: tlsFiles{CertFile: "server.crt", KeyFile: "server.key", ClientCA: "ca.crt"}},
+		{files: tlsFiles{CertFile: "server.crt"}, wantErr: true},
+		{files: tlsFiles{KeyFile: "server.key"}, wantErr: true},
+		{files: tlsFiles{ClientCA: "ca.crt"}, wantErr: true},
+	}
+	for _, test := range tests {
+		err := checkTLSFlags(test.files)
+		if (err != nil) != test.wantErr {
+			t.Errorf("checkTLSFlags(%+v) returned %v", test.files, err)
+		}
+	}
+}
+
+func TestClientCAReload(t *testing.T) {
+	dir := t.TempDir()
+	writeCertificate(t, dir, "server")
+	first := writeCertificate(t, dir, "first")
+	second := writeCertificate(t, dir, "second")
+	app := &Application{
+		InfoLog:  log.New(io.Discard, "", 0),
+		ErrorLog: log.New(io.Discard, "", 0),
+		TLS: tlsFiles{
+			CertFile: filepath.Join(dir, "server.crt"),
+			KeyFile:  filepath.Join(dir, "server.key"),
+			ClientCA: filepath.Join(dir, "ca.crt"),
+		},
+	}
+	if err := os.Rename(filepath.Join(dir, "first.crt"), app.TLS.ClientCA); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.loadCertificate(); err != nil {
+		t.Fatal(err)
+	}
+	config := app.serverTLSConfig(true)
+	if public := app.serverTLSConfig(false); public.GetConfigForClient != nil || public.ClientAuth != tls.NoClientCert {
+		t.Error("the public listener asks for client certificates")
+	}
+
+	tests := []struct {
+		name   string
+		ca     string
+		trusts *x509.Certificate
+		denies *x509.Certificate
+	}{
+		{name: "initial bundle", trusts: first, denies: second},
+		{name: "rotated bundle", ca: "second.crt", trusts: second, denies: first},
+		{name: "invalid bundle keeps the running one", ca: "server.key", trusts: second, denies: first},
+	}
+	for _, test := range tests {
+		if test.ca != "" {
+			if err := os.Rename(filepath.Join(dir, test.ca), app.TLS.ClientCA); err != nil {
+				t.Fatal(err)
+			}
+			app.loadCertificate()
+		}
+		handshake, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if handshake.ClientAuth != tls.RequireAndVerifyClientCert {
+			t.Errorf("%s: ClientAuth = %v", test.name, handshake.ClientAuth)
+		}
+		options := x509.VerifyOptions{Roots: handshake.ClientCAs}
+		if _, err := test.trusts.Verify(options); err != nil {
+			t.Errorf("%s: %s is not trusted -> %v", test.name, test.trusts.Subject.CommonName, err)
+		}
+		if _, err := test.denies.Verify(options); err == nil {
+			t.Errorf("%s: %s is still trusted", test.name, test.denies.Subject.CommonName)
+		}
+	}
+}
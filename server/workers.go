@@ -1,23 +1,98 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"github.com/cilium/ebpf"
 )
 
-func (app *Application) timeoutWorker(interval int) {
+// timeoutWorker removes the expired rules from the blocked maps until the context is cancelled,
+// the XDP program already ignores them once they expire
+func (app *Application) timeoutWorker(ctx context.Context, interval int) {
 	app.InfoLog.Printf("Starting timeout checker worker with interval of %d", interval)
 	ticker := time.NewTicker(time.Duration(interval) * time.Second)
-	for range ticker.C {
-		currentTime := time.Now()
-		for key, value := range app.TimeoutList {
-			if currentTime.After(value) {
-				err := app.BpfObjects.BlockedIpv4.Delete(&key)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			app.InfoLog.Print("Timeout checker worker stopped")
+			return
+		case <-ticker.C:
+		}
+		currentTime, err := monotonicNow()
+		if err != nil {
+			app.InfoLog.Print("TimeoutWorker error cannot read the monotonic clock -> ", err)
+			continue
+		}
+		rules, err := app.blockedRules()
+		if err != nil {
+			app.InfoLog.Print("TimeoutWorker error cannot read the blocked map -> ", err)
+			continue
+		}
+		removed := false
+		for _, value := range rules {
+			if value.Rule.ExpiresNs != 0 && currentTime >= value.Rule.ExpiresNs {
+				before := app.ruleSnapshot(value.Prefix)
+				err := app.expirePrefix(value.Prefix, value.Rule)
+				//the prefix was blocked again since the maps were read, or unblocked
+				if errors.Is(err, errRuleChanged) || errors.Is(err, ebpf.ErrKeyNotExist) {
+					continue
+				}
+				app.audit(actorTimeoutWorker, auditExpire, value.Prefix.String(), before, nil, err)
 				if err != nil {
-					app.InfoLog.Print("TimeoutWorker error cannot delete the key ", key, " from the blockedIPv4 map -> ", err)
+					app.InfoLog.Print("TimeoutWorker error cannot delete the key ", value.Prefix, " from the blocked map -> ", err)
+					continue
 				}
-				delete(app.TimeoutList, key)
+				app.deleteRuleMetadata(value.Prefix)
+				removed = true
 			}
 		}
+		if removed {
+			app.persistState()
+		}
+	}
+}
 
+// reloadWorker reconciles the configuration file and reloads the tokens file, TLS certificate and client CA on every SIGHUP
+// until the context is cancelled, the listen addresses and workers of the configuration file are only read on start
+func (app *Application) reloadWorker(ctx context.Context, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		if app.TokensPath != "" {
+			if err := app.reloadTokens(); err != nil {
+				app.ErrorLog.Printf("Cannot reload the tokens file, keeping the running tokens -> %v", err)
+			}
+		}
+		if app.TLS.enabled() {
+			if err := app.loadCertificate(); err != nil {
+				app.ErrorLog.Printf("Cannot reload the TLS certificate or client CA, keeping the running ones -> %v", err)
+			}
+		}
+		if path == "" {
+			continue
+		}
+		app.InfoLog.Printf("Reloading the configuration file %s", path)
+		config, err := loadConfig(path)
+		if err != nil {
+			app.ErrorLog.Printf("Cannot reload the configuration file, keeping the running configuration -> %v", err)
+			continue
+		}
+		if err := app.reconcile(config); err != nil {
+			app.ErrorLog.Printf("Cannot apply the configuration file -> %v", err)
+			continue
+		}
+		app.InfoLog.Print("Configuration file reloaded, changes of the listen addresses and workers need a restart")
 	}
 }
@@ -8,10 +8,61 @@
 #include <bpf/bpf_helpers.h>
 #include <linux/if_ether.h>
 #include <linux/ip.h>
+#include <linux/ipv6.h>
+#include <linux/in.h>
+#include <linux/udp.h>
 #include <bpf/bpf_endian.h>
 
+/* Default capacities of the maps, the server replaces them with -max-rules and -max-stats-entries before loading */
 #define MAX_MAP_LPM_ENTRIES 10000
 #define MAX_MAP_HASH_ENTRIES 10000
+/* Every rule of both blocked tries gets its own counters, the ID 0 is never handed out */
+#define MAX_RULE_IDS (MAX_MAP_LPM_ENTRIES + MAX_MAP_HASH_ENTRIES + 1)
+
+/* Enclosing prefixes tried when the rule of the longest prefix does not match the protocol or ports of the packet */
+#define MAX_RULE_FALLBACK 8
+
+/* Returned by the filters when the packet does not match any allowed or blocked prefix */
+#define NO_MATCH -1
+/* Returned by the filters for the packets of an allowed prefix and the ones dropped by the source or destination rule */
+#define FILTER_ALLOWED 0
+#define FILTER_DROP_SRC 1
+#define FILTER_DROP_DST 2
+/* Returned by the filters when only rules in monitor mode matched, the packet passes unless another header is dropped */
+#define FILTER_MONITORED 3
+
+/* Indexes of the verdict counters */
+#define VERDICT_PASSED 0
+#define VERDICT_DROPPED_SRC 1
+#define VERDICT_DROPPED_DST 2
+#define VERDICT_DROPPED_GRE 3
+#define VERDICT_ABORTED 4
+#define VERDICT_NON_IP_PASSED 5
+/* Packets that a rule in monitor mode would have dropped */
+#define VERDICT_MONITORED 6
+#define VERDICT_COUNT 7
+
+/* Interfaces with a larger index share the verdict counters of index 0 */
+#define MAX_VERDICT_IFINDEX 1024
+
+/* Actions of the blocked tries rules */
+#define ACTION_DROP 0
+#define ACTION_RATELIMIT 1
+
+/* Modes of the blocked tries rules, the packets matched by a monitor rule are counted but passed */
+#define RULE_MODE_ENFORCE 0
+#define RULE_MODE_MONITOR 1
+
+#define NSEC_PER_SEC 1000000000ULL
+
+/* Flags of the settings map written by the server */
+#define SETTING_ADDRESS_STATS (1 << 0)
+#define SETTING_SAMPLE_DROPS (1 << 1)
+/* Every rule is handled as if it was in monitor mode */
+#define SETTING_MONITOR (1 << 2)
+
+/* Bytes of the start of the dropped packets copied to the samples */
+#define SAMPLE_SNAPLEN 128
 
 /* Key for lpm_trie */
 union key_4 {
@@ -19,6 +70,47 @@ union key_4 {
 	__u8 b8[8];
 };
 
+/* Key for the IPv6 lpm_trie */
+struct key_6 {
+	__u32 prefixlen;
+	__u8 addr[16];
+};
+
+
+/* Value of the blocked tries, zero fields match any packet */
+struct rule {
+  __u16 src_port_min;
+  __u16 src_port_max;
+  __u16 dst_port_min;
+  __u16 dst_port_max;
+  __u8 protocol;
+  __u8 action;
+  __u8 mode;
+  // Length of the prefix of the rule, the lookup falls back to the enclosing prefix from it
+  __u8 prefixlen;
+  // Limits of the ratelimit action, zero means unlimited
+  __u32 rate_pps;
+  __u32 rate_bytes;
+  // Index of the counters of the rule in the rule_stats map, zero is not counted
+  __u32 id;
+  // bpf_ktime_get_ns time after which the rule is ignored, zero never expires
+  __u64 expires_ns;
+};
+
+/* Key of the token buckets, every rule keeps a bucket per source address, IPv4 addresses are stored IPv4-mapped */
+struct ratelimit_key {
+  __u32 rule_id;
+  __u8 addr[16];
+};
+
+/* Per source token bucket of the ratelimit action, tokens are scaled by NSEC_PER_SEC */
+struct token_bucket {
+  __u64 last_ns;
+  __u64 packet_tokens;
+  __u64 byte_tokens;
+  __u64 passed_packets;
+  __u64 limited_packets;
+};
 
 struct statusMapVal {
   __u64 src_packets;
@@ -27,22 +119,96 @@ struct statusMapVal {
   __u64 dst_size_packets;
 };
 
+/* Value of the single entry of the settings map */
+struct settings {
+  __u32 flags;
+  // One in sample_rate of the packets dropped by every rule is sampled while SETTING_SAMPLE_DROPS is set
+  __u32 sample_rate;
+};
+
+/* Rule that dropped the packet, filled by the filters for the sampling */
+struct drop_info {
+  __u32 rule_id;
+  // Set when the rule or the settings are in monitor mode and the packet should pass
+  __u32 monitored;
+  __u64 rule_packets;
+};
+
+/* Sample of a dropped packet sent to the drop_samples ring buffer */
+struct drop_sample {
+  __u64 timestamp_ns;
+  __u32 ifindex;
+  __u32 rule_id;
+  __u32 packet_size;
+  __u32 captured;
+  __u8 verdict;
+  __u8 data[SAMPLE_SNAPLEN];
+};
+
+/* Packets and bytes dropped by a rule, and the ones it would have dropped in monitor mode */
+struct rule_counters {
+  __u64 packets;
+  __u64 bytes;
+  __u64 monitored_packets;
+  __u64 monitored_bytes;
+};
+
+/* Packets and bytes of every verdict of an interface */
+struct verdict_counters {
+  __u64 packets[VERDICT_COUNT];
+  __u64 bytes[VERDICT_COUNT];
+};
+
 struct grehdr
 {
   __be16 flags;
   __be16 protocol;
 };
 
+/* Layer 4 details of the packet used to match the rules */
+struct l4info {
+  __u8 protocol;
+  __u8 has_ports;
+  __u16 src_port;
+  __u16 dst_port;
+};
 
-/* Map for trie implementation */
+
+/* Allowed prefixes are checked before the blocked ones */
 struct {
 	__uint(type, BPF_MAP_TYPE_LPM_TRIE);
 	__uint(key_size, 8);
 	__uint(value_size, 1);
-	__uint(max_entries, MAX_MAP_HASH_ENTRIES);
+	__uint(max_entries, MAX_MAP_LPM_ENTRIES);
+	__uint(map_flags, BPF_F_NO_PREALLOC);
+} allowed_ipv4 SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_LPM_TRIE);
+	__uint(key_size, 20);
+	__uint(value_size, 1);
+	__uint(max_entries, MAX_MAP_LPM_ENTRIES);
+	__uint(map_flags, BPF_F_NO_PREALLOC);
+} allowed_ipv6 SEC(".maps");
+
+/* Map for trie implementation */
+struct {
+	__uint(type, BPF_MAP_TYPE_LPM_TRIE);
+	__uint(key_size, 8);
+	__type(value, struct rule);
+	__uint(max_entries, MAX_MAP_LPM_ENTRIES);
 	__uint(map_flags, BPF_F_NO_PREALLOC);
 } blocked_ipv4 SEC(".maps");
 
+/* Map for the IPv6 trie implementation */
+struct {
+	__uint(type, BPF_MAP_TYPE_LPM_TRIE);
+	__uint(key_size, 20);
+	__type(value, struct rule);
+	__uint(max_entries, MAX_MAP_LPM_ENTRIES);
+	__uint(map_flags, BPF_F_NO_PREALLOC);
+} blocked_ipv6 SEC(".maps");
+
 struct {
 	//__uint(type, BPF_MAP_TYPE_PERCPU_HASH);
 	__uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
@@ -51,20 +217,462 @@ struct {
 	__type(value, struct statusMapVal);
 } status SEC(".maps");
 
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
+	__uint(max_entries, MAX_MAP_HASH_ENTRIES);
+	__uint(key_size, 16);
+	__type(value, struct statusMapVal);
+} status_ipv6 SEC(".maps");
+
+/* Token buckets keyed by the rule and the source address, the buckets are per CPU so a source spread over
+ * several receive queues can pass the rate of the rule once per CPU */
+struct {
+	__uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
+	__uint(max_entries, MAX_MAP_HASH_ENTRIES);
+	__type(key, struct ratelimit_key);
+	__type(value, struct token_bucket);
+} ratelimit SEC(".maps");
+
+/* Verdict counters keyed by the index of the receiving interface */
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__uint(max_entries, MAX_VERDICT_IFINDEX);
+	__type(key, __u32);
+	__type(value, struct verdict_counters);
+} verdicts SEC(".maps");
+
+/* Counters of the blocked rules keyed by their ID */
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__uint(max_entries, MAX_RULE_IDS);
+	__type(key, __u32);
+	__type(value, struct rule_counters);
+} rule_stats SEC(".maps");
+
+/* Single entry holding the settings written by the server */
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__uint(max_entries, 1);
+	__type(key, __u32);
+	__type(value, struct settings);
+} settings SEC(".maps");
+
+/* Samples of the dropped packets read by the server while it captures */
+struct {
+	__uint(type, BPF_MAP_TYPE_RINGBUF);
+	__uint(max_entries, 1 << 20);
+} drop_samples SEC(".maps");
+
+/* Check if the SETTING_ flag is set by the server */
+static __always_inline int setting_enabled(__u32 flag) {
+  __u32 key = 0;
+  struct settings *config = bpf_map_lookup_elem(&settings, &key);
+  return config != NULL && (config->flags & flag);
+}
+
+/* Count the packet dropped by the rule in its rule_stats counters and remember the rule for the sampling,
+ * the packets of a rule in monitor mode are counted apart and marked to pass */
+static __always_inline void count_rule(struct rule *rule, __u32 packet_size, struct drop_info *drop) {
+  __u32 id = rule->id;
+  drop->rule_id = id;
+  drop->monitored = rule->mode == RULE_MODE_MONITOR || setting_enabled(SETTING_MONITOR);
+  drop->rule_packets = 0;
+  if (id == 0) {
+    return;
+  }
+  struct rule_counters *counters = bpf_map_lookup_elem(&rule_stats, &id);
+  if (counters == NULL) {
+    return;
+  }
+  if (drop->monitored) {
+    counters->monitored_packets += 1;
+    counters->monitored_bytes += packet_size;
+    drop->rule_packets = counters->monitored_packets;
+    return;
+  }
+  counters->packets += 1;
+  counters->bytes += packet_size;
+  drop->rule_packets = counters->packets;
+}
+
+/* Send the first bytes of one in sample_rate of the packets dropped by the rule to the drop_samples ring buffer,
+ * the per CPU counter of the rule picks the packets so that a busy rule does not hide the others. The counter
+ * is not shared between the CPUs, so every CPU samples the first packet of the rule and then one in sample_rate */
+static __always_inline void sample_drop(struct xdp_md *ctx, int verdict, struct drop_info *drop, __u32 packet_size) {
+  __u32 key = 0;
+  struct settings *config = bpf_map_lookup_elem(&settings, &key);
+  if (config == NULL || !(config->flags & SETTING_SAMPLE_DROPS) || config->sample_rate == 0) {
+    return;
+  }
+  if (drop->rule_packets == 0 || (drop->rule_packets - 1) % config->sample_rate != 0) {
+    return;
+  }
+  struct drop_sample *sample = bpf_ringbuf_reserve(&drop_samples, sizeof(*sample), 0);
+  if (sample == NULL) {
+    return;
+  }
+  __u32 captured = packet_size;
+  if (captured > SAMPLE_SNAPLEN) {
+    captured = SAMPLE_SNAPLEN;
+  }
+  // Keep the length provably within the sample for the verifier
+  captured &= 0xff;
+  if (captured == 0 || captured > SAMPLE_SNAPLEN || bpf_xdp_load_bytes(ctx, 0, sample->data, captured) < 0) {
+    bpf_ringbuf_discard(sample, 0);
+    return;
+  }
+  sample->timestamp_ns = bpf_ktime_get_ns();
+  sample->ifindex = ctx->ingress_ifindex;
+  sample->rule_id = drop->rule_id;
+  sample->packet_size = packet_size;
+  sample->captured = captured;
+  sample->verdict = verdict;
+  bpf_ringbuf_submit(sample, 0);
+}
+
+/* Count the packet in the verdict counters of its interface */
+static __always_inline void count_verdict(struct xdp_md *ctx, int verdict, __u32 packet_size) {
+  __u32 ifindex = ctx->ingress_ifindex;
+  if (ifindex >= MAX_VERDICT_IFINDEX) {
+    ifindex = 0;
+  }
+  struct verdict_counters *counters = bpf_map_lookup_elem(&verdicts, &ifindex);
+  if (counters == NULL) {
+    return;
+  }
+  counters->packets[verdict] += 1;
+  counters->bytes[verdict] += packet_size;
+}
+
+/* Parse the TCP or UDP ports that follow the IP header */
+static __always_inline void parse_l4(struct l4info *l4, __u8 protocol, void *l4_start, void *data_end) {
+  l4->protocol = protocol;
+  l4->has_ports = 0;
+  if (protocol != IPPROTO_TCP && protocol != IPPROTO_UDP) {
+    return;
+  }
+  // TCP and UDP both start with the source and destination ports
+  struct udphdr *ports = l4_start;
+  if ((void *)(ports + 1) > data_end) {
+    return;
+  }
+  l4->has_ports = 1;
+  l4->src_port = bpf_ntohs(ports->source);
+  l4->dst_port = bpf_ntohs(ports->dest);
+}
+
+/* Check if the rule is not expired and the packet matches its protocol and port ranges */
+static __always_inline int rule_matches(struct rule *rule, struct l4info *l4) {
+  // Expired rules stay in the trie until the server removes them
+  if (rule->expires_ns != 0 && bpf_ktime_get_ns() >= rule->expires_ns) {
+    return 0;
+  }
+  if (rule->protocol != 0 && rule->protocol != l4->protocol) {
+    return 0;
+  }
+  if (rule->src_port_max != 0) {
+    if (!l4->has_ports || l4->src_port < rule->src_port_min || l4->src_port > rule->src_port_max) {
+      return 0;
+    }
+  }
+  if (rule->dst_port_max != 0) {
+    if (!l4->has_ports || l4->dst_port < rule->dst_port_min || l4->dst_port > rule->dst_port_max) {
+      return 0;
+    }
+  }
+  return 1;
+}
+
+/* Look up the rule of the IPv4 address that matches the packet, when the rule of the longest prefix does not match
+ * its protocol or ports the rule of the enclosing prefix is tried, so a narrower port rule does not hide a broader rule */
+static __always_inline struct rule *lookup_rule_ipv4(union key_4 *key, struct l4info *l4) {
+  union key_4 lookup = *key;
+  for (int i = 0; i < MAX_RULE_FALLBACK; i++) {
+    struct rule *rule = bpf_map_lookup_elem(&blocked_ipv4, &lookup);
+    if (rule == NULL) {
+      return NULL;
+    }
+    if (rule_matches(rule, l4)) {
+      return rule;
+    }
+    if (rule->prefixlen == 0 || rule->prefixlen > 32) {
+      return NULL;
+    }
+    lookup.b32[0] = rule->prefixlen - 1;
+  }
+  return NULL;
+}
+
+/* Look up the rule of the IPv6 address that matches the packet like lookup_rule_ipv4 */
+static __always_inline struct rule *lookup_rule_ipv6(struct key_6 *key, struct l4info *l4) {
+  struct key_6 lookup = *key;
+  for (int i = 0; i < MAX_RULE_FALLBACK; i++) {
+    struct rule *rule = bpf_map_lookup_elem(&blocked_ipv6, &lookup);
+    if (rule == NULL) {
+      return NULL;
+    }
+    if (rule_matches(rule, l4)) {
+      return rule;
+    }
+    if (rule->prefixlen == 0 || rule->prefixlen > 128) {
+      return NULL;
+    }
+    lookup.prefixlen = rule->prefixlen - 1;
+  }
+  return NULL;
+}
+
+/* Refill the token bucket of the rule and the source and take the tokens of the packet, returns 1 if the packet is within
+ * the rate. The source and destination rules of a packet have their own buckets, so each rule spends the packet once */
+static __always_inline int ratelimit_allows(struct rule *rule, __u8 *src_addr, __u32 packet_size) {
+  struct ratelimit_key key = {};
+  key.rule_id = rule->id;
+  __builtin_memcpy(key.addr, src_addr, sizeof(key.addr));
+  __u64 now = bpf_ktime_get_ns();
+  __u64 packet_cost = NSEC_PER_SEC;
+  __u64 byte_cost = (__u64)packet_size * NSEC_PER_SEC;
+  // The bucket holds up to one second worth of tokens
+  __u64 packet_burst = (__u64)rule->rate_pps * NSEC_PER_SEC;
+  __u64 byte_burst = (__u64)rule->rate_bytes * NSEC_PER_SEC;
+
+  struct token_bucket *bucket = bpf_map_lookup_elem(&ratelimit, &key);
+  if (bucket == NULL) {
+    struct token_bucket newBucket = {};
+    newBucket.last_ns = now;
+    newBucket.packet_tokens = packet_burst;
+    newBucket.byte_tokens = byte_burst;
+    bpf_map_update_elem(&ratelimit, &key, &newBucket, BPF_ANY);
+    bucket = bpf_map_lookup_elem(&ratelimit, &key);
+    if (bucket == NULL) {
+      return 1;
+    }
+  }
+
+  __u64 elapsed = now - bucket->last_ns;
+  if (elapsed > NSEC_PER_SEC) {
+    elapsed = NSEC_PER_SEC;
+  }
+  bucket->last_ns = now;
+  bucket->packet_tokens += elapsed * rule->rate_pps;
+  if (bucket->packet_tokens > packet_burst) {
+    bucket->packet_tokens = packet_burst;
+  }
+  bucket->byte_tokens += elapsed * rule->rate_bytes;
+  if (bucket->byte_tokens > byte_burst) {
+    bucket->byte_tokens = byte_burst;
+  }
+
+  if ((rule->rate_pps != 0 && bucket->packet_tokens < packet_cost) ||
+      (rule->rate_bytes != 0 && bucket->byte_tokens < byte_cost)) {
+    bucket->limited_packets += 1;
+    return 0;
+  }
+  if (rule->rate_pps != 0) {
+    bucket->packet_tokens -= packet_cost;
+  }
+  if (rule->rate_bytes != 0) {
+    bucket->byte_tokens -= byte_cost;
+  }
+  bucket->passed_packets += 1;
+  return 1;
+}
+
+/* Count the packet matched by a rule that drops it, returns 1 when the rule is enforced and fills drop with it.
+ * The first rule in monitor mode is kept in monitor instead, so the other rules of the packet are still checked */
+static __always_inline int apply_rule(struct rule *rule, __u32 packet_size, struct drop_info *drop, struct drop_info *monitor) {
+  struct drop_info matched = {};
+  count_rule(rule, packet_size, &matched);
+  if (!matched.monitored) {
+    *drop = matched;
+    return 1;
+  }
+  if (!monitor->monitored) {
+    *monitor = matched;
+  }
+  return 0;
+}
+
+/* Apply the action of a matched rule, returns 1 if the packet should be dropped */
+static __always_inline int rule_drops(struct rule *rule, __u8 *src_addr, __u32 packet_size) {
+  if (rule->action == ACTION_RATELIMIT) {
+    return !ratelimit_allows(rule, src_addr, packet_size);
+  }
+  return 1;
+}
+
+/* Count a dropped packet in the status or status_ipv6 map, the addresses are only counted when the server asks for them */
+static __always_inline void count_status(void *status_map, void *addr, __u32 packet_size, int is_src) {
+  if (!setting_enabled(SETTING_ADDRESS_STATS)) {
+    return;
+  }
+  struct statusMapVal *stats_element = bpf_map_lookup_elem(status_map, addr);
+  if (stats_element != NULL){
+    if (is_src) {
+      stats_element->src_packets += 1;
+      stats_element->src_size_packets += packet_size;
+    } else {
+      stats_element->dst_packets += 1;
+      stats_element->dst_size_packets += packet_size;
+    }
+    return;
+  }
+  struct statusMapVal newData = {};
+  if (is_src) {
+    newData.src_packets = 1;
+    newData.src_size_packets = packet_size;
+  } else {
+    newData.dst_packets = 1;
+    newData.dst_size_packets = packet_size;
+  }
+  bpf_map_update_elem(status_map, addr, &newData, BPF_ANY);
+}
+
+/* Look up the source and destination of the IPv4 packet in the tries, returns the FILTER_ result or NO_MATCH
+ * and fills drop with the rule of the dropped packets and monitor with the first rule in monitor mode */
+static __always_inline int filter_ipv4(struct iphdr *ip, void *data_end, __u32 packet_size, struct drop_info *drop, struct drop_info *monitor) {
+  struct l4info l4 = {};
+  // Non first fragments do not carry the layer 4 header
+  if (ip->ihl >= 5 && !(ip->frag_off & bpf_htons(0x1FFF))) {
+    parse_l4(&l4, ip->protocol, (void *)ip + ip->ihl * 4, data_end);
+  } else {
+    l4.protocol = ip->protocol;
+  }
+
+  union key_4 srcKey;
+    /* Look up in the trie for lpm */
+  srcKey.b32[0] = 32;
+  srcKey.b8[4] = ip->saddr & 0xff;
+  srcKey.b8[5] = (ip->saddr >> 8) & 0xff;
+  srcKey.b8[6] = (ip->saddr >> 16) & 0xff;
+  srcKey.b8[7] = (ip->saddr >> 24) & 0xff;
+  union key_4 dstKey;
+    /* Look up in the trie for lpm */
+  dstKey.b32[0] = 32;
+  dstKey.b8[4] = ip->daddr & 0xff;
+  dstKey.b8[5] = (ip->daddr >> 8) & 0xff;
+  dstKey.b8[6] = (ip->daddr >> 16) & 0xff;
+  dstKey.b8[7] = (ip->daddr >> 24) & 0xff;
+
+  // Allowed prefixes override the blocked ones
+  if (bpf_map_lookup_elem(&allowed_ipv4, &srcKey) != NULL || bpf_map_lookup_elem(&allowed_ipv4, &dstKey) != NULL) {
+    return FILTER_ALLOWED;
+  }
+
+  // The ratelimit token buckets are keyed by the IPv4-mapped source address
+  __u8 src_addr[16] = {};
+  src_addr[10] = 0xff;
+  src_addr[11] = 0xff;
+  __builtin_memcpy(&src_addr[12], &ip->saddr, 4);
+
+  struct rule *src_rule = lookup_rule_ipv4(&srcKey, &l4);
+  if (src_rule != NULL && rule_drops(src_rule, src_addr, packet_size)){
+    __be32 ip_src_addr = (*ip).saddr;
+    count_status(&status, &ip_src_addr, packet_size, 1);
+    if (apply_rule(src_rule, packet_size, drop, monitor)) {
+      return FILTER_DROP_SRC;
+    }
+  }
+  struct rule *dst_rule = lookup_rule_ipv4(&dstKey, &l4);
+  if (dst_rule != NULL && rule_drops(dst_rule, src_addr, packet_size)){
+    __be32 ip_dst_addr = (*ip).daddr;
+    count_status(&status, &ip_dst_addr, packet_size, 0);
+    if (apply_rule(dst_rule, packet_size, drop, monitor)) {
+      return FILTER_DROP_DST;
+    }
+  }
+  return monitor->monitored ? FILTER_MONITORED : NO_MATCH;
+}
+
+/* Look up the source and destination of the IPv6 packet in the tries, returns the FILTER_ result or NO_MATCH
+ * and fills drop with the rule of the dropped packets and monitor with the first rule in monitor mode */
+static __always_inline int filter_ipv6(struct ipv6hdr *ip6, void *data_end, __u32 packet_size, struct drop_info *drop, struct drop_info *monitor) {
+  struct l4info l4 = {};
+  // Extension headers are not followed, so only the first next header is matched
+  parse_l4(&l4, ip6->nexthdr, (void *)(ip6 + 1), data_end);
+
+  struct key_6 srcKey;
+  srcKey.prefixlen = 128;
+  __builtin_memcpy(srcKey.addr, &ip6->saddr, sizeof(srcKey.addr));
+  struct key_6 dstKey;
+  dstKey.prefixlen = 128;
+  __builtin_memcpy(dstKey.addr, &ip6->daddr, sizeof(dstKey.addr));
+
+  // Allowed prefixes override the blocked ones
+  if (bpf_map_lookup_elem(&allowed_ipv6, &srcKey) != NULL || bpf_map_lookup_elem(&allowed_ipv6, &dstKey) != NULL) {
+    return FILTER_ALLOWED;
+  }
+
+  struct rule *src_rule = lookup_rule_ipv6(&srcKey, &l4);
+  if (src_rule != NULL && rule_drops(src_rule, srcKey.addr, packet_size)){
+    count_status(&status_ipv6, &ip6->saddr, packet_size, 1);
+    if (apply_rule(src_rule, packet_size, drop, monitor)) {
+      return FILTER_DROP_SRC;
+    }
+  }
+  struct rule *dst_rule = lookup_rule_ipv6(&dstKey, &l4);
+  if (dst_rule != NULL && rule_drops(dst_rule, srcKey.addr, packet_size)){
+    count_status(&status_ipv6, &ip6->daddr, packet_size, 0);
+    if (apply_rule(dst_rule, packet_size, drop, monitor)) {
+      return FILTER_DROP_DST;
+    }
+  }
+  return monitor->monitored ? FILTER_MONITORED : NO_MATCH;
+}
+
+/* Count and sample a packet that a rule in monitor mode would have dropped, returns its XDP action */
+static __always_inline int monitor_verdict(struct xdp_md *ctx, struct drop_info *drop, __u32 packet_size) {
+  count_verdict(ctx, VERDICT_MONITORED, packet_size);
+  sample_drop(ctx, VERDICT_MONITORED, drop, packet_size);
+  return XDP_PASS;
+}
+
+/* Count the result of the outer header filter and return its XDP action */
+static __always_inline int filter_verdict(struct xdp_md *ctx, int result, struct drop_info *drop, struct drop_info *monitor, __u32 packet_size) {
+  if (result == FILTER_MONITORED) {
+    return monitor_verdict(ctx, monitor, packet_size);
+  }
+  if (result == FILTER_DROP_SRC) {
+    count_verdict(ctx, VERDICT_DROPPED_SRC, packet_size);
+    sample_drop(ctx, VERDICT_DROPPED_SRC, drop, packet_size);
+    return XDP_DROP;
+  }
+  if (result == FILTER_DROP_DST) {
+    count_verdict(ctx, VERDICT_DROPPED_DST, packet_size);
+    sample_drop(ctx, VERDICT_DROPPED_DST, drop, packet_size);
+    return XDP_DROP;
+  }
+  count_verdict(ctx, VERDICT_PASSED, packet_size);
+  return XDP_PASS;
+}
+
 SEC("xdp")
 int firewall(struct xdp_md *ctx){
     void *data = (void *)(long)ctx->data;
     void *data_end = (void *)(long)ctx->data_end;
     __u32 packet_size = ctx->data_end-ctx->data;
+    struct drop_info drop = {};
+    struct drop_info monitor = {};
     // We need to parse the ethernet header
     struct ethhdr *ether = data;
     // Check if the Ethernet header is malformed
     if (data + sizeof(*ether) > data_end) {
+      count_verdict(ctx, VERDICT_ABORTED, packet_size);
       return XDP_ABORTED;
     }
     //Ethernet header is not malformed
+    if (ether->h_proto == bpf_htons(ETH_P_IPV6)) {
+      //parse the IPv6 packet
+      struct ipv6hdr *ip6 = data + sizeof(*ether);
+      // Check if the IPv6 header is malformed
+      if ((void *)(ip6 + 1) > data_end) {
+        count_verdict(ctx, VERDICT_ABORTED, packet_size);
+        return XDP_ABORTED;
+      }
+      return filter_verdict(ctx, filter_ipv6(ip6, data_end, packet_size, &drop, &monitor), &drop, &monitor, packet_size);
+    }
     if (ether->h_proto != bpf_htons(ETH_P_IP)) { 
     // If not IPv4 Traffic, pass the packet
+      count_verdict(ctx, VERDICT_NON_IP_PASSED, packet_size);
       return XDP_PASS;
     }
     //move data pointer to pass the ethernet header
@@ -73,59 +681,14 @@ int firewall(struct xdp_md *ctx){
     struct iphdr *ip = data;
     // Check if the IPv4 header is malformed
     if (data + sizeof(*ip) > data_end) {
+      count_verdict(ctx, VERDICT_ABORTED, packet_size);
       return XDP_ABORTED;
     }
 
-		union key_4 srcKey;
-			/* Look up in the trie for lpm */
-		srcKey.b32[0] = 32;
-		srcKey.b8[4] = ip->saddr & 0xff;
-		srcKey.b8[5] = (ip->saddr >> 8) & 0xff;
-		srcKey.b8[6] = (ip->saddr >> 16) & 0xff;
-		srcKey.b8[7] = (ip->saddr >> 24) & 0xff;
-    
-    __u32 *src_pointer = bpf_map_lookup_elem(&blocked_ipv4, &srcKey);
-    if (src_pointer != NULL){
-      __be32 ip_src_addr = (*ip).saddr;
-      struct statusMapVal *stats_element = bpf_map_lookup_elem(&status, &ip_src_addr);  
-      if (stats_element != NULL){
-          stats_element->src_packets += 1;
-          stats_element->src_size_packets += packet_size;
-      } else {
-        struct statusMapVal newData;
-        newData.src_packets = 1;
-        newData.src_size_packets = packet_size;
-        newData.dst_packets = 0;
-        newData.dst_size_packets = 0;
-        bpf_map_update_elem(&status, &ip_src_addr, &newData, BPF_ANY);
-
-      }
-      return XDP_DROP;
-    }
-		union key_4 dstKey;
-			/* Look up in the trie for lpm */
-		dstKey.b32[0] = 32;
-		dstKey.b8[4] = ip->daddr & 0xff;
-		dstKey.b8[5] = (ip->daddr >> 8) & 0xff;
-		dstKey.b8[6] = (ip->daddr >> 16) & 0xff;
-		dstKey.b8[7] = (ip->daddr >> 24) & 0xff;
-    
-    __u32 *dst_pointer = bpf_map_lookup_elem(&blocked_ipv4, &dstKey);
-    if (dst_pointer != NULL){
-      __be32 ip_dst_addr = (*ip).daddr;
-      struct statusMapVal *stats_element = bpf_map_lookup_elem(&status, &ip_dst_addr);  
-      if (stats_element != NULL){
-          stats_element->dst_packets += 1;
-          stats_element->dst_size_packets += packet_size;
-      } else {
-        struct statusMapVal newData;
-        newData.src_packets = 0;
-        newData.src_size_packets = 0;
-        newData.dst_packets = 1;
-        newData.dst_size_packets = packet_size;
-        bpf_map_update_elem(&status, &ip_dst_addr, &newData, BPF_ANY);
-      }
-      return XDP_DROP;
+    int result = filter_ipv4(ip, data_end, packet_size, &drop, &monitor);
+    // A packet matched only by rules in monitor mode is still dropped by an enforced rule of its inner GRE header
+    if (result != NO_MATCH && result != FILTER_MONITORED) {
+      return filter_verdict(ctx, result, &drop, &monitor, packet_size);
     }
 
     if (ip && ip->protocol == 47) { // Protocol 47: GRE
@@ -133,6 +696,7 @@ int firewall(struct xdp_md *ctx){
 
       // Validate GRE Header
       if (unlikely((void *)(greh + 1) > data_end)) {
+        count_verdict(ctx, VERDICT_DROPPED_GRE, packet_size);
         return XDP_DROP;
       }
 
@@ -141,59 +705,19 @@ int firewall(struct xdp_md *ctx){
 
       // Validate next protocol header
       if (unlikely((void *)(ip + 1) > data_end)) {
+        count_verdict(ctx, VERDICT_DROPPED_GRE, packet_size);
         return XDP_DROP;
       }
-      union key_4 srcKey;
-        /* Look up in the trie for lpm */
-      srcKey.b32[0] = 32;
-      srcKey.b8[4] = ip->saddr & 0xff;
-      srcKey.b8[5] = (ip->saddr >> 8) & 0xff;
-      srcKey.b8[6] = (ip->saddr >> 16) & 0xff;
-      srcKey.b8[7] = (ip->saddr >> 24) & 0xff;
-      
-      __u32 *src_pointer = bpf_map_lookup_elem(&blocked_ipv4, &srcKey);
-      if (src_pointer != NULL){
-        __be32 ip_src_addr = (*ip).saddr;
-        struct statusMapVal *stats_element = bpf_map_lookup_elem(&status, &ip_src_addr);  
-        if (stats_element != NULL){
-            stats_element->src_packets += 1;
-            stats_element->src_size_packets += packet_size;
-        } else {
-          struct statusMapVal newData;
-          newData.src_packets = 1;
-          newData.src_size_packets = packet_size;
-          newData.dst_packets = 0;
-          newData.dst_size_packets = 0;
-          bpf_map_update_elem(&status, &ip_src_addr, &newData, BPF_ANY);
-
-        }
-        return XDP_DROP;
-      }
-      union key_4 dstKey;
-        /* Look up in the trie for lpm */
-      dstKey.b32[0] = 32;
-      dstKey.b8[4] = ip->daddr & 0xff;
-      dstKey.b8[5] = (ip->daddr >> 8) & 0xff;
-      dstKey.b8[6] = (ip->daddr >> 16) & 0xff;
-      dstKey.b8[7] = (ip->daddr >> 24) & 0xff;
-      
-      __u32 *dst_pointer = bpf_map_lookup_elem(&blocked_ipv4, &dstKey);
-      if (dst_pointer != NULL){
-        __be32 ip_dst_addr = (*ip).daddr;
-        struct statusMapVal *stats_element = bpf_map_lookup_elem(&status, &ip_dst_addr);  
-        if (stats_element != NULL){
-            stats_element->dst_packets += 1;
-            stats_element->dst_size_packets += packet_size;
-        } else {
-          struct statusMapVal newData;
-          newData.src_packets = 0;
-          newData.src_size_packets = 0;
-          newData.dst_packets = 1;
-          newData.dst_size_packets = packet_size;
-          bpf_map_update_elem(&status, &ip_dst_addr, &newData, BPF_ANY);
-        }
+      int inner = filter_ipv4(ip, data_end, packet_size, &drop, &monitor);
+      if (inner == FILTER_DROP_SRC || inner == FILTER_DROP_DST) {
+        count_verdict(ctx, VERDICT_DROPPED_GRE, packet_size);
+        sample_drop(ctx, VERDICT_DROPPED_GRE, &drop, packet_size);
         return XDP_DROP;
       }
     }
+    if (monitor.monitored) {
+      return monitor_verdict(ctx, &monitor, packet_size);
+    }
+    count_verdict(ctx, VERDICT_PASSED, packet_size);
     return XDP_PASS;
-} 
\ No newline at end of file
+}